@@ -1,7 +1,6 @@
 package appstoreserverapi
 
 import (
-	"bytes"
 	"encoding/json"
 	"net/http"
 )
@@ -9,15 +8,31 @@ import (
 // ApiExtendAsubscriptionRenewalDate 延长订阅续订日期
 // Extend a Subscription Renewal Date
 // doc: https://developer.apple.com/documentation/appstoreserverapi/extend_a_subscription_renewal_date
-func (c *client) ApiExtendAsubscriptionRenewalDate(transactionId string, req ExtendRenewalDateRequest) (*ExtendRenewalDateResponse, error) {
-	reqUrl := c.apiExtendASubscriptionRenewalDateUrl + transactionId
+func (c *client) ApiExtendAsubscriptionRenewalDate(transactionId string, req ExtendRenewalDateRequest, opts ...CallOption) (*ExtendRenewalDateResponse, error) {
+	o := c.newCallOptions(opts)
+	span := c.startSpan(&o, endpointExtendASubscriptionRenewalDate)
+	result, err := c.extendAsubscriptionRenewalDate(transactionId, req, o)
+	span.End(err)
+	return result, err
+}
+
+func (c *client) extendAsubscriptionRenewalDate(transactionId string, req ExtendRenewalDateRequest, o callOptions) (*ExtendRenewalDateResponse, error) {
+	reqUri := apiExtendASubscriptionRenewalDateUri + transactionId
 	b, _ := json.Marshal(req)
-	r, err := c.doRequest(http.MethodPut, reqUrl, bytes.NewReader(b))
+	r, e, err := c.doRequest(&apiRequest{
+		endpoint: endpointExtendASubscriptionRenewalDate,
+		method:   http.MethodPut,
+		uri:      reqUri,
+		id:       transactionId,
+		opts:     o,
+		body:     b,
+	})
 	if err != nil {
 		return nil, err
 	}
 	result := &ExtendRenewalDateResponse{
 		raw:                   r.String(),
+		env:                   e,
 		EffectiveDate:         r.Get("effectiveDate").Int(),
 		OriginalTransactionId: r.Get("originalTransactionId").String(),
 		Success:               r.Get("success").Bool(),
@@ -46,6 +61,7 @@ type ExtendRenewalDateRequest struct {
 
 type ExtendRenewalDateResponse struct {
 	raw                   string
+	env                   Env
 	EffectiveDate         int64  `json:"effectiveDate"`
 	OriginalTransactionId string `json:"originalTransactionId"`
 	Success               bool   `json:"success"`
@@ -55,3 +71,9 @@ type ExtendRenewalDateResponse struct {
 func (r *ExtendRenewalDateResponse) Raw() string {
 	return r.raw
 }
+
+// Env 返回结果的环境
+// The environment that answered
+func (r *ExtendRenewalDateResponse) Env() Env {
+	return r.env
+}
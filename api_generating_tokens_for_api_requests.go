@@ -9,7 +9,6 @@ import (
 	"github.com/lestrrat-go/jwx/v2/jws"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 	"io"
-	"strings"
 	"time"
 )
 
@@ -29,31 +28,36 @@ import (
 // 应用的BundleID（例如：“com.example.testbundleid2021”)
 // Bundle ID: Your app’s bundle ID (Ex: “com.example.testbundleid2021”)
 // pk:
-// 签名的秘钥
-// sign key
+// 签名的秘钥，设置了 Key 时使用 Key
+// sign key, Key is used instead when set
 func SignJwt(cfg *Config) (string, error) {
-	iat := time.Now()
-	cfg.exp = iat.Add(cfg.ExpiryIn)
+	token, _, err := signJwt(cfg, cfg.signingKeys()[0], cfg.now())
+	return token, err
+}
+
+// signJwt 使用指定的秘钥签名并返回过期时间，不修改 cfg
+func signJwt(cfg *Config, key SigningKey, iat time.Time) (string, time.Time, error) {
+	exp := iat.Add(cfg.ExpiryIn)
 
-	jwt.Settings(jwt.WithFlattenAudience(true))
 	j := jwt.New()
+	// 只对这个 token 扁平化 aud，不修改 jwx 的全局设置
+	j.Options().Enable(jwt.FlattenAudience)
 	j.Set(jwt.IssuerKey, cfg.Iss)
 	j.Set(jwt.IssuedAtKey, iat)
-	j.Set(jwt.ExpirationKey, cfg.exp)
+	j.Set(jwt.ExpirationKey, exp)
 	j.Set(jwt.AudienceKey, cfg.Aud)
 	j.Set("bid", cfg.Bid)
 
 	headers := jws.NewHeaders()
-	headers.Set("kid", cfg.Kid)
+	headers.Set("kid", key.Kid)
 
-	reader := strings.NewReader(cfg.Pk)
-	prikey, err := privateKeyFromReader(reader)
+	signer, err := key.keyProvider().Signer()
 	if err != nil {
-		return "", err
+		return "", exp, err
 	}
 
-	signed, err := jwt.Sign(j, jwt.WithKey(jwa.ES256, prikey, jws.WithProtectedHeaders(headers)))
-	return string(signed), err
+	signed, err := jwt.Sign(j, jwt.WithKey(jwa.ES256, signer, jws.WithProtectedHeaders(headers)))
+	return string(signed), exp, err
 }
 
 var (
@@ -62,16 +66,10 @@ var (
 	ErrPrivateKeyNotECDSA      = errors.New("pk must be of ECDSA type")
 )
 
-func privateKeyFromReader(rd *strings.Reader) (*ecdsa.PrivateKey, error) {
-	b := make([]byte, rd.Len())
-	for {
-		_, err := rd.Read(b)
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return nil, err
-		}
+func privateKeyFromReader(rd io.Reader) (*ecdsa.PrivateKey, error) {
+	b, err := io.ReadAll(rd)
+	if err != nil {
+		return nil, err
 	}
 	by, _ := pem.Decode(b)
 	if by == nil {
@@ -1,15 +1,42 @@
 package appstoreserverapi
 
 import (
+	"fmt"
 	"net/http"
 )
 
-// ApiGetAllSubscriptionStatuses 获取所有的订阅状态
-// Get All Subscription Statuses
+// ApiGetAllSubscriptionStatuses 获取所有的订阅状态，设置了 Config.StatusCache 时返回缓存结果的副本
+// Get All Subscription Statuses, with Config.StatusCache set a copy of the cached result is returned
 // doc: https://developer.apple.com/documentation/appstoreserverapi/get_all_subscription_statuses
-func (c *client) ApiGetAllSubscriptionStatuses(transactionId string) (*StatusResponse, error) {
-	reqUrl := c.apiGetAllSubscriptionStatusesUrl + transactionId
-	r, err := c.doRequest(http.MethodGet, reqUrl, nil)
+func (c *client) ApiGetAllSubscriptionStatuses(transactionId string, opts ...CallOption) (*StatusResponse, error) {
+	o := c.newCallOptions(opts)
+	span := c.startSpan(&o, endpointGetAllSubscriptionStatuses)
+	result, err := c.getAllSubscriptionStatuses(transactionId, o)
+	span.End(err)
+	return result, err
+}
+
+func (c *client) getAllSubscriptionStatuses(transactionId string, o callOptions) (*StatusResponse, error) {
+	cacheKey := StatusCacheKey{BundleId: c.cfg.Bid, Environment: c.cfg.Evn, SandboxFallback: o.sandboxFallback, TransactionId: transactionId}
+	var generation uint64
+	if c.cfg.StatusCache != nil {
+		if !o.noCache {
+			if result, ok := c.cfg.StatusCache.Get(cacheKey); ok {
+				return result.clone(), nil
+			}
+		}
+		// 请求进行中收到通知时，Set 不写入这次的结果
+		generation = c.cfg.StatusCache.Generation()
+	}
+	reqUri := apiGetAllSubscriptionStatusesUri + transactionId
+	r, e, err := c.doRequest(&apiRequest{
+		endpoint:   endpointGetAllSubscriptionStatuses,
+		method:     http.MethodGet,
+		uri:        reqUri,
+		id:         transactionId,
+		opts:       o,
+		generation: generation,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -17,30 +44,37 @@ func (c *client) ApiGetAllSubscriptionStatuses(transactionId string) (*StatusRes
 	// decode
 	result := &StatusResponse{
 		raw:         r.String(),
+		env:         e,
 		Environment: r.Get("environment").String(),
 		BundleId:    r.Get("bundleId").String(),
 		AppAppleId:  r.Get("appAppleId").Int(),
 	}
 
+	d := c.newDecoder(o.ctx, endpointGetAllSubscriptionStatuses)
 	datas := make([]StatusData, 0)
 
-	for _, item := range r.Get("data").Array() {
+	for i, item := range r.Get("data").Array() {
 		data := StatusData{
 			SubscriptionGroupIdentifier: item.Get("subscriptionGroupIdentifier").String(),
 		}
 		lastTransactions := make([]LastTransaction, 0)
-		for _, val := range item.Get("lastTransactions").Array() {
+		for j, val := range item.Get("lastTransactions").Array() {
 			lastTransaction := LastTransaction{
 				OriginalTransactionId: val.Get("originalTransactionId").String(),
 				Status:                val.Get("status").Int(),
 			}
+			path := fmt.Sprintf("data.%d.lastTransactions.%d", i, j)
 			jWSTransactionDecodedPayload := JWSTransactionDecodedPayload{}
-			Parse(val.Get("signedTransactionInfo").String(), &jWSTransactionDecodedPayload)
+			if !d.decodeAt(i, j, path+".signedTransactionInfo", val.Get("signedTransactionInfo").String(), &jWSTransactionDecodedPayload) {
+				continue
+			}
 			lastTransaction.SignedTransactionInfo = jWSTransactionDecodedPayload
 
+			// 续订信息解码失败时保留交易，部分模式下 SignedRenewalInfo 为零值
 			jWSRenewalInfoDecodedPayload := JWSRenewalInfoDecodedPayload{}
-			Parse(val.Get("signedRenewalInfo").String(), &jWSRenewalInfoDecodedPayload)
-			lastTransaction.SignedRenewalInfo = jWSRenewalInfoDecodedPayload
+			if d.decodeAt(i, j, path+".signedRenewalInfo", val.Get("signedRenewalInfo").String(), &jWSRenewalInfoDecodedPayload) {
+				lastTransaction.SignedRenewalInfo = jWSRenewalInfoDecodedPayload
+			}
 
 			lastTransactions = append(lastTransactions, lastTransaction)
 		}
@@ -50,11 +84,31 @@ func (c *client) ApiGetAllSubscriptionStatuses(transactionId string) (*StatusRes
 
 	result.Data = datas
 
-	return result, nil
+	discard, err := d.result()
+	if discard {
+		return nil, err
+	}
+	transactions := make([]JWSTransactionDecodedPayload, 0)
+	renewals := make([]JWSRenewalInfoDecodedPayload, 0)
+	for _, data := range datas {
+		for _, lastTransaction := range data.LastTransactions {
+			transactions = append(transactions, lastTransaction.SignedTransactionInfo)
+			if lastTransaction.SignedRenewalInfo.OriginalTransactionId != "" {
+				renewals = append(renewals, lastTransaction.SignedRenewalInfo)
+			}
+		}
+	}
+	err = c.writeThrough(transactions, renewals, err)
+	// 部分解码失败或写入存储失败的结果不缓存，下次调用重新写入
+	if c.cfg.StatusCache != nil && err == nil {
+		c.cfg.StatusCache.Set(cacheKey, result.clone(), generation)
+	}
+	return result, err
 }
 
 type StatusResponse struct {
 	raw         string
+	env         Env
 	Environment string       `json:"environment"`
 	BundleId    string       `json:"bundleId"`
 	AppAppleId  int64        `json:"appAppleId"`
@@ -73,7 +127,24 @@ type LastTransaction struct {
 	SignedRenewalInfo     JWSRenewalInfoDecodedPayload `json:"signedRenewalInfo"`
 }
 
+// clone 复制结果，缓存中的结果不会被调用方修改
+func (r *StatusResponse) clone() *StatusResponse {
+	cp := *r
+	cp.Data = make([]StatusData, len(r.Data))
+	for i, data := range r.Data {
+		cp.Data[i] = data
+		cp.Data[i].LastTransactions = append([]LastTransaction(nil), data.LastTransactions...)
+	}
+	return &cp
+}
+
 // Raw 返回原始结果
 func (r *StatusResponse) Raw() string {
 	return r.raw
 }
+
+// Env 返回结果的环境
+// The environment that answered
+func (r *StatusResponse) Env() Env {
+	return r.env
+}
@@ -1,6 +1,7 @@
 package appstoreserverapi
 
 import (
+	"fmt"
 	"net/http"
 	"sort"
 	"strings"
@@ -10,22 +11,47 @@ import (
 // Get Refund History
 // doc: https://developer.apple.com/documentation/appstoreserverapi/get_refund_history
 // desc: true then signedTransactions order by webOrderLineItemId desc
-func (c *client) ApiGetRefundHistory(transactionId string, desc bool) (*RefundLookupResponse, error) {
-	reqUrl := c.apiGetRefundHistoryUrl + transactionId
-	r, err := c.doRequest(http.MethodGet, reqUrl, nil)
+func (c *client) ApiGetRefundHistory(transactionId string, desc bool, opts ...CallOption) (*RefundLookupResponse, error) {
+	o := c.newCallOptions(opts)
+	span := c.startSpan(&o, endpointGetRefundHistory)
+	result, err := c.getRefundHistory(transactionId, desc, o)
+	span.End(err)
+	return result, err
+}
+
+func (c *client) getRefundHistory(transactionId string, desc bool, o callOptions) (*RefundLookupResponse, error) {
+	reqUri := apiGetRefundHistoryUri + transactionId + o.query()
+	r, e, err := c.doRequest(&apiRequest{
+		endpoint: endpointGetRefundHistory,
+		method:   http.MethodGet,
+		uri:      reqUri,
+		id:       transactionId,
+		opts:     o,
+	})
 	if err != nil {
 		return nil, err
 	}
 	result := &RefundLookupResponse{
-		raw: r.String(),
+		raw:      r.String(),
+		env:      e,
+		Revision: r.Get("revision").String(),
+		HasMore:  r.Get("hasMore").Bool(),
 	}
 
+	d := c.newDecoder(o.ctx, endpointGetRefundHistory)
 	signedTransactions := make([]JWSTransactionDecodedPayload, 0)
-	for _, item := range r.Get("signedTransactions").Array() {
+	for i, item := range r.Get("signedTransactions").Array() {
 		signedTransaction := JWSTransactionDecodedPayload{}
-		Parse(item.String(), &signedTransaction)
+		if !d.decode(i, fmt.Sprintf("signedTransactions.%d", i), item.String(), &signedTransaction) {
+			continue
+		}
 		signedTransactions = append(signedTransactions, signedTransaction)
 	}
+	discard, err := d.result()
+	if discard {
+		return nil, err
+	}
+	err = c.writeThrough(signedTransactions, nil, err)
 
 	if desc {
 		sort.SliceStable(signedTransactions, func(i, j int) bool {
@@ -38,14 +64,23 @@ func (c *client) ApiGetRefundHistory(transactionId string, desc bool) (*RefundLo
 
 	result.SignedTransactions = signedTransactions
 
-	return result, nil
+	return result, err
 }
 
 type RefundLookupResponse struct {
 	raw                string
+	env                Env
+	Revision           string                         `json:"revision"`
+	HasMore            bool                           `json:"hasMore"`
 	SignedTransactions []JWSTransactionDecodedPayload `json:"signedTransactions"`
 }
 
 func (r *RefundLookupResponse) Raw() string {
 	return r.raw
 }
+
+// Env 返回结果的环境
+// The environment that answered
+func (r *RefundLookupResponse) Env() Env {
+	return r.env
+}
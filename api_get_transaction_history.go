@@ -1,6 +1,7 @@
 package appstoreserverapi
 
 import (
+	"fmt"
 	"net/http"
 	"sort"
 	"strings"
@@ -10,14 +11,29 @@ import (
 // Get Transaction History
 // doc: https://developer.apple.com/documentation/appstoreserverapi/get_transaction_history
 // desc: true then signedTransactions order by webOrderLineItemId desc
-func (c *client) ApiGetTransactionHistory(transactionId string, desc bool) (*HistoryResponse, error) {
-	reqUrl := c.apiGetTransactionHistoryUrl + transactionId
-	r, err := c.doRequest(http.MethodGet, reqUrl, nil)
+func (c *client) ApiGetTransactionHistory(transactionId string, desc bool, opts ...CallOption) (*HistoryResponse, error) {
+	o := c.newCallOptions(opts)
+	span := c.startSpan(&o, endpointGetTransactionHistory)
+	result, err := c.getTransactionHistory(transactionId, desc, o)
+	span.End(err)
+	return result, err
+}
+
+func (c *client) getTransactionHistory(transactionId string, desc bool, o callOptions) (*HistoryResponse, error) {
+	reqUri := apiGetTransactionHistoryUri + transactionId + o.query()
+	r, e, err := c.doRequest(&apiRequest{
+		endpoint: endpointGetTransactionHistory,
+		method:   http.MethodGet,
+		uri:      reqUri,
+		id:       transactionId,
+		opts:     o,
+	})
 	if err != nil {
 		return nil, err
 	}
 	result := &HistoryResponse{
 		raw:         r.String(),
+		env:         e,
 		Revision:    r.Get("revision").String(),
 		BundleId:    r.Get("bundleId").String(),
 		AppAppleId:  r.Get("appAppleId").Int(),
@@ -25,12 +41,20 @@ func (c *client) ApiGetTransactionHistory(transactionId string, desc bool) (*His
 		HasMore:     r.Get("hasMore").Bool(),
 	}
 
+	d := c.newDecoder(o.ctx, endpointGetTransactionHistory)
 	signedTransactions := make([]JWSTransactionDecodedPayload, 0)
-	for _, item := range r.Get("signedTransactions").Array() {
+	for i, item := range r.Get("signedTransactions").Array() {
 		signedTransaction := JWSTransactionDecodedPayload{}
-		Parse(item.String(), &signedTransaction)
+		if !d.decode(i, fmt.Sprintf("signedTransactions.%d", i), item.String(), &signedTransaction) {
+			continue
+		}
 		signedTransactions = append(signedTransactions, signedTransaction)
 	}
+	discard, err := d.result()
+	if discard {
+		return nil, err
+	}
+	err = c.writeThrough(signedTransactions, nil, err)
 
 	if desc {
 		sort.SliceStable(signedTransactions, func(i, j int) bool {
@@ -43,11 +67,12 @@ func (c *client) ApiGetTransactionHistory(transactionId string, desc bool) (*His
 
 	result.SignedTransactions = signedTransactions
 
-	return result, nil
+	return result, err
 }
 
 type HistoryResponse struct {
 	raw                string
+	env                Env
 	Revision           string                         `json:"revision"`
 	BundleId           string                         `json:"bundleId"`
 	AppAppleId         int64                          `json:"appAppleId"`
@@ -59,3 +84,9 @@ type HistoryResponse struct {
 func (r *HistoryResponse) Raw() string {
 	return r.raw
 }
+
+// Env 返回结果的环境
+// The environment that answered
+func (r *HistoryResponse) Env() Env {
+	return r.env
+}
@@ -1,36 +1,61 @@
 package appstoreserverapi
 
 import (
+	"fmt"
 	"net/http"
 )
 
 // ApiLookUpOrderId 查找订单 ID
 // Look Up Order ID
 // doc: https://developer.apple.com/documentation/appstoreserverapi/look_up_order_id
-func (c *client) ApiLookUpOrderId(orderId string) (*OrderLookupResponse, error) {
-	reqUrl := c.apiLookupOrderIdUrl + orderId
-	r, err := c.doRequest(http.MethodGet, reqUrl, nil)
+func (c *client) ApiLookUpOrderId(orderId string, opts ...CallOption) (*OrderLookupResponse, error) {
+	o := c.newCallOptions(opts)
+	span := c.startSpan(&o, endpointLookUpOrderId)
+	result, err := c.lookUpOrderId(orderId, o)
+	span.End(err)
+	return result, err
+}
+
+func (c *client) lookUpOrderId(orderId string, o callOptions) (*OrderLookupResponse, error) {
+	reqUri := apiLookupOrderIdUri + orderId
+	r, e, err := c.doRequest(&apiRequest{
+		endpoint: endpointLookUpOrderId,
+		method:   http.MethodGet,
+		uri:      reqUri,
+		id:       orderId,
+		opts:     o,
+	})
 	if err != nil {
 		return nil, err
 	}
 	result := &OrderLookupResponse{
 		raw:    r.String(),
+		env:    e,
 		Status: r.Get("status").Int(),
 	}
 
+	d := c.newDecoder(o.ctx, endpointLookUpOrderId)
 	signedTransactions := make([]JWSTransactionDecodedPayload, 0)
-	for _, item := range r.Get("signedTransactions").Array() {
+	for i, item := range r.Get("signedTransactions").Array() {
 		signedTransaction := JWSTransactionDecodedPayload{}
-		Parse(item.String(), &signedTransaction)
+		if !d.decode(i, fmt.Sprintf("signedTransactions.%d", i), item.String(), &signedTransaction) {
+			continue
+		}
 		signedTransactions = append(signedTransactions, signedTransaction)
 	}
+	discard, err := d.result()
+	if discard {
+		return nil, err
+	}
+	err = c.writeThrough(signedTransactions, nil, err)
 	result.SignedTransactions = signedTransactions
 
-	return result, nil
+	return result, err
 }
 
 type OrderLookupResponse struct {
 	raw    string
+	env    Env
 	Status int64 `json:"status"`
 	// 原始数据
 	SignedTransactions []JWSTransactionDecodedPayload `json:"signedTransactions"`
@@ -39,3 +64,9 @@ type OrderLookupResponse struct {
 func (r *OrderLookupResponse) Raw() string {
 	return r.raw
 }
+
+// Env 返回结果的环境
+// The environment that answered
+func (r *OrderLookupResponse) Env() Env {
+	return r.env
+}
@@ -1,7 +1,6 @@
 package appstoreserverapi
 
 import (
-	"bytes"
 	"encoding/json"
 	"net/http"
 )
@@ -9,10 +8,25 @@ import (
 // ApiSendConsumptionInformation 发送消费信息
 // Send Consumption Information
 // doc: https://developer.apple.com/documentation/appstoreserverapi/send_consumption_information
-func (c *client) ApiSendConsumptionInformation(transactionId string, req ConsumptionRequest) error {
-	reqUrl := c.apiSendConsumptionInformationUrl + transactionId
+func (c *client) ApiSendConsumptionInformation(transactionId string, req ConsumptionRequest, opts ...CallOption) error {
+	o := c.newCallOptions(opts)
+	span := c.startSpan(&o, endpointSendConsumptionInformation)
+	err := c.sendConsumptionInformation(transactionId, req, o)
+	span.End(err)
+	return err
+}
+
+func (c *client) sendConsumptionInformation(transactionId string, req ConsumptionRequest, o callOptions) error {
+	reqUri := apiSendConsumptionInformationUri + transactionId
 	b, _ := json.Marshal(req)
-	_, err := c.doRequest(http.MethodPut, reqUrl, bytes.NewReader(b))
+	_, _, err := c.doRequest(&apiRequest{
+		endpoint: endpointSendConsumptionInformation,
+		method:   http.MethodPut,
+		uri:      reqUri,
+		id:       transactionId,
+		opts:     o,
+		body:     b,
+	})
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,126 @@
+package appstoreserverapi
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+)
+
+// AuditEntry 一次修改 Apple 数据的调用的审计记录，例如 ApiExtendAsubscriptionRenewalDate、ApiSendConsumptionInformation
+// The audit entry of a call changing customer state at Apple, eg: ApiExtendAsubscriptionRenewalDate, ApiSendConsumptionInformation
+type AuditEntry struct {
+	Time time.Time `json:"time"`
+	// 操作人，用 WithActor 设置到 WithContext 传入的 context 中
+	// The actor, set with WithActor on the context passed with WithContext
+	Actor         string `json:"actor,omitempty"`
+	Endpoint      string `json:"endpoint"`
+	Environment   Env    `json:"environment"`
+	TransactionId string `json:"transactionId"`
+	// 请求体
+	// The request body
+	Request json.RawMessage `json:"request,omitempty"`
+	// 响应体，失败或没有响应体时为空
+	// The response body, empty on failure or when there is none
+	Response json.RawMessage `json:"response,omitempty"`
+	// Apple 返回的 errorCode，没有时为 0
+	// The errorCode returned by Apple, 0 when none
+	ErrorCode int    `json:"errorCode,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Auditor 保存审计记录，每次修改 Apple 数据的调用（无论成功失败）结束后调用
+// 返回的错误只记录到日志，不影响调用的结果，因为 Apple 的数据可能已经修改
+// Keeps audit entries, called after each call changing customer state at Apple, whether it succeeded or not
+// a returned error is only logged and does not change the result of the call, since Apple may already have applied it
+type Auditor interface {
+	Audit(entry AuditEntry) error
+}
+
+type actorKey struct{}
+
+// WithActor 在 context 中设置操作人，用于审计记录
+// Sets the actor on a context, used by audit entries
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext 读取 WithActor 设置的操作人
+// Reads the actor set with WithActor
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// audit 记录一次修改 Apple 数据的调用
+func (c *client) audit(ar *apiRequest, e Env, raw string, err error) {
+	if c.cfg.Auditor == nil {
+		return
+	}
+	entry := AuditEntry{
+		Time:          c.cfg.now(),
+		Actor:         ActorFromContext(ar.opts.ctx),
+		Endpoint:      ar.endpoint,
+		Environment:   e,
+		TransactionId: ar.id,
+	}
+	if json.Valid(ar.body) {
+		entry.Request = json.RawMessage(ar.body)
+	}
+	if raw != "" && json.Valid([]byte(raw)) {
+		entry.Response = json.RawMessage(raw)
+	}
+	if err != nil {
+		entry.Error = err.Error()
+		if appErr, ok := err.(AppError); ok {
+			entry.ErrorCode = appErr.ErrorCode()
+		}
+	}
+	if auditErr := c.cfg.Auditor.Audit(entry); auditErr != nil {
+		c.logger.Log(LevelError, "audit failed",
+			Field{FieldEndpoint, ar.endpoint},
+			Field{FieldTransactionId, ar.id},
+			Field{FieldError, auditErr.Error()},
+		)
+	}
+}
+
+// FileAuditor 把审计记录追加到 JSONL 文件，每条一行，每次写入后调用 fsync
+// Appends audit entries to a JSONL file, one per line, each write is followed by fsync
+type FileAuditor struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+// NewFileAuditor 打开或创建审计文件
+// Opens or creates the audit file
+func NewFileAuditor(path string) (*FileAuditor, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &FileAuditor{file: file}, nil
+}
+
+func (a *FileAuditor) Audit(entry AuditEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if _, err := a.file.Write(b); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+// Close 关闭审计文件
+// Closes the audit file
+func (a *FileAuditor) Close() error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return a.file.Close()
+}
@@ -0,0 +1,79 @@
+package appstoreserverapi_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/lhlyu/appstoreserverapi"
+	"github.com/lhlyu/appstoreserverapi/storetest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestClient_Audit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	auditor, err := appstoreserverapi.NewFileAuditor(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditor.Close()
+	s, client := newStoreTestClient(t, func(cfg *appstoreserverapi.Config) {
+		cfg.TryCount = 1
+		cfg.Auditor = auditor
+	}, storetest.MonthlySubscription("8000", "monthly", time.Now()))
+
+	ctx := appstoreserverapi.WithContext(appstoreserverapi.WithActor(context.Background(), "alice@example.com"))
+	req := appstoreserverapi.ExtendRenewalDateRequest{ExtendByDays: 7, ExtendReasonCode: 1, RequestIdentifier: "req-1"}
+	if _, err := client.ApiExtendAsubscriptionRenewalDate("8000", req, ctx); err != nil {
+		t.Fatal(err)
+	}
+	s.InjectError(storetest.EndpointSendConsumptionInformation, 400, 4000006, 1)
+	if err := client.ApiSendConsumptionInformation("8000", appstoreserverapi.ConsumptionRequest{CustomerConsented: true}, ctx); err == nil {
+		t.Fatal("expected the injected error")
+	}
+	// 查询不记录审计
+	if _, err := client.ApiGetAllSubscriptionStatuses("8000"); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	entries := make([]appstoreserverapi.AuditEntry, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		entry := appstoreserverapi.AuditEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("entries = %d, want 2", len(entries))
+	}
+
+	extend := entries[0]
+	if extend.Actor != "alice@example.com" || extend.Endpoint != "ExtendASubscriptionRenewalDate" || extend.TransactionId != "8000" {
+		t.Errorf("extend entry = %+v", extend)
+	}
+	sent := appstoreserverapi.ExtendRenewalDateRequest{}
+	if err := json.Unmarshal(extend.Request, &sent); err != nil || sent != req {
+		t.Errorf("request = %s, err = %v", extend.Request, err)
+	}
+	resp := appstoreserverapi.ExtendRenewalDateResponse{}
+	if err := json.Unmarshal(extend.Response, &resp); err != nil || !resp.Success {
+		t.Errorf("response = %s, err = %v", extend.Response, err)
+	}
+	if extend.Time.IsZero() || extend.Error != "" {
+		t.Errorf("extend entry = %+v", extend)
+	}
+
+	consumption := entries[1]
+	if consumption.Endpoint != "SendConsumptionInformation" || consumption.ErrorCode != 4000006 || consumption.Error == "" || consumption.Response != nil {
+		t.Errorf("consumption entry = %+v", consumption)
+	}
+}
@@ -0,0 +1,168 @@
+package appstoreserverapi
+
+import (
+	"context"
+	"sync"
+)
+
+// 批量调用的默认并发数
+const defaultBatchConcurrency = 4
+
+// BatchOptions 批量调用的选项
+// Options of a batch call
+type BatchOptions struct {
+	// 并发数：同时进行的请求数量，默认 4；请求仍然受 Config.RateLimiter 限制
+	// Concurrency: how many requests run at once, defaults to 4; requests are still limited by Config.RateLimiter
+	Concurrency int
+	// 保持顺序：为 true 时结果按传入 ID 的顺序返回，否则按完成的顺序返回
+	// Ordered: when true results come in the order of the IDs, otherwise in the order they finish
+	Ordered bool
+	// 每次调用的选项，批量调用的 ctx 已经通过 WithContext 传入
+	// Options passed to each call, the ctx of the batch is already passed with WithContext
+	CallOptions []CallOption
+}
+
+// OrderLookupResult 批量查找订单 ID 的一个结果
+// One result of BatchLookUpOrderId
+type OrderLookupResult struct {
+	// 在传入的 ID 中的位置
+	// Position among the IDs passed in
+	Index    int
+	OrderId  string
+	Response *OrderLookupResponse
+	Err      error
+}
+
+// StatusResult 批量获取订阅状态的一个结果
+// One result of BatchGetAllSubscriptionStatuses
+type StatusResult struct {
+	// 在传入的 ID 中的位置
+	// Position among the IDs passed in
+	Index         int
+	TransactionId string
+	Response      *StatusResponse
+	Err           error
+}
+
+// BatchLookUpOrderId 批量查找订单 ID，每个 ID 返回一个结果，全部返回后关闭 channel
+// ctx 取消后不再发起新的请求，未开始的 ID 的 Err 为 ctx.Err()；调用方必须读完 channel
+// Looks up many order IDs, one result per ID, the channel is closed after the last one
+// once ctx is done no new request is started, IDs not started get ctx.Err(); the caller must drain the channel
+func BatchLookUpOrderId(ctx context.Context, client Client, orderIds []string, opts BatchOptions) <-chan OrderLookupResult {
+	results := make(chan OrderLookupResult)
+	callOpts := opts.callOptions(ctx)
+	go func() {
+		defer close(results)
+		runBatch(ctx, orderIds, opts, func(orderId string) (interface{}, error) {
+			return client.ApiLookUpOrderId(orderId, callOpts...)
+		}, func(item batchItem) {
+			r, _ := item.value.(*OrderLookupResponse)
+			results <- OrderLookupResult{Index: item.index, OrderId: item.id, Response: r, Err: item.err}
+		})
+	}()
+	return results
+}
+
+// BatchGetAllSubscriptionStatuses 批量获取订阅状态，每个 ID 返回一个结果，全部返回后关闭 channel
+// ctx 取消后不再发起新的请求，未开始的 ID 的 Err 为 ctx.Err()；调用方必须读完 channel
+// Gets the subscription statuses of many transaction IDs, one result per ID, the channel is closed after the last one
+// once ctx is done no new request is started, IDs not started get ctx.Err(); the caller must drain the channel
+func BatchGetAllSubscriptionStatuses(ctx context.Context, client Client, transactionIds []string, opts BatchOptions) <-chan StatusResult {
+	results := make(chan StatusResult)
+	callOpts := opts.callOptions(ctx)
+	go func() {
+		defer close(results)
+		runBatch(ctx, transactionIds, opts, func(transactionId string) (interface{}, error) {
+			return client.ApiGetAllSubscriptionStatuses(transactionId, callOpts...)
+		}, func(item batchItem) {
+			r, _ := item.value.(*StatusResponse)
+			results <- StatusResult{Index: item.index, TransactionId: item.id, Response: r, Err: item.err}
+		})
+	}()
+	return results
+}
+
+// callOptions 每次调用的选项，ctx 在前，可以被 CallOptions 中的 WithContext 覆盖
+func (opts BatchOptions) callOptions(ctx context.Context) []CallOption {
+	return append([]CallOption{WithContext(ctx)}, opts.CallOptions...)
+}
+
+type batchItem struct {
+	index int
+	id    string
+	value interface{}
+	err   error
+}
+
+// runBatch 最多 opts.Concurrency 个请求同时调用 call，emit 在同一个 goroutine 中依次调用，全部完成后返回
+func runBatch(ctx context.Context, ids []string, opts BatchOptions, call func(id string) (interface{}, error), emit func(item batchItem)) {
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = defaultBatchConcurrency
+	}
+
+	done := make(chan batchItem)
+	go func() {
+		fanOut(ctx, len(ids), concurrency, func(i int) {
+			item := batchItem{index: i, id: ids[i]}
+			item.value, item.err = call(ids[i])
+			done <- item
+		}, func(i int, err error) {
+			done <- batchItem{index: i, id: ids[i], err: err}
+		})
+		close(done)
+	}()
+
+	if !opts.Ordered {
+		for item := range done {
+			emit(item)
+		}
+		return
+	}
+	// 先完成的结果暂存，等前面的都返回后再按顺序返回
+	pending := make(map[int]batchItem)
+	next := 0
+	for item := range done {
+		pending[item.index] = item
+		for {
+			item, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			emit(item)
+			next++
+		}
+	}
+}
+
+// fanOut 最多 concurrency 个 goroutine 同时对 [0, n) 中的下标调用 run，全部返回后返回
+// ctx 取消后不再开始新的下标，未开始的下标调用 skip；Syncer、Reconciler 和批量调用共用
+func fanOut(ctx context.Context, n, concurrency int, run func(i int), skip func(i int, err error)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	sem := make(chan struct{}, concurrency)
+	wg := sync.WaitGroup{}
+	for i := 0; i < n; i++ {
+		if err := ctx.Err(); err != nil {
+			skip(i, err)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			skip(i, ctx.Err())
+			continue
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			run(i)
+		}(i)
+	}
+	wg.Wait()
+}
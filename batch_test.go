@@ -0,0 +1,77 @@
+package appstoreserverapi_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/lhlyu/appstoreserverapi"
+	"github.com/lhlyu/appstoreserverapi/storetest"
+	"testing"
+	"time"
+)
+
+func TestBatch(t *testing.T) {
+	start := time.Now().AddDate(0, -1, 0)
+	ids := make([]string, 0)
+	orderIds := make([]string, 0)
+	scenarios := make([]*storetest.Scenario, 0)
+	for i := 0; i < 12; i++ {
+		id := fmt.Sprint(2000 + i)
+		scenarios = append(scenarios, storetest.MonthlySubscription(id, "monthly", start).Renew(1))
+		ids = append(ids, id)
+		orderIds = append(orderIds, "ORDER"+id)
+	}
+	s, client := newStoreTestClient(t, func(cfg *appstoreserverapi.Config) {
+		cfg.TryCount = 1
+		cfg.RateLimiter = appstoreserverapi.NewRateLimiter(100, time.Second, nil)
+	}, scenarios...)
+	for i, sc := range scenarios {
+		s.AddOrder(orderIds[i], sc.Latest().TransactionId)
+	}
+
+	// 一个请求失败，只影响这一个 ID
+	s.InjectError(storetest.EndpointLookUpOrderId, 500, 5000000, 1)
+	next, failed := 0, 0
+	for r := range appstoreserverapi.BatchLookUpOrderId(context.Background(), client, orderIds, appstoreserverapi.BatchOptions{Concurrency: 3, Ordered: true}) {
+		if r.Index != next || r.OrderId != orderIds[next] {
+			t.Fatalf("result %d is for %d %s", next, r.Index, r.OrderId)
+		}
+		next++
+		if r.Err != nil {
+			failed++
+			continue
+		}
+		if r.Response.Status != 0 || len(r.Response.SignedTransactions) != 1 {
+			t.Errorf("%s: %+v", r.OrderId, r.Response)
+		}
+	}
+	if next != len(orderIds) || failed != 1 {
+		t.Errorf("results = %d, failed = %d", next, failed)
+	}
+
+	seen := make(map[int]bool)
+	for r := range appstoreserverapi.BatchGetAllSubscriptionStatuses(context.Background(), client, ids, appstoreserverapi.BatchOptions{}) {
+		if r.Err != nil {
+			t.Errorf("%s: %v", r.TransactionId, r.Err)
+			continue
+		}
+		if ids[r.Index] != r.TransactionId || len(r.Response.Data) != 1 {
+			t.Errorf("%d %s: %+v", r.Index, r.TransactionId, r.Response)
+		}
+		seen[r.Index] = true
+	}
+	if len(seen) != len(ids) {
+		t.Errorf("got %d results, want %d", len(seen), len(ids))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls := s.Calls(storetest.EndpointGetAllSubscriptionStatuses)
+	for r := range appstoreserverapi.BatchGetAllSubscriptionStatuses(ctx, client, ids, appstoreserverapi.BatchOptions{Ordered: true}) {
+		if r.Err != context.Canceled {
+			t.Errorf("%s: err = %v, want %v", r.TransactionId, r.Err, context.Canceled)
+		}
+	}
+	if s.Calls(storetest.EndpointGetAllSubscriptionStatuses) != calls {
+		t.Error("a cancelled batch should not call the API")
+	}
+}
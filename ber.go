@@ -0,0 +1,148 @@
+package appstoreserverapi
+
+import (
+	"errors"
+)
+
+// App 收据是 BER 编码的 PKCS#7，可能包含不定长编码，encoding/asn1 只支持 DER，所以这里自己解析
+// The app receipt is BER encoded PKCS#7 and may use indefinite lengths, encoding/asn1 only supports DER
+
+var ErrBerMalformed = errors.New("malformed BER data")
+
+const (
+	berTagInteger  = 0x02
+	berTagOID      = 0x06
+	berTagSequence = 0x10
+	berTagSet      = 0x11
+
+	berClassUniversal = 0
+)
+
+type berElement struct {
+	class       int
+	constructed bool
+	tag         int
+	content     []byte
+}
+
+// readBER 读取一个元素，返回元素和剩余的数据
+func readBER(b []byte) (berElement, []byte, error) {
+	e := berElement{}
+	if len(b) < 2 {
+		return e, nil, ErrBerMalformed
+	}
+	e.class = int(b[0] >> 6)
+	e.constructed = b[0]&0x20 != 0
+	e.tag = int(b[0] & 0x1f)
+	i := 1
+	if e.tag == 0x1f {
+		// 高位标签
+		e.tag = 0
+		for {
+			if i >= len(b) || i > 4 {
+				return e, nil, ErrBerMalformed
+			}
+			e.tag = e.tag<<7 | int(b[i]&0x7f)
+			i++
+			if b[i-1]&0x80 == 0 {
+				break
+			}
+		}
+	}
+	if i >= len(b) {
+		return e, nil, ErrBerMalformed
+	}
+	l := int(b[i])
+	i++
+	if l == 0x80 {
+		// 不定长：内容以 00 00 结束
+		if !e.constructed {
+			return e, nil, ErrBerMalformed
+		}
+		rest := b[i:]
+		for {
+			if len(rest) >= 2 && rest[0] == 0 && rest[1] == 0 {
+				e.content = b[i : len(b)-len(rest)]
+				return e, rest[2:], nil
+			}
+			var err error
+			_, rest, err = readBER(rest)
+			if err != nil {
+				return e, nil, err
+			}
+		}
+	}
+	if l > 0x80 {
+		n := l & 0x7f
+		if n > 4 || i+n > len(b) {
+			return e, nil, ErrBerMalformed
+		}
+		l = 0
+		for _, v := range b[i : i+n] {
+			l = l<<8 | int(v)
+		}
+		i += n
+	}
+	if l < 0 || i+l > len(b) {
+		return e, nil, ErrBerMalformed
+	}
+	e.content = b[i : i+l]
+	return e, b[i+l:], nil
+}
+
+// children 解析构造类型的所有子元素
+func (e berElement) children() ([]berElement, error) {
+	if !e.constructed {
+		return nil, ErrBerMalformed
+	}
+	items := make([]berElement, 0)
+	rest := e.content
+	for len(rest) > 0 {
+		var item berElement
+		var err error
+		item, rest, err = readBER(rest)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// octets 返回字符串类型的内容，构造类型（分段）时拼接所有分段
+func (e berElement) octets() ([]byte, error) {
+	if !e.constructed {
+		return e.content, nil
+	}
+	items, err := e.children()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, 0)
+	for _, item := range items {
+		v, err := item.octets()
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, v...)
+	}
+	return b, nil
+}
+
+func (e berElement) int64() (int64, error) {
+	if e.constructed || len(e.content) == 0 || len(e.content) > 8 {
+		return 0, ErrBerMalformed
+	}
+	v := int64(0)
+	if e.content[0]&0x80 != 0 {
+		v = -1
+	}
+	for _, b := range e.content {
+		v = v<<8 | int64(b)
+	}
+	return v, nil
+}
+
+func (e berElement) is(class, tag int) bool {
+	return e.class == class && e.tag == tag
+}
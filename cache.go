@@ -0,0 +1,221 @@
+package appstoreserverapi
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// StatusCache 缓存 ApiGetAllSubscriptionStatuses 的结果
+// 客户端写入和读取时都会复制结果，实现可以直接保存和返回同一个指针
+// Caches the results of ApiGetAllSubscriptionStatuses
+// the client copies results on both write and read, implementations may keep and return the same pointer
+type StatusCache interface {
+	// Get 按请求读取
+	// Reads by the request
+	Get(key StatusCacheKey) (*StatusResponse, bool)
+	// Generation 当前的代数，每次 Invalidate 加一，请求 Apple 前读取并传给 Set
+	// The current generation, incremented by every Invalidate, read before asking Apple and passed to Set
+	Generation() uint64
+	// Set 按请求写入，结果中的 originalTransactionId 在 generation 之后被 Invalidate 过时不写入：
+	// 请求进行中收到的通知比结果新
+	// Writes by the request, skipped when an originalTransactionId in the result was invalidated after generation:
+	// a notification received while the request was in flight is newer than the result
+	Set(key StatusCacheKey, r *StatusResponse, generation uint64)
+	// Invalidate 删除该应用中包含该 originalTransactionId 的结果，NotificationHandler 收到通知时调用
+	// Drops the results of the app containing originalTransactionId, called by NotificationHandler on each notification
+	Invalidate(bundleId, originalTransactionId string)
+}
+
+// StatusCacheKey 一次请求：同一个 transactionId 在不同的应用、环境、是否回退沙盒时结果不同，分别缓存
+// One request: the same transactionId answers differently per app, environment and sandbox fallback, so each is cached separately
+type StatusCacheKey struct {
+	// 客户端的应用，即 Config.Bid；Registry 中的客户端共用一个缓存
+	// The app of the client, ie: Config.Bid; the clients of a Registry share one cache
+	BundleId string
+	// 客户端配置的环境，即 Config.Evn
+	// The environment of the client, ie: Config.Evn
+	Environment     Env
+	SandboxFallback bool
+	TransactionId   string
+}
+
+// LRUStatusCache 内存中的 StatusCache，超过容量时淘汰最久未使用的
+// An in-memory StatusCache evicting the least recently used result when full
+type LRUStatusCache struct {
+	capacity int
+	ttl      time.Duration
+	clock    Clock
+
+	lock    sync.Mutex
+	order   *list.List
+	entries map[StatusCacheKey]*list.Element
+	// originalTransactionId 到请求
+	byOriginal map[statusOriginal]map[StatusCacheKey]bool
+	generation uint64
+	// originalTransactionId 最后一次 Invalidate 后的代数，超过 capacity 时清空
+	invalidated map[statusOriginal]uint64
+	// 清空 invalidated 时的代数，更早读取的代数无法判断，不写入
+	floor uint64
+}
+
+// statusOriginal 一个应用中的 originalTransactionId
+type statusOriginal struct {
+	bundleId string
+	original string
+}
+
+type statusCacheEntry struct {
+	key StatusCacheKey
+	// 结果中的 originalTransactionId 和请求的 transactionId
+	originals []string
+	response  *StatusResponse
+	expiresAt time.Time
+}
+
+// NewLRUStatusCache 创建缓存
+// capacity: 最多缓存的结果数量，至少为 1
+// ttl: 有效期，为 0 时只在淘汰或通知时删除
+// clock: 为空时使用 SystemClock
+// capacity: the most results kept, at least 1
+// ttl: how long a result is valid, 0 keeps it until evicted or invalidated
+// clock: defaults to SystemClock
+func NewLRUStatusCache(capacity int, ttl time.Duration, clock Clock) *LRUStatusCache {
+	if capacity < 1 {
+		capacity = 1
+	}
+	if clock == nil {
+		clock = SystemClock
+	}
+	return &LRUStatusCache{
+		capacity:    capacity,
+		ttl:         ttl,
+		clock:       clock,
+		order:       list.New(),
+		entries:     make(map[StatusCacheKey]*list.Element),
+		byOriginal:  make(map[statusOriginal]map[StatusCacheKey]bool),
+		invalidated: make(map[statusOriginal]uint64),
+	}
+}
+
+func (c *LRUStatusCache) Get(key StatusCacheKey) (*StatusResponse, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*statusCacheEntry)
+	if c.ttl > 0 && !c.clock.Now().Before(entry.expiresAt) {
+		c.remove(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return entry.response, true
+}
+
+func (c *LRUStatusCache) Generation() uint64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.generation
+}
+
+func (c *LRUStatusCache) Set(key StatusCacheKey, r *StatusResponse, generation uint64) {
+	originals := statusOriginalTransactionIds(r)
+	// 请求的 transactionId 就是 originalTransactionId，但结果中没有交易时，也能按它删除
+	if !containsString(originals, key.TransactionId) {
+		originals = append(originals, key.TransactionId)
+	}
+	entry := &statusCacheEntry{
+		key:       key,
+		originals: originals,
+		response:  r,
+		expiresAt: c.clock.Now().Add(c.ttl),
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if generation < c.floor {
+		return
+	}
+	for _, original := range entry.originals {
+		if c.invalidated[statusOriginal{key.BundleId, original}] > generation {
+			return
+		}
+	}
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	c.entries[key] = c.order.PushFront(entry)
+	for _, original := range entry.originals {
+		o := statusOriginal{key.BundleId, original}
+		if c.byOriginal[o] == nil {
+			c.byOriginal[o] = make(map[StatusCacheKey]bool)
+		}
+		c.byOriginal[o][key] = true
+	}
+	for c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *LRUStatusCache) Invalidate(bundleId, originalTransactionId string) {
+	o := statusOriginal{bundleId, originalTransactionId}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.generation++
+	if len(c.invalidated) >= c.capacity {
+		c.invalidated = make(map[statusOriginal]uint64)
+		c.floor = c.generation
+	}
+	c.invalidated[o] = c.generation
+	for key := range c.byOriginal[o] {
+		if el, ok := c.entries[key]; ok {
+			c.remove(el)
+		}
+	}
+}
+
+// Len 缓存的结果数量
+// The number of cached results
+func (c *LRUStatusCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRUStatusCache) remove(el *list.Element) {
+	entry := c.order.Remove(el).(*statusCacheEntry)
+	delete(c.entries, entry.key)
+	for _, original := range entry.originals {
+		o := statusOriginal{entry.key.BundleId, original}
+		delete(c.byOriginal[o], entry.key)
+		if len(c.byOriginal[o]) == 0 {
+			delete(c.byOriginal, o)
+		}
+	}
+}
+
+// statusOriginalTransactionIds 结果中的所有 originalTransactionId
+func statusOriginalTransactionIds(r *StatusResponse) []string {
+	seen := make(map[string]bool)
+	originals := make([]string, 0)
+	for _, data := range r.Data {
+		for _, last := range data.LastTransactions {
+			if last.OriginalTransactionId == "" || seen[last.OriginalTransactionId] {
+				continue
+			}
+			seen[last.OriginalTransactionId] = true
+			originals = append(originals, last.OriginalTransactionId)
+		}
+	}
+	return originals
+}
+
+func containsString(values []string, v string) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,217 @@
+package appstoreserverapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/lhlyu/appstoreserverapi"
+	"github.com/lhlyu/appstoreserverapi/storetest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClient_Coalescing(t *testing.T) {
+	s, client := newStoreTestClient(t, nil, storetest.MonthlySubscription("3000", "monthly", time.Now()))
+	s.SetLatency(50 * time.Millisecond)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if r, err := client.ApiGetAllSubscriptionStatuses("3000"); err != nil || len(r.Data) != 1 {
+				t.Errorf("r = %+v, err = %v", r, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls := s.Calls(storetest.EndpointGetAllSubscriptionStatuses); calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+}
+
+func TestLRUStatusCache(t *testing.T) {
+	start := time.Now()
+	sc := storetest.MonthlySubscription("3000", "monthly", start)
+	clock := storetest.NewClock(start)
+	cache := appstoreserverapi.NewLRUStatusCache(2, time.Minute, clock)
+	s, client := newStoreTestClient(t, func(cfg *appstoreserverapi.Config) {
+		cfg.StatusCache = cache
+	}, sc, storetest.MonthlySubscription("3100", "monthly", start), storetest.MonthlySubscription("3200", "monthly", start))
+	calls := func() int {
+		return s.Calls(storetest.EndpointGetAllSubscriptionStatuses)
+	}
+	key := func(id string) appstoreserverapi.StatusCacheKey {
+		return appstoreserverapi.StatusCacheKey{BundleId: storetest.Bid, Environment: appstoreserverapi.LocalTesting, TransactionId: id}
+	}
+	get := func(id string) {
+		t.Helper()
+		if _, err := client.ApiGetAllSubscriptionStatuses(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	get("3000")
+	get("3000")
+	if calls() != 1 {
+		t.Fatalf("calls = %d, want 1", calls())
+	}
+	clock.Advance(time.Minute)
+	get("3000")
+	if calls() != 2 {
+		t.Errorf("expired: calls = %d, want 2", calls())
+	}
+
+	// 容量为 2，3000 最久未使用，被淘汰
+	get("3100")
+	get("3200")
+	if cache.Len() != 2 {
+		t.Errorf("len = %d, want 2", cache.Len())
+	}
+	if _, ok := cache.Get(key("3000")); ok {
+		t.Error("3000 should be evicted")
+	}
+
+	// 收到通知后删除该 originalTransactionId 的结果
+	sc.Renew(1)
+	s.AddScenario(sc)
+	signed, err := sc.Sign(s.Fixtures())
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &appstoreserverapi.NotificationHandler{Verifier: s.Chain.Verifier(), StatusCache: cache}
+	body, _ := json.Marshal(map[string]string{"signedPayload": signed[len(signed)-1]})
+	get(sc.Latest().TransactionId)
+	if _, ok := cache.Get(key(sc.Latest().TransactionId)); !ok {
+		t.Fatal("expected a cached result")
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/notifications", strings.NewReader(string(body))))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d", w.Code)
+	}
+	if _, ok := cache.Get(key(sc.Latest().TransactionId)); ok {
+		t.Error("notification should invalidate the cached result")
+	}
+	if _, ok := cache.Get(key("3200")); !ok {
+		t.Error("other results should stay cached")
+	}
+
+	// 是否回退沙盒不同的请求分别缓存
+	before := calls()
+	if _, err := client.ApiGetAllSubscriptionStatuses("3200", appstoreserverapi.WithSandboxFallback(true)); err != nil {
+		t.Fatal(err)
+	}
+	if calls() != before+1 {
+		t.Errorf("sandbox fallback request: calls = %d, want %d", calls(), before+1)
+	}
+	fallbackKey := key("3200")
+	fallbackKey.SandboxFallback = true
+	if _, ok := cache.Get(fallbackKey); !ok {
+		t.Error("sandbox fallback result should be cached under its own key")
+	}
+	if _, ok := cache.Get(appstoreserverapi.StatusCacheKey{BundleId: storetest.Bid, Environment: appstoreserverapi.Production, TransactionId: "3200"}); ok {
+		t.Error("another environment should not hit the cache")
+	}
+
+	// 共用缓存的其它应用不命中，也不能删除
+	otherApp := key("3200")
+	otherApp.BundleId = "com.example.other"
+	if _, ok := cache.Get(otherApp); ok {
+		t.Error("another app should not hit the cache")
+	}
+	cache.Invalidate(otherApp.BundleId, "3200")
+	if _, ok := cache.Get(key("3200")); !ok {
+		t.Error("a notification of another app should not invalidate the result")
+	}
+
+	// 返回的是缓存结果的副本
+	r, err := client.ApiGetAllSubscriptionStatuses("3200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Data[0].LastTransactions[0].Status = 99
+	if r, _ = client.ApiGetAllSubscriptionStatuses("3200"); r.Data[0].LastTransactions[0].Status == 99 {
+		t.Error("modifying a returned result should not change the cache")
+	}
+}
+
+func TestClient_CoalescingFollowerContext(t *testing.T) {
+	s, client := newStoreTestClient(t, nil, storetest.MonthlySubscription("3300", "monthly", time.Now()))
+	s.SetLatency(300 * time.Millisecond)
+
+	leader := make(chan error, 1)
+	go func() {
+		_, err := client.ApiGetAllSubscriptionStatuses("3300")
+		leader <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	// 等待共用结果的调用在自己的 ctx 超时后立即返回
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	if _, err := client.ApiGetAllSubscriptionStatuses("3300", appstoreserverapi.WithContext(ctx)); err != context.DeadlineExceeded {
+		t.Errorf("follower err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if waited := time.Since(begin); waited > 200*time.Millisecond {
+		t.Errorf("follower waited %s for the shared request", waited)
+	}
+	if err := <-leader; err != nil {
+		t.Errorf("leader err = %v", err)
+	}
+	if calls := s.Calls(storetest.EndpointGetAllSubscriptionStatuses); calls != 1 {
+		t.Errorf("calls = %d, want 1", calls)
+	}
+}
+
+func TestLRUStatusCache_InvalidateInFlight(t *testing.T) {
+	cache := appstoreserverapi.NewLRUStatusCache(10, 0, nil)
+	s, client := newStoreTestClient(t, func(cfg *appstoreserverapi.Config) {
+		cfg.StatusCache = cache
+	}, storetest.MonthlySubscription("3400", "monthly", time.Now()))
+	s.SetLatency(200 * time.Millisecond)
+	calls := func() int {
+		return s.Calls(storetest.EndpointGetAllSubscriptionStatuses)
+	}
+	get := func(done chan<- error) {
+		_, err := client.ApiGetAllSubscriptionStatuses("3400")
+		done <- err
+	}
+
+	// 请求进行中收到通知，结果不缓存
+	first := make(chan error, 1)
+	go get(first)
+	time.Sleep(50 * time.Millisecond)
+	cache.Invalidate(storetest.Bid, "3400")
+	if err := <-first; err != nil {
+		t.Fatal(err)
+	}
+	if cache.Len() != 0 {
+		t.Errorf("len = %d, want 0: the result is older than the notification", cache.Len())
+	}
+
+	// 收到通知后开始的请求不共用进行中的请求
+	before := calls()
+	leader := make(chan error, 1)
+	go get(leader)
+	time.Sleep(50 * time.Millisecond)
+	cache.Invalidate(storetest.Bid, "3400")
+	follower := make(chan error, 1)
+	go get(follower)
+	if err := <-leader; err != nil {
+		t.Fatal(err)
+	}
+	if err := <-follower; err != nil {
+		t.Fatal(err)
+	}
+	if calls() != before+2 {
+		t.Errorf("calls = %d, want %d", calls(), before+2)
+	}
+	if cache.Len() != 1 {
+		t.Errorf("len = %d, want 1: the request started after the notification is cached", cache.Len())
+	}
+}
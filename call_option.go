@@ -0,0 +1,77 @@
+package appstoreserverapi
+
+import (
+	"context"
+	"net/url"
+)
+
+// CallOption 单次调用的选项
+// Options of a single call
+type CallOption func(o *callOptions)
+
+type callOptions struct {
+	ctx             context.Context
+	sandboxFallback bool
+	revision        string
+	noCache         bool
+	// 本次调用的 span，由 startSpan 设置
+	span Span
+}
+
+func (c *client) newCallOptions(opts []CallOption) callOptions {
+	o := callOptions{
+		ctx:             context.Background(),
+		sandboxFallback: c.cfg.SandboxFallback,
+		span:            nopSpan{},
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// WithContext 本次调用的 context：取消后不再等待限流和重试，其中的 span 作为父 span，WithActor 设置的操作人用于审计
+// The context of the call: once done, rate limit waits and retries stop, its span becomes the parent span, the actor set with WithActor is audited
+func WithContext(ctx context.Context) CallOption {
+	return func(o *callOptions) {
+		if ctx != nil {
+			o.ctx = ctx
+		}
+	}
+}
+
+// WithSandboxFallback 正式环境返回交易不存在时，是否再到沙盒环境查询，覆盖 Config.SandboxFallback
+// Whether to retry in sandbox when production says the transaction is not found, overrides Config.SandboxFallback
+func WithSandboxFallback(enable bool) CallOption {
+	return func(o *callOptions) {
+		o.sandboxFallback = enable
+	}
+}
+
+// WithRevision 分页查询，传入上一次结果的 Revision，只返回之后的数据
+// 用于 ApiGetTransactionHistory 和 ApiGetRefundHistory
+// Pages through results, pass the Revision of the previous result to get only what came after it
+// used by ApiGetTransactionHistory and ApiGetRefundHistory
+func WithRevision(revision string) CallOption {
+	return func(o *callOptions) {
+		o.revision = revision
+	}
+}
+
+// WithoutCache 不读取 Config.StatusCache，直接请求 Apple，新的结果仍然写入缓存
+// 用于 ApiGetAllSubscriptionStatuses
+// Skips reading Config.StatusCache and asks Apple, the fresh result is still written to the cache
+// used by ApiGetAllSubscriptionStatuses
+func WithoutCache() CallOption {
+	return func(o *callOptions) {
+		o.noCache = true
+	}
+}
+
+// query 分页参数
+func (o callOptions) query() string {
+	if o.revision == "" {
+		return ""
+	}
+	return "?" + url.Values{"revision": {o.revision}}.Encode()
+}
@@ -0,0 +1,255 @@
+// Package cassette 录制和回放 HTTP 请求，让集成测试可以在没有网络的环境中运行
+// Package cassette records and replays HTTP requests so integration tests can run without a network
+package cassette
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	ErrNoInteraction = errors.New("cassette: no recorded interaction matches the request")
+	ErrModeInvalid   = errors.New("cassette: invalid mode")
+)
+
+// Mode 录制或回放
+// Record or replay
+type Mode int
+
+const (
+	// ModeReplay 只从文件中回放，不访问网络
+	// ModeReplay serves from the file only, the network is never used
+	ModeReplay Mode = iota
+	// ModeRecord 请求真实的服务并记录，调用 Save 写入文件
+	// ModeRecord forwards to the real server and records, Save writes the file
+	ModeRecord
+)
+
+// redacted Authorization 头在文件中的值
+const redacted = "REDACTED"
+
+// 写入文件的请求头和响应头，其它头可能包含 Cookie、代理凭证等，不录制
+var (
+	requestHeaders  = []string{"Accept", "Content-Type"}
+	responseHeaders = []string{"Content-Length", "Content-Type", "Retry-After"}
+)
+
+// Request 录制的请求
+// A recorded request
+type Request struct {
+	Method string      `json:"method"`
+	Path   string      `json:"path"`
+	Query  string      `json:"query,omitempty"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// Response 录制的响应
+// A recorded response
+type Response struct {
+	Status int         `json:"status"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// Interaction 一次请求和响应
+// One request and its response
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Recorder 录制或回放的 http.RoundTripper
+// 回放时按 method、path、query、body 匹配，相同的请求按录制的顺序依次返回
+// 录制时只保存 Accept、Content-Type 等少数几个头，Authorization 记为 REDACTED
+// An http.RoundTripper that records or replays
+// Replay matches on method, path, query and body, identical requests are served in recorded order
+// Recording keeps only a few headers such as Accept and Content-Type, Authorization is stored as REDACTED
+type Recorder struct {
+	path      string
+	mode      Mode
+	transport http.RoundTripper
+
+	lock         sync.Mutex
+	interactions []*Interaction
+	used         []bool
+}
+
+// New 创建 Recorder
+// path: 文件路径
+// mode: 录制或回放，回放时文件必须存在
+// transport: 录制时使用的 RoundTripper，为空时使用 http.DefaultTransport
+// path: the cassette file
+// mode: record or replay, the file must exist to replay
+// transport: the RoundTripper used to record, http.DefaultTransport when nil
+func New(path string, mode Mode, transport http.RoundTripper) (*Recorder, error) {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	r := &Recorder{
+		path:      path,
+		mode:      mode,
+		transport: transport,
+	}
+	switch mode {
+	case ModeRecord:
+	case ModeReplay:
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(b, &r.interactions); err != nil {
+			return nil, fmt.Errorf("cassette: %s: %w", path, err)
+		}
+		r.used = make([]bool, len(r.interactions))
+	default:
+		return nil, ErrModeInvalid
+	}
+	return r, nil
+}
+
+// Interactions 已录制或已加载的交互
+// The recorded or loaded interactions
+func (r *Recorder) Interactions() []Interaction {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	result := make([]Interaction, 0, len(r.interactions))
+	for _, it := range r.interactions {
+		result = append(result, *it)
+	}
+	return result
+}
+
+// RoundTrip 实现 http.RoundTripper
+// Implements http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	recorded := newRequest(req, body)
+	if r.mode == ModeReplay {
+		return r.replay(req, recorded)
+	}
+
+	// RoundTripper 不能修改调用方的请求，请求体放在副本中发送
+	out := req.Clone(req.Context())
+	if body != nil {
+		out.Body = io.NopCloser(bytes.NewReader(body))
+		out.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	resp, err := r.transport.RoundTrip(out)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.lock.Lock()
+	r.interactions = append(r.interactions, &Interaction{
+		Request: recorded,
+		Response: Response{
+			Status: resp.StatusCode,
+			Header: filterHeader(resp.Header, responseHeaders),
+			Body:   string(b),
+		},
+	})
+	r.lock.Unlock()
+	resp.Body = io.NopCloser(bytes.NewReader(b))
+	return resp, nil
+}
+
+// Save 录制模式下把交互写入文件，回放模式下什么也不做
+// Writes the interactions to the file when recording, does nothing when replaying
+func (r *Recorder) Save() error {
+	if r.mode != ModeRecord {
+		return nil
+	}
+	r.lock.Lock()
+	b, err := json.MarshalIndent(r.interactions, "", "  ")
+	r.lock.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(r.path, append(b, '\n'), 0644)
+}
+
+func (r *Recorder) replay(req *http.Request, recorded Request) (*http.Response, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for i, it := range r.interactions {
+		if r.used[i] || !it.Request.matches(recorded) {
+			continue
+		}
+		r.used[i] = true
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", it.Response.Status, http.StatusText(it.Response.Status)),
+			StatusCode:    it.Response.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        it.Response.Header.Clone(),
+			Body:          io.NopCloser(bytes.NewReader([]byte(it.Response.Body))),
+			ContentLength: int64(len(it.Response.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %s %s", ErrNoInteraction, req.Method, req.URL.RequestURI())
+}
+
+func (q Request) matches(o Request) bool {
+	return q.Method == o.Method && q.Path == o.Path && q.Query == o.Query && q.Body == o.Body
+}
+
+// newRequest 记录请求，只保留 requestHeaders，Authorization 头会被隐藏
+func newRequest(req *http.Request, body []byte) Request {
+	header := filterHeader(req.Header, requestHeaders)
+	if req.Header.Get("Authorization") != "" {
+		header.Set("Authorization", redacted)
+	}
+	return Request{
+		Method: req.Method,
+		Path:   req.URL.Path,
+		Query:  req.URL.Query().Encode(),
+		Header: header,
+		Body:   string(body),
+	}
+}
+
+// filterHeader 只保留 allowed 中的头
+func filterHeader(h http.Header, allowed []string) http.Header {
+	header := make(http.Header)
+	for _, key := range allowed {
+		if values := h.Values(key); len(values) > 0 {
+			header[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+		}
+	}
+	return header
+}
+
+// readBody 读取并关闭请求体，不修改 req
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	b, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,127 @@
+package cassette
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecorder_RecordReplay(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		b, _ := io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(r.Method + " " + r.URL.RequestURI() + " " + string(b)))
+	}))
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "cassettes", "test.json")
+
+	rec, err := New(path, ModeRecord, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: rec}
+	do := func(method, uri, body string) (int, string, error) {
+		req, _ := http.NewRequest(method, server.URL+uri, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := client.Do(req)
+		if err != nil {
+			return 0, "", err
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b), nil
+	}
+	if _, _, err := do(http.MethodPut, "/a?y=2&x=1", `{"n":1}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := do(http.MethodGet, "/b", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "secret") {
+		t.Error("the Authorization header was written to the cassette")
+	}
+
+	rec, err = New(path, ModeReplay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.Transport = rec
+	status, body, err := do(http.MethodPut, "/a?x=1&y=2", `{"n":1}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != http.StatusAccepted || body != `PUT /a?y=2&x=1 {"n":1}` {
+		t.Errorf("replayed %d %q", status, body)
+	}
+	if calls != 2 {
+		t.Errorf("server calls = %d, want 2", calls)
+	}
+
+	_, _, err = do(http.MethodPut, "/a?x=1&y=2", `{"n":2}`)
+	if !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("different body: err = %v, want %v", err, ErrNoInteraction)
+	}
+	if _, _, err := do(http.MethodGet, "/b", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := do(http.MethodGet, "/b", ""); !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("used interaction: err = %v, want %v", err, ErrNoInteraction)
+	}
+}
+
+func TestRecorder_RequestAndHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{}`))
+	}))
+	defer server.Close()
+	path := filepath.Join(t.TempDir(), "test.json")
+
+	rec, err := New(path, ModeRecord, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := io.NopCloser(strings.NewReader(`{"n":1}`))
+	req, _ := http.NewRequest(http.MethodPut, server.URL+"/a", body)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Cookie", "session=secret")
+	req.Header.Set("Proxy-Authorization", "Basic secret")
+	resp, err := rec.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// 调用方的请求不被修改
+	if req.Body != body {
+		t.Error("RoundTrip replaced the caller's request body")
+	}
+	if err := rec.Save(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "secret") {
+		t.Errorf("headers outside the allow-list were written to the cassette: %s", b)
+	}
+	it := rec.Interactions()[0]
+	if it.Request.Header.Get("Content-Type") != "application/json" || it.Response.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("allowed headers were dropped: %+v", it)
+	}
+}
@@ -1,14 +1,16 @@
 package appstoreserverapi
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/lestrrat-go/jwx/v2/jwt"
 	"github.com/tidwall/gjson"
 	"io"
-	"log"
 	"net/http"
-	"sync"
+	"strings"
 	"time"
 )
 
@@ -16,15 +18,28 @@ var (
 	ErrConfigIsNil   = errors.New("config is nil")
 	ErrConfigInvalid = errors.New("config invalid")
 	ErrRequestFailed = errors.New("request failed")
+	ErrUnauthorized  = errors.New("unauthorized")
 )
 
-type env string
+type Env string
 
 const (
-	Production  env = "production"
-	Development env = "development"
+	Production  Env = "production"
+	Development Env = "development"
+	// Sandbox 沙盒环境，同 Development
+	Sandbox = Development
+	// Xcode Xcode 中的 StoreKit 测试环境，需要在 Config.BaseUrls 中设置地址
+	Xcode Env = "xcode"
+	// LocalTesting 本地测试环境，例如自己搭建的模拟服务，需要在 Config.BaseUrls 中设置地址
+	LocalTesting Env = "localTesting"
 )
 
+// defaultBaseUrls 各个环境的默认地址
+var defaultBaseUrls = map[Env]string{
+	Production:  productionBaseUrl,
+	Development: developmentBaseUrl,
+}
+
 type Config struct {
 	// 发行人: 您在 App Store Connect 中的密钥页面中的发行者 ID（例如：" 57246542-96fe-1a63-e053-0824d011072a"）
 	// Issuer: Your issuer ID from the Keys page in App Store Connect (Ex: "57246542-96fe-1a63-e053-0824d011072a")
@@ -43,17 +58,73 @@ type Config struct {
 		-----END PRIVATE KEY-----`
 	*/
 	Pk string
+	// 签名秘钥提供者：可选，设置后代替 Pk，例如 HSM、KMS 中的 crypto.Signer 或者秘钥文件
+	// Key provider: optional, replaces Pk, eg: a crypto.Signer backed by an HSM or KMS, or a key file
+	Key KeyProvider
+	// 多个秘钥：可选，设置后代替 Kid、Pk、Key，按 KeyRotation 策略使用，用于不停机更换秘钥
+	// Signing keys: optional, replaces Kid, Pk and Key, used according to KeyRotation to rotate keys without downtime
+	Keys []SigningKey
+	// 秘钥轮换策略：默认 RotateOnUnauthorized
+	// Key rotation policy: defaults to RotateOnUnauthorized
+	KeyRotation RotationPolicy
 	// 受众：appstoreconnect-v1
 	// Audience: appstoreconnect-v1
 	Aud string
 	// 有效期：默认是10分钟
 	ExpiryIn time.Duration
+	// 提前刷新：token 过期前多久开始在后台刷新，默认是有效期的 1/10，不小于有效期时使用有效期的 1/2
+	// Refresh skew: how long before expiry the token is refreshed in the background, defaults to ExpiryIn/10, ExpiryIn/2 is used when it is not below ExpiryIn
+	RefreshSkew time.Duration
 	// 环境：默认正式环境
-	Evn env
+	Evn Env
+	// 沙盒回退：正式环境返回交易不存在时，再到沙盒环境查询，默认关闭，可以在每次调用时用 WithSandboxFallback 修改
+	// App Review 在正式版本中使用沙盒购买，所以 Apple 建议这样处理
+	// Sandbox fallback: retry in sandbox when production says the transaction is not found, off by default,
+	// can be changed per call with WithSandboxFallback. Apple recommends it because App Review makes sandbox purchases against production builds
+	SandboxFallback bool
+	// 环境地址：可选，覆盖默认地址，例如指向本地的模拟服务，Xcode、LocalTesting 环境必须设置
+	// Base URLs: optional, override the default base URL of an environment, eg: a local fake server,
+	// required for Xcode and LocalTesting
+	BaseUrls map[Env]string
 	// 重试次数：默认10次
 	TryCount uint
-
-	exp time.Time
+	// 解码模式：默认部分模式，和之前一样返回解码成功的结果，同时返回 DecodeErrors；DecodeStrict 时整个调用失败
+	// Decode mode: defaults to DecodePartial, results are returned as before together with DecodeErrors; DecodeStrict fails the whole call
+	DecodeMode DecodeMode
+	// 日志：默认不输出，transactionId 等敏感字段会被脱敏
+	// Logger: silent by default, sensitive fields such as transactionId are redacted
+	Logger Logger
+	// 校验器：为空时只解码签名数据，不校验证书链和签名
+	// Verifier: when nil, signed data is decoded without verifying the chain and signature
+	Verifier *Verifier
+	// HTTP 客户端：默认 http.DefaultClient
+	// HTTP client: defaults to http.DefaultClient
+	HttpClient *http.Client
+	// 限流：可选，多个客户端可以共用一个
+	// Rate limiter: optional, may be shared by several clients
+	RateLimiter RateLimiter
+	// 存储：可选，设置后 Api 方法返回的交易和续订信息会写入
+	// Store: optional, transactions and renewal infos returned by the Api methods are written into it
+	Store TransactionStore
+	// 订阅状态缓存：可选，设置后 ApiGetAllSubscriptionStatuses 先读缓存，例如 NewLRUStatusCache；
+	// 把同一个缓存设置到 NotificationHandler.StatusCache，收到通知时删除过期的结果
+	// Status cache: optional, ApiGetAllSubscriptionStatuses reads it first, eg: NewLRUStatusCache;
+	// set the same cache on NotificationHandler.StatusCache to drop stale results on notifications
+	StatusCache StatusCache
+	// 指标：可选，记录每次请求的状态、耗时、重试、token 签名和限流等待
+	// Metrics: optional, records the status and latency of each request, retries, token signing and rate limit waits
+	Metrics Metrics
+	// 链路追踪：可选，记录每次调用、请求和签名校验的 span
+	// Tracer: optional, records spans for each call, request and signature verification
+	Tracer Tracer
+	// 审计：可选，每次修改 Apple 数据的调用结束后记录，例如 NewFileAuditor
+	// Auditor: optional, records each call changing customer state at Apple, eg: NewFileAuditor
+	Auditor Auditor
+	// 时钟：默认 SystemClock，用于 token 过期、证书校验和 Metrics 中的请求耗时；
+	// RateLimiter 不读取 Config，需要在 NewRateLimiter 中传入同一个时钟
+	// Clock: defaults to SystemClock, used for token expiry, certificate checks and the request durations in Metrics;
+	// RateLimiter does not read Config, pass the same clock to NewRateLimiter
+	Clock Clock
 }
 
 type Client interface {
@@ -61,52 +132,43 @@ type Client interface {
 	// ApiGetAllSubscriptionStatuses 获取所有的订阅状态
 	// Get All Subscription Statuses
 	// doc: https://developer.apple.com/documentation/appstoreserverapi/get_all_subscription_statuses
-	ApiGetAllSubscriptionStatuses(transactionId string) (*StatusResponse, error)
+	ApiGetAllSubscriptionStatuses(transactionId string, opts ...CallOption) (*StatusResponse, error)
 
 	// ApiLookUpOrderId 查找订单 ID
 	// Look Up Order ID
 	// doc: https://developer.apple.com/documentation/appstoreserverapi/look_up_order_id
-	ApiLookUpOrderId(orderId string) (*OrderLookupResponse, error)
+	ApiLookUpOrderId(orderId string, opts ...CallOption) (*OrderLookupResponse, error)
 
 	// ApiGetTransactionHistory 获取历史交易记录
 	// Get Transaction History
 	// doc: https://developer.apple.com/documentation/appstoreserverapi/get_transaction_history
 	// desc: true then signedTransactions order by webOrderLineItemId desc
-	ApiGetTransactionHistory(transactionId string, desc bool) (*HistoryResponse, error)
+	ApiGetTransactionHistory(transactionId string, desc bool, opts ...CallOption) (*HistoryResponse, error)
 
 	// ApiGetRefundHistory 获取退款历史
 	// Get Refund History
 	// doc: https://developer.apple.com/documentation/appstoreserverapi/get_refund_history
 	// desc: true then signedTransactions order by webOrderLineItemId desc
-	ApiGetRefundHistory(transactionId string, desc bool) (*RefundLookupResponse, error)
+	ApiGetRefundHistory(transactionId string, desc bool, opts ...CallOption) (*RefundLookupResponse, error)
 
 	// ApiExtendAsubscriptionRenewalDate 延长订阅续订日期
 	// Extend a Subscription Renewal Date
 	// doc: https://developer.apple.com/documentation/appstoreserverapi/extend_a_subscription_renewal_date
-	ApiExtendAsubscriptionRenewalDate(transactionId string, req ExtendRenewalDateRequest) (*ExtendRenewalDateResponse, error)
+	ApiExtendAsubscriptionRenewalDate(transactionId string, req ExtendRenewalDateRequest, opts ...CallOption) (*ExtendRenewalDateResponse, error)
 
 	// ApiSendConsumptionInformation 发送消费信息
 	// Send Consumption Information
 	// doc: https://developer.apple.com/documentation/appstoreserverapi/send_consumption_information
-	ApiSendConsumptionInformation(transactionId string, req ConsumptionRequest) error
-}
-
-type apiUrl struct {
-	apiGetAllSubscriptionStatusesUrl     string
-	apiGetTransactionHistoryUrl          string
-	apiLookupOrderIdUrl                  string
-	apiGetRefundHistoryUrl               string
-	apiExtendASubscriptionRenewalDateUrl string
-	apiSendConsumptionInformationUrl     string
+	ApiSendConsumptionInformation(transactionId string, req ConsumptionRequest, opts ...CallOption) error
 }
 
 type client struct {
-	apiUrl
-
-	bearer string
-	lock   sync.Mutex
+	tokens *tokenSource
+	// 合并相同的 GET 请求
+	flights flightGroup
 
-	cfg *Config
+	cfg    *Config
+	logger Logger
 }
 
 func NewClient(cfg *Config) (Client, error) {
@@ -116,113 +178,264 @@ func NewClient(cfg *Config) (Client, error) {
 	if cfg.Iss == "" {
 		return nil, ErrConfigInvalid
 	}
-	if cfg.Kid == "" {
-		return nil, ErrConfigInvalid
-	}
 	if cfg.Bid == "" {
 		return nil, ErrConfigInvalid
 	}
-	if cfg.Pk == "" {
+	if !cfg.hasKey() {
 		return nil, ErrConfigInvalid
 	}
 	if cfg.ExpiryIn == 0 {
 		cfg.ExpiryIn = time.Minute * 10
 	}
+	if cfg.RefreshSkew == 0 {
+		cfg.RefreshSkew = cfg.ExpiryIn / 10
+	}
+	if cfg.RefreshSkew >= cfg.ExpiryIn {
+		cfg.RefreshSkew = cfg.ExpiryIn / 2
+	}
 	if cfg.TryCount == 0 {
 		cfg.TryCount = 10
 	}
 	if cfg.Evn == "" {
 		cfg.Evn = Production
 	}
+	if cfg.KeyRotation == "" {
+		cfg.KeyRotation = RotateOnUnauthorized
+	}
+	if cfg.DecodeMode == "" {
+		cfg.DecodeMode = DecodePartial
+	}
 	if cfg.Aud == "" {
 		cfg.Aud = "appstoreconnect-v1"
 	}
+	if cfg.HttpClient == nil {
+		cfg.HttpClient = http.DefaultClient
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = SystemClock
+	}
+	if cfg.Verifier != nil && cfg.Verifier.clock == nil {
+		cfg.Verifier = cfg.Verifier.WithClock(cfg.Clock)
+	}
+	if cfg.Verifier != nil && cfg.Verifier.bundleId == "" {
+		cfg.Verifier = cfg.Verifier.WithApp(cfg.Bid, 0, "")
+	}
+	if cfg.baseUrl(cfg.Evn) == "" {
+		return nil, ErrConfigInvalid
+	}
 	c := &client{
-		cfg:  cfg,
-		lock: sync.Mutex{},
-		apiUrl: apiUrl{
-			apiGetAllSubscriptionStatusesUrl:     productionBaseUrl + apiGetAllSubscriptionStatusesUri,
-			apiGetTransactionHistoryUrl:          productionBaseUrl + apiGetTransactionHistoryUri,
-			apiLookupOrderIdUrl:                  productionBaseUrl + apiLookupOrderIdUri,
-			apiGetRefundHistoryUrl:               productionBaseUrl + apiGetRefundHistoryUri,
-			apiExtendASubscriptionRenewalDateUrl: productionBaseUrl + apiExtendASubscriptionRenewalDateUri,
-			apiSendConsumptionInformationUrl:     productionBaseUrl + apiSendConsumptionInformationUri,
-		},
-	}
-	if cfg.Evn == Development {
-		c.apiUrl = apiUrl{
-			apiGetAllSubscriptionStatusesUrl:     developmentBaseUrl + apiGetAllSubscriptionStatusesUri,
-			apiGetTransactionHistoryUrl:          developmentBaseUrl + apiGetTransactionHistoryUri,
-			apiLookupOrderIdUrl:                  developmentBaseUrl + apiLookupOrderIdUri,
-			apiGetRefundHistoryUrl:               developmentBaseUrl + apiGetRefundHistoryUri,
-			apiExtendASubscriptionRenewalDateUrl: developmentBaseUrl + apiExtendASubscriptionRenewalDateUri,
-			apiSendConsumptionInformationUrl:     developmentBaseUrl + apiSendConsumptionInformationUri,
-		}
+		cfg:    cfg,
+		tokens: newTokenSource(cfg),
+		logger: newRedactLogger(cfg.Logger),
 	}
 	return c, nil
 }
 
-func (c *client) GetBearer() (string, error) {
-	if err := c.newIfExpired(); err != nil {
-		return "", err
+// baseUrl 环境对应的地址，优先使用 BaseUrls 中的设置
+func (cfg *Config) baseUrl(e Env) string {
+	if u, ok := cfg.BaseUrls[e]; ok && u != "" {
+		return strings.TrimSuffix(u, "/")
 	}
-	return c.bearer, nil
+	return defaultBaseUrls[e]
 }
 
-func (c *client) newIfExpired() error {
-	defer c.lock.Unlock()
-	c.lock.Lock()
-	if c.bearer == "" {
-		token, err := SignJwt(c.cfg)
-		if err != nil {
-			return err
+func (c *client) GetBearer() (string, error) {
+	return c.tokens.Token()
+}
+
+// apiRequest 一次接口请求
+type apiRequest struct {
+	// 接口名称，例如：GetTransactionHistory
+	endpoint string
+	method   string
+	// 路径，例如：/inApps/v1/history/{transactionId}
+	uri string
+	// 路径中的 transactionId 或 orderId
+	id   string
+	opts callOptions
+	body []byte
+	// 请求前读取的 StatusCache 代数，不同代数的 GET 不合并：收到通知后开始的请求不共用之前的结果
+	generation uint64
+}
+
+// doRequest 发送请求，返回结果和响应的环境
+// 正在进行中的相同 GET 请求只发送一次，结果共用；其它请求会修改 Apple 的数据，记录审计
+func (c *client) doRequest(ar *apiRequest) (*gjson.Result, Env, error) {
+	if ar.method != http.MethodGet {
+		r, e, err := c.doFallbackRequest(ar)
+		raw := ""
+		if r != nil {
+			raw = r.Raw
 		}
-		c.bearer = token
-		return nil
+		c.audit(ar, e, raw, err)
+		return r, e, err
 	}
-	// 过期的时
-	if time.Now().Unix() >= c.cfg.exp.Unix() {
-		token, err := SignJwt(c.cfg)
-		if err != nil {
-			return err
-		}
-		c.bearer = token
-		return nil
+	key := fmt.Sprintf("%s %t %d %s", c.cfg.Evn, ar.opts.sandboxFallback, ar.generation, ar.uri)
+	r, e, err := c.flights.do(ar.opts.ctx, key, func() (*gjson.Result, Env, error) {
+		return c.doFallbackRequest(ar)
+	})
+	// 共用的请求因为发起方的 ctx 取消而失败，自己的 ctx 没有取消时单独再请求一次
+	if (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) && ar.opts.ctx.Err() == nil {
+		return c.doFallbackRequest(ar)
 	}
-	return nil
+	return r, e, err
 }
 
-func (c *client) doRequest(method, url string, body io.Reader) (*gjson.Result, error) {
+// doFallbackRequest 开启沙盒回退时，正式环境返回交易不存在后再请求沙盒环境
+func (c *client) doFallbackRequest(ar *apiRequest) (*gjson.Result, Env, error) {
+	r, err := c.doEnvRequest(ar, c.cfg.Evn)
+	if err == nil || c.cfg.Evn != Production || !ar.opts.sandboxFallback || !isTransactionNotFound(err) {
+		return r, c.cfg.Evn, err
+	}
+	c.logger.Log(LevelInfo, "transaction not found in production, retrying in sandbox",
+		Field{FieldEndpoint, ar.endpoint},
+		Field{FieldTransactionId, ar.id},
+	)
+	ar.opts.span.SetAttributes(Field{FieldEnvironment, string(Development)})
+	r, err = c.doEnvRequest(ar, Development)
+	return r, Development, err
+}
+
+func (c *client) doEnvRequest(ar *apiRequest, e Env) (*gjson.Result, error) {
 	var err error
+	// 收到 401 时作废 token，重新签名后再重试一次
+	reSigned := false
 	bearer, err := c.GetBearer()
 	if err != nil {
+		c.logger.Log(LevelError, "sign token failed",
+			Field{FieldEndpoint, ar.endpoint},
+			Field{FieldError, errorText(err)},
+		)
 		return nil, err
 	}
-	req, err := http.NewRequest(method, url, body)
-	if err != nil {
-		return nil, err
-	}
-	req.Header.Set("Authorization", "Bearer "+bearer)
+	metrics := c.cfg.metrics()
+	tracer := c.cfg.tracer()
 	var resp *http.Response
-	for i := int(c.cfg.TryCount); i > 0; i-- {
-		resp, err = http.DefaultClient.Do(req)
+	// 第一次请求和重新签名后的请求不是重试，重新签名由 TokenRefresh 记录
+	retry := false
+	for attempt := 1; attempt <= int(c.cfg.TryCount); attempt++ {
+		if retry {
+			metrics.Retry(ar.endpoint)
+		}
+		retry = true
+		if c.cfg.RateLimiter != nil {
+			waitStart := c.cfg.now()
+			err = c.cfg.RateLimiter.Wait(ar.opts.ctx)
+			metrics.RateLimitWait(ar.endpoint, c.cfg.now().Sub(waitStart))
+			if err != nil {
+				return nil, err
+			}
+		}
+		var body io.Reader
+		if ar.body != nil {
+			body = bytes.NewReader(ar.body)
+		}
+		ctx, span := tracer.Start(ar.opts.ctx, SpanAttempt,
+			Field{FieldEndpoint, ar.endpoint},
+			Field{FieldEnvironment, string(e)},
+			Field{FieldBundleId, c.cfg.Bid},
+			Field{FieldAttempt, attempt},
+		)
+		var req *http.Request
+		req, err = http.NewRequestWithContext(ctx, ar.method, c.cfg.baseUrl(e)+ar.uri, body)
 		if err != nil {
+			span.End(err)
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+bearer)
+		start := c.cfg.now()
+		resp, err = c.cfg.HttpClient.Do(req)
+		if err != nil {
+			metrics.Request(ar.endpoint, 0, 0, c.cfg.now().Sub(start))
+			span.End(err)
+			// 调用方取消后不再重试
+			if ar.opts.ctx.Err() != nil {
+				return nil, ar.opts.ctx.Err()
+			}
+			c.logger.Log(LevelWarn, "request failed",
+				Field{FieldEndpoint, ar.endpoint},
+				Field{FieldTransactionId, ar.id},
+				Field{FieldAttempt, attempt},
+				Field{FieldError, errorText(err)},
+			)
 			continue
 		}
 		b, _ := io.ReadAll(resp.Body)
 		resp.Body.Close()
-		if resp.StatusCode != http.StatusOK {
-			appErr, ok := newAppErrorFromJson(b)
-			if ok {
+		var appErr AppError
+		isAppErr, errorCode := false, 0
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			if appErr, isAppErr = newAppErrorFromJson(b); isAppErr {
+				errorCode = appErr.ErrorCode()
+			}
+		}
+		metrics.Request(ar.endpoint, resp.StatusCode, errorCode, c.cfg.now().Sub(start))
+		attrs := []Field{{FieldStatus, resp.StatusCode}}
+		if isAppErr {
+			attrs = append(attrs, Field{FieldErrorCode, errorCode})
+		}
+		span.SetAttributes(attrs...)
+		ar.opts.span.SetAttributes(attrs...)
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			span.End(nil)
+		} else if isAppErr {
+			span.End(appErr)
+		} else {
+			span.End(errors.New(resp.Status))
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			err = ErrUnauthorized
+			c.logger.Log(LevelWarn, "request unauthorized",
+				Field{FieldEndpoint, ar.endpoint},
+				Field{FieldTransactionId, ar.id},
+				Field{FieldAttempt, attempt},
+				Field{FieldStatus, resp.StatusCode},
+			)
+			if reSigned {
+				return nil, err
+			}
+			reSigned = true
+			c.tokens.Invalidate(bearer)
+			bearer, err = c.GetBearer()
+			if err != nil {
+				return nil, err
+			}
+			// 重新签名后的重试不计入重试次数
+			attempt--
+			retry = false
+			continue
+		}
+		// 发送消费信息返回 202
+		if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+			if isAppErr {
 				err = appErr
+				c.logger.Log(LevelWarn, "request returned error",
+					Field{FieldEndpoint, ar.endpoint},
+					Field{FieldTransactionId, ar.id},
+					Field{FieldAttempt, attempt},
+					Field{FieldStatus, resp.StatusCode},
+					Field{FieldErrorCode, appErr.ErrorCode()},
+				)
 				if appErr.IsRetryable() {
 					continue
 				}
 				return nil, err
 			}
 			err = errors.New(resp.Status)
+			c.logger.Log(LevelWarn, "request returned error",
+				Field{FieldEndpoint, ar.endpoint},
+				Field{FieldTransactionId, ar.id},
+				Field{FieldAttempt, attempt},
+				Field{FieldStatus, resp.StatusCode},
+			)
 			continue
 		}
+		c.logger.Log(LevelDebug, "request succeeded",
+			Field{FieldEndpoint, ar.endpoint},
+			Field{FieldTransactionId, ar.id},
+			Field{FieldAttempt, attempt},
+			Field{FieldStatus, resp.StatusCode},
+		)
 		r := gjson.ParseBytes(b)
 		return &r, nil
 	}
@@ -232,19 +445,18 @@ func (c *client) doRequest(method, url string, body io.Reader) (*gjson.Result, e
 	return nil, ErrRequestFailed
 }
 
+// Parse 解码签名数据（不校验签名），v 为 nil 时只检查能否解码
+// Decodes signed data without verifying it, when v is nil it only checks that the payload decodes
 func Parse(payload string, v interface{}) error {
 	token, err := jwt.ParseString(payload, jwt.WithVerify(false), jwt.WithValidate(false))
 	if err != nil {
-		log.Println(err)
 		return err
 	}
 	b, err := json.Marshal(token.PrivateClaims())
 	if err != nil {
-		log.Println(err)
 		return err
 	}
 	if v == nil {
-		log.Println(string(b))
 		return nil
 	}
 	return json.Unmarshal(b, v)
@@ -1,8 +1,10 @@
 package appstoreserverapi
 
 import (
-	"encoding/json"
-	"fmt"
+	"flag"
+	"github.com/lhlyu/appstoreserverapi/cassette"
+	"net/http"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -30,135 +32,118 @@ FEjZIaV1pq1/OYCJ
 -----END PRIVATE KEY-----`
 )
 
-func TestClient_GetAllSubscriptionStatuses(t *testing.T) {
-	c, err := NewClient(&Config{
+// 测试回放 testdata/cassettes 中的响应，不需要网络
+// 这些响应是合成的：由 storetest 模拟服务生成，签名证书链是 "storetest Intermediate CA"，并不是沙盒环境的真实响应
+// 使用 -record 时用上面的配置请求沙盒环境，真实的响应会覆盖合成的响应：go test -run TestClient_ -record
+// The tests replay the responses in testdata/cassettes and need no network
+// those responses are synthetic: generated by the storetest fake server and signed by "storetest Intermediate CA", not real sandbox responses
+// with -record they call the sandbox with the config above, the real responses replace the synthetic ones: go test -run TestClient_ -record
+var record = flag.Bool("record", false, "record the cassettes against the sandbox")
+
+// newCassetteClient 回放时使用临时生成的秘钥，录制的请求中不包含 Authorization
+func newCassetteClient(t *testing.T) Client {
+	cfg := &Config{
 		Iss:      ISS,
 		Kid:      KID,
 		Bid:      BID,
 		Pk:       PK,
 		Aud:      AUD,
-		ExpiryIn: time.Second * 6,
+		Evn:      Sandbox,
+		ExpiryIn: time.Minute * 10,
+		TryCount: 1,
+	}
+	mode := cassette.ModeRecord
+	if !*record {
+		mode = cassette.ModeReplay
+		_, cfg.Pk = newTestKey(t)
+	}
+	rec, err := cassette.New(filepath.Join("testdata", "cassettes", t.Name()+".json"), mode, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := rec.Save(); err != nil {
+			t.Error(err)
+		}
 	})
+	cfg.HttpClient = &http.Client{Transport: rec}
+	c, err := NewClient(cfg)
 	if err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
 	}
+	return c
+}
+
+func TestClient_GetAllSubscriptionStatuses(t *testing.T) {
+	c := newCassetteClient(t)
 	r, err := c.ApiGetAllSubscriptionStatuses("180001239612922")
 	if err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
+	}
+	if r.BundleId != BID || len(r.Data) == 0 || len(r.Data[0].LastTransactions) == 0 {
+		t.Fatalf("unexpected response: %s", r.raw)
+	}
+	if got := r.Data[0].LastTransactions[0].SignedTransactionInfo.OriginalTransactionId; got != "180001239612922" {
+		t.Errorf("originalTransactionId = %s, want 180001239612922", got)
 	}
-	b, _ := json.Marshal(r)
-	fmt.Println(string(b))
 }
 
 func TestClient_LookUpOrderId(t *testing.T) {
-	c, err := NewClient(&Config{
-		Iss:      ISS,
-		Kid:      KID,
-		Bid:      BID,
-		Pk:       PK,
-		Aud:      AUD,
-		ExpiryIn: time.Minute * 10,
-	})
-	if err != nil {
-		t.Error(err)
-		return
-	}
+	c := newCassetteClient(t)
 	r, err := c.ApiLookUpOrderId("MQKN8D872M")
 	if err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
+	}
+	if r.Status != 0 || len(r.SignedTransactions) == 0 {
+		t.Errorf("unexpected response: %s", r.raw)
 	}
-	b, _ := json.Marshal(r)
-	fmt.Println(string(b))
 }
 
 func TestClient_GetTransactionHistory(t *testing.T) {
-	c, err := NewClient(&Config{
-		Iss:      ISS,
-		Kid:      KID,
-		Bid:      BID,
-		Pk:       PK,
-		Aud:      AUD,
-		ExpiryIn: time.Minute * 10,
-		TryCount: 1,
-	})
-	if err != nil {
-		t.Error(err)
-		return
-	}
+	c := newCassetteClient(t)
 	r, err := c.ApiGetTransactionHistory("52000104826360", false)
 	if err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
+	}
+	if r.BundleId != BID || len(r.SignedTransactions) == 0 {
+		t.Fatalf("unexpected response: %s", r.raw)
+	}
+	for _, tx := range r.SignedTransactions {
+		if tx.OriginalTransactionId != "52000104826360" {
+			t.Errorf("originalTransactionId = %s, want 52000104826360", tx.OriginalTransactionId)
+		}
 	}
-	b, _ := json.Marshal(r)
-	fmt.Println(string(b))
 }
 
 func TestClient_ApiGetRefundHistory(t *testing.T) {
-	c, err := NewClient(&Config{
-		Iss:      ISS,
-		Kid:      KID,
-		Bid:      BID,
-		Pk:       PK,
-		Aud:      AUD,
-		ExpiryIn: time.Minute * 10,
-	})
-	if err != nil {
-		t.Error(err)
-		return
-	}
+	c := newCassetteClient(t)
 	r, err := c.ApiGetRefundHistory("180001267635832", false)
 	if err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
+	}
+	if len(r.SignedTransactions) == 0 || r.SignedTransactions[0].RevocationDate == 0 {
+		t.Errorf("unexpected response: %s", r.raw)
 	}
-	b, _ := json.Marshal(r)
-	fmt.Println(string(b))
 }
 
 func TestClient_ApiExtendAsubscriptionRenewalDate(t *testing.T) {
-	c, err := NewClient(&Config{
-		Iss:      ISS,
-		Kid:      KID,
-		Bid:      BID,
-		Pk:       PK,
-		Aud:      AUD,
-		ExpiryIn: time.Minute * 10,
-	})
-	if err != nil {
-		t.Error(err)
-		return
-	}
+	c := newCassetteClient(t)
 	r, err := c.ApiExtendAsubscriptionRenewalDate("180001267635832", ExtendRenewalDateRequest{
 		ExtendByDays:      30,
 		ExtendReasonCode:  1,
 		RequestIdentifier: "sdadsa234asfafadfadfas",
 	})
 	if err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
+	}
+	if !r.Success || r.OriginalTransactionId != "180001267635832" || r.EffectiveDate == 0 {
+		t.Errorf("unexpected response: %s", r.raw)
 	}
-	b, _ := json.Marshal(r)
-	fmt.Println(string(b))
 }
 
 func TestClient_ApiSendConsumptionInformation(t *testing.T) {
-	c, err := NewClient(&Config{
-		Iss:      ISS,
-		Kid:      KID,
-		Bid:      BID,
-		Pk:       PK,
-		Aud:      AUD,
-		ExpiryIn: time.Minute * 10,
-	})
-	if err != nil {
-		t.Error(err)
-		return
-	}
-	err = c.ApiSendConsumptionInformation("180001267635832", ConsumptionRequest{
+	c := newCassetteClient(t)
+	err := c.ApiSendConsumptionInformation("180001267635832", ConsumptionRequest{
 		AccountTenure:            0,
 		AppAccountToken:          "",
 		ConsumptionStatus:        0,
@@ -172,7 +157,6 @@ func TestClient_ApiSendConsumptionInformation(t *testing.T) {
 		UserStatus:               0,
 	})
 	if err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
 	}
 }
@@ -0,0 +1,29 @@
+package appstoreserverapi
+
+import "time"
+
+// Clock 时间来源，测试中可以替换为手动推进的时钟，避免等待 token 过期
+// The source of time, tests can replace it with a manually advanced clock instead of sleeping until a token expires
+type Clock interface {
+	Now() time.Time
+}
+
+// ClockFunc 函数形式的 Clock
+// A Clock as a function
+type ClockFunc func() time.Time
+
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// SystemClock 系统时间，Config.Clock 的默认值
+// The system time, the default of Config.Clock
+var SystemClock Clock = ClockFunc(time.Now)
+
+// now 配置的当前时间，未设置 Clock 时使用系统时间
+func (cfg *Config) now() time.Time {
+	if cfg.Clock == nil {
+		return SystemClock.Now()
+	}
+	return cfg.Clock.Now()
+}
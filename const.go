@@ -9,7 +9,18 @@ const (
 	apiGetAllSubscriptionStatusesUri     = "/inApps/v1/subscriptions/"            // + TransactionId
 	apiLookupOrderIdUri                  = "/inApps/v1/lookup/"                   // + orderId
 	apiGetTransactionHistoryUri          = "/inApps/v1/history/"                  // + TransactionId
-	apiGetRefundHistoryUri               = "/inApps/v1/refund/lookup/"            // + TransactionId
+	apiGetRefundHistoryUri               = "/inApps/v2/refund/lookup/"            // + TransactionId
 	apiExtendASubscriptionRenewalDateUri = "/inApps/v1/subscriptions/extend/"     // + TransactionId
 	apiSendConsumptionInformationUri     = "/inApps/v1/transactions/consumption/" // + TransactionId
 )
+
+// 接口名称，用于日志等
+// Endpoint names, used by logs etc.
+const (
+	endpointGetAllSubscriptionStatuses     = "GetAllSubscriptionStatuses"
+	endpointLookUpOrderId                  = "LookUpOrderId"
+	endpointGetTransactionHistory          = "GetTransactionHistory"
+	endpointGetRefundHistory               = "GetRefundHistory"
+	endpointExtendASubscriptionRenewalDate = "ExtendASubscriptionRenewalDate"
+	endpointSendConsumptionInformation     = "SendConsumptionInformation"
+)
@@ -0,0 +1,132 @@
+package appstoreserverapi
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// DecodeMode 解码模式
+// How signed items that fail to decode are handled
+type DecodeMode string
+
+const (
+	// DecodeStrict 严格模式：任意一条签名数据解码失败，整个调用失败，需要设置 Config.DecodeMode 开启
+	// Strict: the whole call fails if any signed item cannot be decoded, opt in with Config.DecodeMode
+	DecodeStrict DecodeMode = "strict"
+	// DecodePartial 部分模式：默认，返回成功解码的结果，同时返回 DecodeErrors
+	// Partial: the default, return the items that decoded, together with DecodeErrors
+	DecodePartial DecodeMode = "partial"
+)
+
+// DecodeError 单条签名数据解码失败
+// A single signed item that could not be decoded
+type DecodeError struct {
+	// 在响应中的路径，例如：signedTransactions.3、data.1.lastTransactions.0.signedRenewalInfo，唯一标识一条数据
+	// Path in the response, eg: signedTransactions.3, data.1.lastTransactions.0.signedRenewalInfo, uniquely identifies the item
+	Path string
+	// 所属分组的下标，只用于 ApiGetAllSubscriptionStatuses 的 data，其它接口为 0
+	// Index of the group, only used for the data of ApiGetAllSubscriptionStatuses, 0 for other endpoints
+	Group int
+	// 在所属列表中的下标
+	// Index in the list the item belongs to
+	Index int
+	Err   error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode %s: %v", e.Path, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// DecodeErrors 一次调用中所有解码失败的条目
+// All the items of one call that could not be decoded
+type DecodeErrors []*DecodeError
+
+func (e DecodeErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, v := range e {
+		msgs = append(msgs, v.Error())
+	}
+	return fmt.Sprintf("%d signed items failed to decode: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// Indices 返回解码失败的下标，ApiGetAllSubscriptionStatuses 中不同分组的下标会重复，此时使用 Group 或 Path
+// Indices of the items that failed, they repeat across the groups of ApiGetAllSubscriptionStatuses, use Group or Path there
+func (e DecodeErrors) Indices() []int {
+	indices := make([]int, 0, len(e))
+	for _, v := range e {
+		indices = append(indices, v.Index)
+	}
+	return indices
+}
+
+// decoder 收集一次调用中的解码错误
+type decoder struct {
+	ctx      context.Context
+	tracer   Tracer
+	mode     DecodeMode
+	endpoint string
+	logger   Logger
+	verifier *Verifier
+	errs     DecodeErrors
+}
+
+func (c *client) newDecoder(ctx context.Context, endpoint string) *decoder {
+	return &decoder{
+		ctx:      ctx,
+		tracer:   c.cfg.tracer(),
+		mode:     c.cfg.DecodeMode,
+		endpoint: endpoint,
+		logger:   c.logger,
+		verifier: c.cfg.Verifier,
+	}
+}
+
+// decode 解码一条签名数据，失败时记录错误并返回 false
+func (d *decoder) decode(index int, path string, payload string, v interface{}) bool {
+	return d.decodeAt(0, index, path, payload, v)
+}
+
+// decodeAt 解码分组中的一条签名数据
+func (d *decoder) decodeAt(group, index int, path string, payload string, v interface{}) bool {
+	if err := d.parse(path, payload, v); err != nil {
+		d.logger.Log(LevelWarn, "decode signed item failed",
+			Field{FieldEndpoint, d.endpoint},
+			Field{FieldPath, path},
+			Field{FieldError, err.Error()},
+		)
+		d.errs = append(d.errs, &DecodeError{
+			Path:  path,
+			Group: group,
+			Index: index,
+			Err:   err,
+		})
+		return false
+	}
+	return true
+}
+
+func (d *decoder) parse(path string, payload string, v interface{}) error {
+	if d.verifier != nil {
+		_, span := d.tracer.Start(d.ctx, SpanVerify,
+			Field{FieldEndpoint, d.endpoint},
+			Field{FieldPath, path},
+		)
+		err := d.verifier.Verify(payload, v)
+		span.End(err)
+		return err
+	}
+	return Parse(payload, v)
+}
+
+// result 严格模式下有错误时丢弃结果，其它模式按部分模式处理
+func (d *decoder) result() (discard bool, err error) {
+	if len(d.errs) == 0 {
+		return false, nil
+	}
+	return d.mode == DecodeStrict, d.errs
+}
@@ -0,0 +1,131 @@
+package appstoreserverapi
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newDecodeTestClient 返回的两个分组中各有一条续订信息无法解码，第二个分组还有一条交易无法解码
+func newDecodeTestClient(t *testing.T, mode DecodeMode) Client {
+	key, pk := newTestKey(t)
+	sign := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		signed, err := jws.Sign(b, jws.WithKey(jwa.ES256, key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(signed)
+	}
+	last := func(id string, badTransaction, badRenewal bool) map[string]interface{} {
+		tx := sign(JWSTransactionDecodedPayload{TransactionId: id, OriginalTransactionId: id})
+		renewal := sign(JWSRenewalInfoDecodedPayload{OriginalTransactionId: id})
+		if badTransaction {
+			tx = "not a jws"
+		}
+		if badRenewal {
+			renewal = "not a jws"
+		}
+		return map[string]interface{}{
+			"originalTransactionId": id,
+			"status":                1,
+			"signedTransactionInfo": tx,
+			"signedRenewalInfo":     renewal,
+		}
+	}
+	body, _ := json.Marshal(map[string]interface{}{
+		"environment": "Sandbox",
+		"bundleId":    BID,
+		"data": []interface{}{
+			map[string]interface{}{
+				"subscriptionGroupIdentifier": "a",
+				"lastTransactions":            []interface{}{last("1", false, true), last("2", false, false)},
+			},
+			map[string]interface{}{
+				"subscriptionGroupIdentifier": "b",
+				"lastTransactions":            []interface{}{last("3", true, false), last("4", false, true)},
+			},
+		},
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(body)
+	}))
+	t.Cleanup(srv.Close)
+	c, err := NewClient(&Config{
+		Iss:        ISS,
+		Kid:        KID,
+		Bid:        BID,
+		Pk:         pk,
+		Evn:        LocalTesting,
+		BaseUrls:   map[Env]string{LocalTesting: srv.URL},
+		DecodeMode: mode,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestDecodeStrict(t *testing.T) {
+	c := newDecodeTestClient(t, DecodeStrict)
+	r, err := c.ApiGetAllSubscriptionStatuses("1")
+	errs := DecodeErrors{}
+	if r != nil || !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatalf("r = %+v, err = %v", r, err)
+	}
+}
+
+func TestDecodeDefault(t *testing.T) {
+	// 默认部分模式，和之前一样返回结果
+	c := newDecodeTestClient(t, "")
+	r, err := c.ApiGetAllSubscriptionStatuses("1")
+	errs := DecodeErrors{}
+	if r == nil || len(r.Data) != 2 || !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatalf("r = %+v, err = %v", r, err)
+	}
+}
+
+func TestDecodePartial(t *testing.T) {
+	c := newDecodeTestClient(t, DecodePartial)
+	r, err := c.ApiGetAllSubscriptionStatuses("1")
+	errs := DecodeErrors{}
+	if r == nil || !errors.As(err, &errs) {
+		t.Fatalf("r = %+v, err = %v", r, err)
+	}
+
+	want := []struct {
+		path         string
+		group, index int
+	}{
+		{"data.0.lastTransactions.0.signedRenewalInfo", 0, 0},
+		{"data.1.lastTransactions.0.signedTransactionInfo", 1, 0},
+		{"data.1.lastTransactions.1.signedRenewalInfo", 1, 1},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("errs = %v", errs)
+	}
+	for i, w := range want {
+		if errs[i].Path != w.path || errs[i].Group != w.group || errs[i].Index != w.index {
+			t.Errorf("errs[%d] = %+v, want %+v", i, errs[i], w)
+		}
+	}
+	if indices := errs.Indices(); len(indices) != 3 || indices[0] != 0 || indices[2] != 1 {
+		t.Errorf("indices = %v", indices)
+	}
+
+	// 续订信息解码失败时保留交易，交易解码失败时跳过
+	if len(r.Data) != 2 || len(r.Data[0].LastTransactions) != 2 || len(r.Data[1].LastTransactions) != 1 {
+		t.Fatalf("data = %+v", r.Data)
+	}
+	kept := r.Data[0].LastTransactions[0]
+	if kept.SignedTransactionInfo.TransactionId != "1" || kept.SignedRenewalInfo.OriginalTransactionId != "" {
+		t.Errorf("kept = %+v", kept)
+	}
+	if r.Data[1].LastTransactions[0].SignedTransactionInfo.TransactionId != "4" {
+		t.Errorf("group b = %+v", r.Data[1].LastTransactions)
+	}
+}
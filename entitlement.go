@@ -0,0 +1,192 @@
+package appstoreserverapi
+
+import (
+	"time"
+)
+
+// 权益判断
+// Entitlements computed from transactions and renewal infos
+// doc: https://developer.apple.com/documentation/appstoreserverapi/status
+// doc: https://developer.apple.com/documentation/appstoreserverapi/inappownershiptype
+
+// EntitlementReason 权益有效或无效的原因
+// Why an entitlement is active or not
+type EntitlementReason string
+
+const (
+	// ReasonActive 购买或订阅有效
+	ReasonActive EntitlementReason = "active"
+	// ReasonFamilyShared 家庭共享获得，有效
+	ReasonFamilyShared EntitlementReason = "familyShared"
+	// ReasonGracePeriod 扣款失败，在宽限期内仍然有效
+	ReasonGracePeriod EntitlementReason = "gracePeriod"
+	// ReasonBillingRetry 扣款失败，账单重试中，无效，扣款成功后恢复
+	ReasonBillingRetry EntitlementReason = "billingRetry"
+	// ReasonRevoked 退款或者被家庭共享撤销，无效
+	ReasonRevoked EntitlementReason = "revoked"
+	// ReasonExpired 已过期，无效
+	ReasonExpired EntitlementReason = "expired"
+)
+
+const ownershipFamilyShared = "FAMILY_SHARED"
+
+// 订阅状态
+// doc: https://developer.apple.com/documentation/appstoreserverapi/status
+const (
+	statusBillingRetry = 3
+	statusRevoked      = 5
+)
+
+// EntitlementMapping 产品ID到权益的映射，一个产品可以提供多个权益
+// Maps product IDs to entitlements, one product may grant several
+type EntitlementMapping map[string][]string
+
+// Entitlement 一个权益的结果
+// The answer for one entitlement
+type Entitlement struct {
+	Name   string            `json:"name"`
+	Active bool              `json:"active"`
+	Reason EntitlementReason `json:"reason"`
+	// 有效期截止时间，不会过期的购买为零值；无效时为过期、撤销的时间
+	// When access ends, zero for purchases that never expire; for inactive entitlements, when it expired or was revoked
+	ActiveUntil           time.Time `json:"activeUntil"`
+	ProductId             string    `json:"productId"`
+	OriginalTransactionId string    `json:"originalTransactionId"`
+	TransactionId         string    `json:"transactionId"`
+}
+
+// Entitlements 权益名称到结果
+// Entitlement name to its answer
+type Entitlements map[string]Entitlement
+
+// Active 权益是否有效
+// Whether the entitlement is active
+func (e Entitlements) Active(name string) bool {
+	return e[name].Active
+}
+
+// EntitlementEngine 根据交易和续订信息计算权益
+// Computes entitlements from transactions and renewal infos
+type EntitlementEngine struct {
+	mapping EntitlementMapping
+	clock   Clock
+}
+
+// NewEntitlementEngine 创建权益计算器
+// clock: 为空时使用 SystemClock
+// clock: SystemClock when nil
+func NewEntitlementEngine(mapping EntitlementMapping, clock Clock) *EntitlementEngine {
+	if clock == nil {
+		clock = SystemClock
+	}
+	return &EntitlementEngine{
+		mapping: mapping,
+		clock:   clock,
+	}
+}
+
+// FromStatusResponse 从获取所有订阅状态的结果计算
+// Computes from the result of Get All Subscription Statuses
+func (en *EntitlementEngine) FromStatusResponse(r *StatusResponse) Entitlements {
+	result := make(Entitlements)
+	if r == nil {
+		return result
+	}
+	now := en.clock.Now()
+	for _, data := range r.Data {
+		for _, last := range data.LastTransactions {
+			renewal := last.SignedRenewalInfo
+			en.merge(result, en.evaluate(last.SignedTransactionInfo, &renewal, last.Status, now))
+		}
+	}
+	return result
+}
+
+// Evaluate 从交易和续订信息计算，例如交易历史和通知中的数据
+// 续订信息按 originalTransactionId 和交易对应
+// Computes from transactions and renewal infos, eg: the transaction history or notification data
+// renewal infos are matched to transactions by originalTransactionId
+func (en *EntitlementEngine) Evaluate(transactions []JWSTransactionDecodedPayload, renewals ...JWSRenewalInfoDecodedPayload) Entitlements {
+	byOriginal := make(map[string]*JWSRenewalInfoDecodedPayload, len(renewals))
+	for i := range renewals {
+		byOriginal[renewals[i].OriginalTransactionId] = &renewals[i]
+	}
+	result := make(Entitlements)
+	now := en.clock.Now()
+	for _, tx := range transactions {
+		en.merge(result, en.evaluate(tx, byOriginal[tx.OriginalTransactionId], 0, now))
+	}
+	return result
+}
+
+// evaluate 一笔交易提供的权益，status 为 0 时只根据日期判断
+func (en *EntitlementEngine) evaluate(tx JWSTransactionDecodedPayload, renewal *JWSRenewalInfoDecodedPayload, status int64, now time.Time) []Entitlement {
+	names := en.mapping[tx.ProductId]
+	// 已升级的交易被新的交易代替
+	if len(names) == 0 || tx.IsUpgraded {
+		return nil
+	}
+	e := Entitlement{
+		ProductId:             tx.ProductId,
+		OriginalTransactionId: tx.OriginalTransactionId,
+		TransactionId:         tx.TransactionId,
+	}
+	expires := fromMillis(tx.ExpiresDate)
+	switch {
+	case tx.RevocationDate > 0 || status == statusRevoked:
+		e.Reason = ReasonRevoked
+		e.ActiveUntil = fromMillis(tx.RevocationDate)
+	case tx.ExpiresDate == 0 || expires.After(now):
+		e.Active = true
+		e.Reason = ReasonActive
+		e.ActiveUntil = expires
+		if tx.InAppOwnershipType == ownershipFamilyShared {
+			e.Reason = ReasonFamilyShared
+		}
+	case renewal != nil && fromMillis(renewal.GracePeriodExpiresDate).After(now):
+		e.Active = true
+		e.Reason = ReasonGracePeriod
+		e.ActiveUntil = fromMillis(renewal.GracePeriodExpiresDate)
+	case (renewal != nil && renewal.IsInBillingRetryPeriod) || status == statusBillingRetry:
+		e.Reason = ReasonBillingRetry
+		e.ActiveUntil = expires
+	default:
+		e.Reason = ReasonExpired
+		e.ActiveUntil = expires
+	}
+	result := make([]Entitlement, 0, len(names))
+	for _, name := range names {
+		e.Name = name
+		result = append(result, e)
+	}
+	return result
+}
+
+// merge 同一个权益有多笔交易时，有效的优先，其次截止时间晚的优先
+func (en *EntitlementEngine) merge(result Entitlements, entitlements []Entitlement) {
+	for _, e := range entitlements {
+		old, ok := result[e.Name]
+		if !ok || better(e, old) {
+			result[e.Name] = e
+		}
+	}
+}
+
+func better(a, b Entitlement) bool {
+	if a.Active != b.Active {
+		return a.Active
+	}
+	// 不会过期的购买
+	if a.ActiveUntil.IsZero() != b.ActiveUntil.IsZero() {
+		return a.Active && a.ActiveUntil.IsZero()
+	}
+	return a.ActiveUntil.After(b.ActiveUntil)
+}
+
+// fromMillis 毫秒时间戳，0 为零值
+func fromMillis(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, ms*int64(time.Millisecond))
+}
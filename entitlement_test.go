@@ -0,0 +1,126 @@
+package appstoreserverapi
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEntitlementEngine_Evaluate(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	ms := func(d time.Duration) int64 {
+		return now.Add(d).UnixNano() / int64(time.Millisecond)
+	}
+	engine := NewEntitlementEngine(EntitlementMapping{
+		"monthly":  {"pro"},
+		"yearly":   {"pro", "cloud"},
+		"lifetime": {"pro"},
+	}, ClockFunc(func() time.Time { return now }))
+
+	tests := []struct {
+		name       string
+		tx         JWSTransactionDecodedPayload
+		renewal    JWSRenewalInfoDecodedPayload
+		active     bool
+		reason     EntitlementReason
+		activeTill time.Time
+	}{
+		{
+			name:       "active",
+			tx:         JWSTransactionDecodedPayload{OriginalTransactionId: "1", ProductId: "monthly", ExpiresDate: ms(time.Hour)},
+			active:     true,
+			reason:     ReasonActive,
+			activeTill: now.Add(time.Hour),
+		},
+		{
+			name:       "family shared",
+			tx:         JWSTransactionDecodedPayload{OriginalTransactionId: "1", ProductId: "monthly", ExpiresDate: ms(time.Hour), InAppOwnershipType: "FAMILY_SHARED"},
+			active:     true,
+			reason:     ReasonFamilyShared,
+			activeTill: now.Add(time.Hour),
+		},
+		{
+			name:       "grace period",
+			tx:         JWSTransactionDecodedPayload{OriginalTransactionId: "1", ProductId: "monthly", ExpiresDate: ms(-time.Hour)},
+			renewal:    JWSRenewalInfoDecodedPayload{OriginalTransactionId: "1", IsInBillingRetryPeriod: true, GracePeriodExpiresDate: ms(24 * time.Hour)},
+			active:     true,
+			reason:     ReasonGracePeriod,
+			activeTill: now.Add(24 * time.Hour),
+		},
+		{
+			name:       "billing retry",
+			tx:         JWSTransactionDecodedPayload{OriginalTransactionId: "1", ProductId: "monthly", ExpiresDate: ms(-time.Hour)},
+			renewal:    JWSRenewalInfoDecodedPayload{OriginalTransactionId: "1", IsInBillingRetryPeriod: true},
+			reason:     ReasonBillingRetry,
+			activeTill: now.Add(-time.Hour),
+		},
+		{
+			name:       "revoked",
+			tx:         JWSTransactionDecodedPayload{OriginalTransactionId: "1", ProductId: "monthly", ExpiresDate: ms(time.Hour), RevocationDate: ms(-time.Minute)},
+			reason:     ReasonRevoked,
+			activeTill: now.Add(-time.Minute),
+		},
+		{
+			name:       "expired",
+			tx:         JWSTransactionDecodedPayload{OriginalTransactionId: "1", ProductId: "monthly", ExpiresDate: ms(-time.Hour)},
+			reason:     ReasonExpired,
+			activeTill: now.Add(-time.Hour),
+		},
+		{
+			name:   "lifetime",
+			tx:     JWSTransactionDecodedPayload{OriginalTransactionId: "1", ProductId: "lifetime"},
+			active: true,
+			reason: ReasonActive,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := engine.Evaluate([]JWSTransactionDecodedPayload{tt.tx}, tt.renewal)["pro"]
+			if e.Active != tt.active || e.Reason != tt.reason || !e.ActiveUntil.Equal(tt.activeTill) {
+				t.Errorf("got %+v, want active %v, reason %s, until %s", e, tt.active, tt.reason, tt.activeTill)
+			}
+		})
+	}
+}
+
+func TestEntitlementEngine_FromStatusResponse(t *testing.T) {
+	now := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	ms := func(d time.Duration) int64 {
+		return now.Add(d).UnixNano() / int64(time.Millisecond)
+	}
+	engine := NewEntitlementEngine(EntitlementMapping{
+		"monthly": {"pro"},
+		"yearly":  {"pro", "cloud"},
+	}, ClockFunc(func() time.Time { return now }))
+
+	// 从月度升级到年度：月度交易被标记为已升级
+	r := &StatusResponse{Data: []StatusData{{
+		SubscriptionGroupIdentifier: "group",
+		LastTransactions: []LastTransaction{
+			{
+				OriginalTransactionId: "1",
+				Status:                1,
+				SignedTransactionInfo: JWSTransactionDecodedPayload{TransactionId: "11", OriginalTransactionId: "1", ProductId: "monthly", ExpiresDate: ms(20 * 24 * time.Hour), IsUpgraded: true},
+			},
+			{
+				OriginalTransactionId: "2",
+				Status:                1,
+				SignedTransactionInfo: JWSTransactionDecodedPayload{TransactionId: "21", OriginalTransactionId: "2", ProductId: "yearly", ExpiresDate: ms(365 * 24 * time.Hour)},
+			},
+			{
+				OriginalTransactionId: "3",
+				Status:                5,
+				SignedTransactionInfo: JWSTransactionDecodedPayload{TransactionId: "31", OriginalTransactionId: "3", ProductId: "monthly", ExpiresDate: ms(24 * time.Hour)},
+			},
+		},
+	}}}
+	result := engine.FromStatusResponse(r)
+	if !result.Active("pro") || !result.Active("cloud") {
+		t.Fatalf("entitlements = %+v, want pro and cloud", result)
+	}
+	if got := result["pro"].TransactionId; got != "21" {
+		t.Errorf("pro from transaction %s, want the yearly one", got)
+	}
+	if result.Active("missing") {
+		t.Error("unknown entitlement should be inactive")
+	}
+}
@@ -0,0 +1,56 @@
+package appstoreserverapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNewClient_BaseUrls(t *testing.T) {
+	_, pk := newTestKey(t)
+	_, err := NewClient(&Config{Iss: ISS, Kid: KID, Bid: BID, Pk: pk, Evn: LocalTesting})
+	if err != ErrConfigInvalid {
+		t.Errorf("err = %v, want %v", err, ErrConfigInvalid)
+	}
+}
+
+func TestClient_SandboxFallback(t *testing.T) {
+	_, pk := newTestKey(t)
+	production := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"errorCode": 4040010, "errorMessage": "Transaction id not found."}`))
+	}))
+	defer production.Close()
+	sandbox := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"environment": "Sandbox", "bundleId": "` + BID + `", "data": []}`))
+	}))
+	defer sandbox.Close()
+
+	c, err := NewClient(&Config{
+		Iss:      ISS,
+		Kid:      KID,
+		Bid:      BID,
+		Pk:       pk,
+		TryCount: 1,
+		BaseUrls: map[Env]string{
+			Production: production.URL,
+			Sandbox:    sandbox.URL,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = c.ApiGetAllSubscriptionStatuses("2000000100")
+	if !isTransactionNotFound(err) {
+		t.Errorf("err = %v, want transaction not found", err)
+	}
+
+	r, err := c.ApiGetAllSubscriptionStatuses("2000000100", WithSandboxFallback(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Env() != Sandbox || r.Environment != "Sandbox" {
+		t.Errorf("env = %s, environment = %s, want sandbox", r.Env(), r.Environment)
+	}
+}
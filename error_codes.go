@@ -86,6 +86,20 @@ var (
 	InvalidRequestIdentifierError        = newAppError(4000011, "Invalid request identifier")
 	InvalidRequestRevisionError          = newAppError(4000005, "Invalid request revision")
 	OriginalTransactionIdNotFoundError   = newAppError(4040005, "Original transaction id not found")
+	TransactionIdNotFoundError           = newAppError(4040010, "Transaction id not found")
 	SubscriptionExtensionIneligibleError = newAppError(4030004, "Forbidden - subscription state ineligible for extension")
 	SubscriptionMaxExtensionError        = newAppError(4030005, "Forbidden - subscription has reached maximum extension count")
 )
+
+// isTransactionNotFound 交易不存在，可能是沙盒环境的交易
+func isTransactionNotFound(err error) bool {
+	appErr, ok := err.(AppError)
+	if !ok {
+		return false
+	}
+	switch appErr.ErrorCode() {
+	case TransactionIdNotFoundError.ErrorCode(), OriginalTransactionIdNotFoundError.ErrorCode():
+		return true
+	}
+	return false
+}
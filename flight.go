@@ -0,0 +1,50 @@
+package appstoreserverapi
+
+import (
+	"context"
+	"github.com/tidwall/gjson"
+	"sync"
+)
+
+// flightGroup 合并进行中的相同请求：第一个调用发送请求，之后的调用等待并共用结果
+type flightGroup struct {
+	lock  sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	// 请求完成后关闭
+	done chan struct{}
+	r    *gjson.Result
+	e    Env
+	err  error
+}
+
+// do 等待中的调用在自己的 ctx 取消后立即返回 ctx.Err()，不影响进行中的请求
+func (g *flightGroup) do(ctx context.Context, key string, fn func() (*gjson.Result, Env, error)) (*gjson.Result, Env, error) {
+	g.lock.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+	if call, ok := g.calls[key]; ok {
+		g.lock.Unlock()
+		select {
+		case <-call.done:
+			return call.r, call.e, call.err
+		case <-ctx.Done():
+			return nil, "", ctx.Err()
+		}
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.lock.Unlock()
+
+	defer func() {
+		g.lock.Lock()
+		delete(g.calls, key)
+		g.lock.Unlock()
+		close(call.done)
+	}()
+	call.r, call.e, call.err = fn()
+	return call.r, call.e, call.err
+}
@@ -0,0 +1,30 @@
+package appstoreserverapi_test
+
+import (
+	"github.com/lhlyu/appstoreserverapi"
+	"github.com/lhlyu/appstoreserverapi/storetest"
+	"testing"
+)
+
+// newStoreTestClient 启动模拟服务并添加场景，configure 不为空时先修改配置再创建客户端，测试结束时关闭服务
+// Starts the fake server with the scenarios, lets configure adjust the config before creating the client, the server is closed when the test ends
+func newStoreTestClient(t *testing.T, configure func(cfg *appstoreserverapi.Config), scenarios ...*storetest.Scenario) (*storetest.Server, appstoreserverapi.Client) {
+	t.Helper()
+	s, err := storetest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	for _, sc := range scenarios {
+		s.AddScenario(sc)
+	}
+	cfg := s.Config()
+	if configure != nil {
+		configure(cfg)
+	}
+	client, err := appstoreserverapi.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, client
+}
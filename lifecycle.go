@@ -0,0 +1,273 @@
+package appstoreserverapi
+
+import (
+	"errors"
+)
+
+// 订阅生命周期状态机
+// Subscription lifecycle state machine
+// doc: https://developer.apple.com/documentation/appstoreservernotifications/notificationtype
+
+var (
+	ErrLifecycleOutOfOrder = errors.New("event is older than the last applied event")
+	ErrLifecycleMismatch   = errors.New("event belongs to another original transaction")
+)
+
+// SubscriptionState 订阅状态
+// The state of a subscription
+type SubscriptionState string
+
+const (
+	// StateUnknown 还没有处理任何事件
+	StateUnknown SubscriptionState = ""
+	// StateTrial 介绍性优惠期内
+	StateTrial SubscriptionState = "trial"
+	// StateActive 订阅有效
+	StateActive SubscriptionState = "active"
+	// StateGracePeriod 扣款失败，宽限期内
+	StateGracePeriod SubscriptionState = "gracePeriod"
+	// StateBillingRetry 扣款失败，账单重试中
+	StateBillingRetry SubscriptionState = "billingRetry"
+	// StateExpired 已过期
+	StateExpired SubscriptionState = "expired"
+	// StateRevoked 家庭共享被撤销
+	StateRevoked SubscriptionState = "revoked"
+	// StateRefunded 已退款
+	StateRefunded SubscriptionState = "refunded"
+)
+
+// 介绍性优惠
+// doc: https://developer.apple.com/documentation/appstoreserverapi/offertype
+const offerTypeIntroductory = 1
+
+// LifecycleTransition 状态变化
+// A state change
+type LifecycleTransition struct {
+	OriginalTransactionId string            `json:"originalTransactionId"`
+	From                  SubscriptionState `json:"from"`
+	To                    SubscriptionState `json:"to"`
+	// 引起变化的事件：通知类型（和子类型），或者 transaction、renewalInfo
+	// What caused it: the notification type (and subtype), or transaction, renewalInfo
+	Cause      string `json:"cause"`
+	SignedDate int64  `json:"signedDate"`
+}
+
+// 快照事件的 Cause
+const (
+	causeTransaction = "transaction"
+	causeRenewalInfo = "renewalInfo"
+)
+
+// SubscriptionLifecycle 一个 originalTransactionId 的状态机
+// 按 signedDate 顺序处理通知和续订信息，早于已处理事件的会被拒绝；
+// 交易快照（历史记录、订阅状态）的 signedDate 是 Apple 返回结果的时间，按购买时间和过期时间排序，之前周期的交易不覆盖保存的交易；
+// 可以用 JSON 序列化保存
+// The state machine of one originalTransactionId
+// Notifications and renewal infos are applied in signedDate order, older events are rejected;
+// transaction snapshots (history, statuses) are signed when Apple answers, so they are ordered by purchase and expiry date instead
+// and a transaction of an earlier period does not overwrite the stored one; it serializes to JSON
+type SubscriptionLifecycle struct {
+	OriginalTransactionId string            `json:"originalTransactionId"`
+	State                 SubscriptionState `json:"state"`
+	// 最后处理的通知或续订信息的 signedDate
+	// signedDate of the last applied notification or renewal info
+	SignedDate int64 `json:"signedDate"`
+
+	TransactionId          string `json:"transactionId,omitempty"`
+	ProductId              string `json:"productId,omitempty"`
+	PurchaseDate           int64  `json:"purchaseDate,omitempty"`
+	ExpiresDate            int64  `json:"expiresDate,omitempty"`
+	OfferType              int64  `json:"offerType,omitempty"`
+	InAppOwnershipType     string `json:"inAppOwnershipType,omitempty"`
+	RevocationDate         int64  `json:"revocationDate,omitempty"`
+	AutoRenewStatus        int64  `json:"autoRenewStatus"`
+	IsInBillingRetryPeriod bool   `json:"isInBillingRetryPeriod,omitempty"`
+	GracePeriodExpiresDate int64  `json:"gracePeriodExpiresDate,omitempty"`
+}
+
+// NewSubscriptionLifecycle 创建状态机
+// Creates a state machine
+func NewSubscriptionLifecycle(originalTransactionId string) *SubscriptionLifecycle {
+	return &SubscriptionLifecycle{OriginalTransactionId: originalTransactionId}
+}
+
+// ApplyNotification 处理 V2 通知，状态没有变化时返回 nil
+// Applies a V2 notification, returns nil when the state did not change
+func (l *SubscriptionLifecycle) ApplyNotification(n *ResponseBodyV2DecodedPayload) (*LifecycleTransition, error) {
+	tx, renewal := n.Data.SignedTransactionInfo, n.Data.SignedRenewalInfo
+	if err := l.check(tx.OriginalTransactionId, n.SignedDate); err != nil {
+		return nil, err
+	}
+	if renewal.OriginalTransactionId != "" && renewal.OriginalTransactionId != l.OriginalTransactionId {
+		return nil, ErrLifecycleMismatch
+	}
+	// 通知中的交易是之前的周期时，例如旧周期的退款，不改变当前周期的状态
+	current := true
+	if tx.OriginalTransactionId != "" {
+		current = l.updateTransaction(tx)
+	}
+	if renewal.OriginalTransactionId != "" {
+		l.updateRenewalInfo(renewal)
+	}
+
+	state := l.derive(n.SignedDate)
+	switch n.NotificationType {
+	case NotificationTypeDidFailToRenew:
+		state = StateBillingRetry
+		if n.Subtype == SubtypeGracePeriod {
+			state = StateGracePeriod
+		}
+	case NotificationTypeGracePeriodExpired:
+		state = StateBillingRetry
+	case NotificationTypeExpired:
+		if current {
+			state = StateExpired
+		}
+	case NotificationTypeRefund:
+		if current {
+			state = StateRefunded
+		}
+	case NotificationTypeRevoke:
+		if current {
+			state = StateRevoked
+		}
+	}
+	cause := n.NotificationType
+	if n.Subtype != "" {
+		cause += "/" + n.Subtype
+	}
+	l.SignedDate = n.SignedDate
+	return l.transition(state, cause, n.SignedDate), nil
+}
+
+// ApplyTransaction 处理交易快照，按 signedDate 和最后处理的事件中较晚的时间计算状态
+// 之前周期的交易被忽略，返回 nil
+// Applies a transaction snapshot, the state is computed as of the later of its signedDate and the last applied event
+// a transaction of an earlier period is ignored and returns nil
+func (l *SubscriptionLifecycle) ApplyTransaction(tx JWSTransactionDecodedPayload) (*LifecycleTransition, error) {
+	if tx.OriginalTransactionId != "" && tx.OriginalTransactionId != l.OriginalTransactionId {
+		return nil, ErrLifecycleMismatch
+	}
+	if !l.updateTransaction(tx) {
+		return nil, nil
+	}
+	at := tx.SignedDate
+	if at < l.SignedDate {
+		at = l.SignedDate
+	}
+	return l.transition(l.derive(at), causeTransaction, at), nil
+}
+
+// ApplyRenewalInfo 处理续订信息快照，按 signedDate 时的状态计算
+// Applies a renewal info snapshot, the state is computed as of its signedDate
+func (l *SubscriptionLifecycle) ApplyRenewalInfo(renewal JWSRenewalInfoDecodedPayload) (*LifecycleTransition, error) {
+	if err := l.check(renewal.OriginalTransactionId, renewal.SignedDate); err != nil {
+		return nil, err
+	}
+	l.updateRenewalInfo(renewal)
+	l.SignedDate = renewal.SignedDate
+	return l.transition(l.derive(renewal.SignedDate), causeRenewalInfo, renewal.SignedDate), nil
+}
+
+func (l *SubscriptionLifecycle) check(originalTransactionId string, signedDate int64) error {
+	if originalTransactionId != "" && originalTransactionId != l.OriginalTransactionId {
+		return ErrLifecycleMismatch
+	}
+	if signedDate < l.SignedDate {
+		return ErrLifecycleOutOfOrder
+	}
+	return nil
+}
+
+// updateTransaction 保存交易，之前周期的交易不保存，返回 false
+func (l *SubscriptionLifecycle) updateTransaction(tx JWSTransactionDecodedPayload) bool {
+	if l.isEarlierPeriod(tx) {
+		return false
+	}
+	l.TransactionId = tx.TransactionId
+	l.ProductId = tx.ProductId
+	l.PurchaseDate = tx.PurchaseDate
+	l.ExpiresDate = tx.ExpiresDate
+	l.OfferType = tx.OfferType
+	l.InAppOwnershipType = tx.InAppOwnershipType
+	l.RevocationDate = tx.RevocationDate
+	return true
+}
+
+// isEarlierPeriod tx 是保存的交易之前的周期：购买时间更早，购买时间相同时过期时间更早
+// 同一笔交易总是更新，例如退款后的 revocationDate
+func (l *SubscriptionLifecycle) isEarlierPeriod(tx JWSTransactionDecodedPayload) bool {
+	if l.TransactionId == "" || tx.TransactionId == l.TransactionId {
+		return false
+	}
+	if tx.PurchaseDate != l.PurchaseDate {
+		return tx.PurchaseDate < l.PurchaseDate
+	}
+	return tx.ExpiresDate < l.ExpiresDate
+}
+
+func (l *SubscriptionLifecycle) updateRenewalInfo(renewal JWSRenewalInfoDecodedPayload) {
+	l.AutoRenewStatus = renewal.AutoRenewStatus
+	l.IsInBillingRetryPeriod = renewal.IsInBillingRetryPeriod
+	l.GracePeriodExpiresDate = renewal.GracePeriodExpiresDate
+}
+
+// derive 根据保存的交易和续订信息计算 at 时的状态
+func (l *SubscriptionLifecycle) derive(at int64) SubscriptionState {
+	switch {
+	case l.TransactionId == "":
+		return l.State
+	case l.RevocationDate > 0 && l.InAppOwnershipType == ownershipFamilyShared:
+		return StateRevoked
+	case l.RevocationDate > 0:
+		return StateRefunded
+	case l.ExpiresDate > at && l.OfferType == offerTypeIntroductory:
+		return StateTrial
+	case l.ExpiresDate > at:
+		return StateActive
+	case l.GracePeriodExpiresDate > at:
+		return StateGracePeriod
+	case l.IsInBillingRetryPeriod:
+		return StateBillingRetry
+	}
+	return StateExpired
+}
+
+func (l *SubscriptionLifecycle) transition(state SubscriptionState, cause string, signedDate int64) *LifecycleTransition {
+	if state == l.State {
+		return nil
+	}
+	t := &LifecycleTransition{
+		OriginalTransactionId: l.OriginalTransactionId,
+		From:                  l.State,
+		To:                    state,
+		Cause:                 cause,
+		SignedDate:            signedDate,
+	}
+	l.State = state
+	return t
+}
+
+// SubscriptionLifecycles 按 originalTransactionId 保存的状态机，可以用 JSON 序列化保存
+// State machines keyed by originalTransactionId, serializes to JSON
+type SubscriptionLifecycles map[string]*SubscriptionLifecycle
+
+// ApplyNotification 把通知交给对应的状态机，不存在时创建
+// 不包含交易的通知（例如 TEST、汇总通知）会被忽略
+// Routes the notification to its state machine, creating it when missing
+// notifications without a transaction, such as TEST or summaries, are ignored
+func (ls SubscriptionLifecycles) ApplyNotification(n *ResponseBodyV2DecodedPayload) (*LifecycleTransition, error) {
+	id := n.Data.SignedTransactionInfo.OriginalTransactionId
+	if id == "" {
+		id = n.Data.SignedRenewalInfo.OriginalTransactionId
+	}
+	if id == "" {
+		return nil, nil
+	}
+	l, ok := ls[id]
+	if !ok {
+		l = NewSubscriptionLifecycle(id)
+		ls[id] = l
+	}
+	return l.ApplyNotification(n)
+}
@@ -0,0 +1,205 @@
+package appstoreserverapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSubscriptionLifecycle_Notifications(t *testing.T) {
+	day := int64(24 * time.Hour / time.Millisecond)
+	start := int64(1700000000000)
+	tx := JWSTransactionDecodedPayload{
+		OriginalTransactionId: "1000",
+		TransactionId:         "1000",
+		ProductId:             "monthly",
+		OfferType:             1,
+		ExpiresDate:           start + 7*day,
+	}
+	renewal := JWSRenewalInfoDecodedPayload{OriginalTransactionId: "1000", AutoRenewStatus: 1}
+	notify := func(notificationType, subtype string, at int64) *ResponseBodyV2DecodedPayload {
+		return &ResponseBodyV2DecodedPayload{
+			NotificationType: notificationType,
+			Subtype:          subtype,
+			SignedDate:       at,
+			Data: NotificationData{
+				SignedTransactionInfo: tx,
+				SignedRenewalInfo:     renewal,
+			},
+		}
+	}
+
+	ls := SubscriptionLifecycles{}
+	type step struct {
+		n    *ResponseBodyV2DecodedPayload
+		want SubscriptionState
+	}
+	steps := []step{}
+	steps = append(steps, step{notify(NotificationTypeSubscribed, SubtypeInitialBuy, start), StateTrial})
+
+	tx.TransactionId, tx.OfferType, tx.ExpiresDate = "1001", 0, start+37*day
+	steps = append(steps, step{notify(NotificationTypeDidRenew, "", start+7*day), StateActive})
+
+	renewal.IsInBillingRetryPeriod, renewal.GracePeriodExpiresDate = true, start+53*day
+	steps = append(steps, step{notify(NotificationTypeDidFailToRenew, SubtypeGracePeriod, start+37*day), StateGracePeriod})
+	steps = append(steps, step{notify(NotificationTypeGracePeriodExpired, "", start+53*day), StateBillingRetry})
+
+	tx.TransactionId, tx.ExpiresDate = "1002", start+84*day
+	renewal.IsInBillingRetryPeriod, renewal.GracePeriodExpiresDate = false, 0
+	steps = append(steps, step{notify(NotificationTypeDidRenew, SubtypeBillingRecovery, start+54*day), StateActive})
+
+	tx.RevocationDate = start + 60*day
+	steps = append(steps, step{notify(NotificationTypeRefund, "", start+60*day), StateRefunded})
+
+	from := StateUnknown
+	for i, step := range steps {
+		transition, err := ls.ApplyNotification(step.n)
+		if err != nil {
+			t.Fatalf("step %d: %v", i, err)
+		}
+		if transition == nil || transition.From != from || transition.To != step.want {
+			t.Fatalf("step %d: transition = %+v, want %s -> %s", i, transition, from, step.want)
+		}
+		from = step.want
+	}
+
+	// 早于已处理事件的通知被拒绝
+	if _, err := ls.ApplyNotification(steps[1].n); err != ErrLifecycleOutOfOrder {
+		t.Errorf("err = %v, want %v", err, ErrLifecycleOutOfOrder)
+	}
+
+	b, err := json.Marshal(ls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored := SubscriptionLifecycles{}
+	if err := json.Unmarshal(b, &restored); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(restored, ls) {
+		t.Errorf("restored = %+v, want %+v", restored["1000"], ls["1000"])
+	}
+}
+
+func TestSubscriptionLifecycle_Snapshots(t *testing.T) {
+	day := int64(24 * time.Hour / time.Millisecond)
+	l := NewSubscriptionLifecycle("1000")
+
+	transition, err := l.ApplyTransaction(JWSTransactionDecodedPayload{
+		OriginalTransactionId: "1000",
+		TransactionId:         "1000",
+		ExpiresDate:           30 * day,
+		SignedDate:            day,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transition == nil || transition.To != StateActive || transition.Cause != causeTransaction {
+		t.Fatalf("transition = %+v, want active", transition)
+	}
+
+	transition, err = l.ApplyRenewalInfo(JWSRenewalInfoDecodedPayload{
+		OriginalTransactionId:  "1000",
+		IsInBillingRetryPeriod: true,
+		SignedDate:             31 * day,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transition == nil || transition.To != StateBillingRetry {
+		t.Fatalf("transition = %+v, want billing retry", transition)
+	}
+
+	// 状态不变时没有事件
+	transition, err = l.ApplyRenewalInfo(JWSRenewalInfoDecodedPayload{
+		OriginalTransactionId:  "1000",
+		IsInBillingRetryPeriod: true,
+		SignedDate:             32 * day,
+	})
+	if err != nil || transition != nil {
+		t.Errorf("transition = %+v, err = %v, want none", transition, err)
+	}
+
+	if _, err := l.ApplyTransaction(JWSTransactionDecodedPayload{OriginalTransactionId: "2000", SignedDate: 33 * day}); err != ErrLifecycleMismatch {
+		t.Errorf("err = %v, want %v", err, ErrLifecycleMismatch)
+	}
+}
+
+func TestSubscriptionLifecycle_EarlierPeriod(t *testing.T) {
+	day := int64(24 * time.Hour / time.Millisecond)
+	l := NewSubscriptionLifecycle("1000")
+	period := func(id string, purchase int64, signed int64) JWSTransactionDecodedPayload {
+		return JWSTransactionDecodedPayload{
+			OriginalTransactionId: "1000",
+			TransactionId:         id,
+			PurchaseDate:          purchase,
+			ExpiresDate:           purchase + 30*day,
+			SignedDate:            signed,
+		}
+	}
+
+	if _, err := l.ApplyTransaction(period("1002", 30*day, 31*day)); err != nil {
+		t.Fatal(err)
+	}
+	if l.State != StateActive {
+		t.Fatalf("state = %s, want active", l.State)
+	}
+
+	// 历史记录中之前的周期在续订之后处理，快照的 signedDate 更晚，但不覆盖续订
+	transition, err := l.ApplyTransaction(period("1001", 0, 32*day))
+	if err != nil || transition != nil {
+		t.Errorf("transition = %+v, err = %v, want none", transition, err)
+	}
+	if l.State != StateActive || l.TransactionId != "1002" {
+		t.Errorf("state = %s, transactionId = %s, want active 1002", l.State, l.TransactionId)
+	}
+
+	// 签名更早的同一笔交易快照仍然更新，例如退款
+	refunded := period("1002", 30*day, 20*day)
+	refunded.RevocationDate = 33 * day
+	transition, err = l.ApplyTransaction(refunded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transition == nil || transition.To != StateRefunded {
+		t.Errorf("transition = %+v, want refunded", transition)
+	}
+}
+
+func TestSubscriptionLifecycle_RefundEarlierPeriod(t *testing.T) {
+	day := int64(24 * time.Hour / time.Millisecond)
+	period := func(id string, purchase int64) JWSTransactionDecodedPayload {
+		return JWSTransactionDecodedPayload{
+			OriginalTransactionId: "1000",
+			TransactionId:         id,
+			PurchaseDate:          purchase,
+			ExpiresDate:           purchase + 30*day,
+		}
+	}
+	l := NewSubscriptionLifecycle("1000")
+	renewed := &ResponseBodyV2DecodedPayload{
+		NotificationType: NotificationTypeDidRenew,
+		SignedDate:       30 * day,
+		Data:             NotificationData{SignedTransactionInfo: period("1002", 30*day)},
+	}
+	if _, err := l.ApplyNotification(renewed); err != nil {
+		t.Fatal(err)
+	}
+
+	// 退款的是之前的周期，当前周期仍然有效
+	old := period("1001", 0)
+	old.RevocationDate = 31 * day
+	refund := &ResponseBodyV2DecodedPayload{
+		NotificationType: NotificationTypeRefund,
+		SignedDate:       31 * day,
+		Data:             NotificationData{SignedTransactionInfo: old},
+	}
+	transition, err := l.ApplyNotification(refund)
+	if err != nil || transition != nil {
+		t.Errorf("transition = %+v, err = %v, want none", transition, err)
+	}
+	if l.State != StateActive || l.TransactionId != "1002" {
+		t.Errorf("state = %s, transactionId = %s, want active 1002", l.State, l.TransactionId)
+	}
+}
@@ -0,0 +1,135 @@
+package appstoreserverapi
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+)
+
+type LogLevel int
+
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LevelDebug:
+		return "DEBUG"
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// 日志字段
+// Log field keys
+const (
+	FieldEndpoint      = "endpoint"
+	FieldTransactionId = "transactionId"
+	FieldAttempt       = "attempt"
+	FieldStatus        = "status"
+	FieldErrorCode     = "errorCode"
+	FieldError         = "error"
+	FieldPath          = "path"
+	FieldEnvironment   = "environment"
+	FieldBundleId      = "bundleId"
+)
+
+// Field 结构化日志字段
+// A structured log field
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// Logger 结构化日志，默认不输出任何日志
+// Structured logger, the client is silent by default
+type Logger interface {
+	Log(level LogLevel, msg string, fields ...Field)
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Log(LogLevel, string, ...Field) {}
+
+// NewStdLogger 使用标准库 log.Logger 输出 key=value 格式的日志
+// Logs key=value lines with a standard library log.Logger
+func NewStdLogger(l *log.Logger) Logger {
+	return &stdLogger{l: l}
+}
+
+type stdLogger struct {
+	l *log.Logger
+}
+
+func (s *stdLogger) Log(level LogLevel, msg string, fields ...Field) {
+	sb := strings.Builder{}
+	sb.WriteString(level.String())
+	sb.WriteString(" ")
+	sb.WriteString(msg)
+	for _, f := range fields {
+		sb.WriteString(fmt.Sprintf(" %s=%v", f.Key, f.Value))
+	}
+	s.l.Println(sb.String())
+}
+
+// sensitiveFields 输出前需要脱敏的字段
+var sensitiveFields = map[string]bool{
+	FieldTransactionId:      true,
+	"originalTransactionId": true,
+	"appAccountToken":       true,
+	"orderId":               true,
+}
+
+// redactLogger 对敏感字段脱敏后再交给使用者的 Logger
+type redactLogger struct {
+	l Logger
+}
+
+func newRedactLogger(l Logger) Logger {
+	if l == nil {
+		return nopLogger{}
+	}
+	return &redactLogger{l: l}
+}
+
+func (r *redactLogger) Log(level LogLevel, msg string, fields ...Field) {
+	// 复制一份，不修改调用方的 fields
+	fields = append([]Field(nil), fields...)
+	for i, f := range fields {
+		if !sensitiveFields[f.Key] {
+			continue
+		}
+		if s, ok := f.Value.(string); ok {
+			fields[i].Value = Redact(s)
+		}
+	}
+	r.l.Log(level, msg, fields...)
+}
+
+// errorText 日志中的错误信息：*url.Error 包含完整的请求地址，其中有未脱敏的 transactionId，只保留操作和内部错误
+func errorText(err error) string {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Op + ": " + urlErr.Err.Error()
+	}
+	return err.Error()
+}
+
+// Redact 只保留最后4位，例如：****2922
+// Keeps only the last 4 characters, eg: ****2922
+func Redact(s string) string {
+	if len(s) <= 4 {
+		return "****"
+	}
+	return "****" + s[len(s)-4:]
+}
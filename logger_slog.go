@@ -0,0 +1,40 @@
+//go:build go1.21
+// +build go1.21
+
+package appstoreserverapi
+
+import (
+	"context"
+	"log/slog"
+)
+
+// NewSlogLogger 使用 log/slog 输出日志
+// Logs with a log/slog Logger
+func NewSlogLogger(l *slog.Logger) Logger {
+	return &slogLogger{l: l}
+}
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+func (s *slogLogger) Log(level LogLevel, msg string, fields ...Field) {
+	attrs := make([]slog.Attr, 0, len(fields))
+	for _, f := range fields {
+		attrs = append(attrs, slog.Any(f.Key, f.Value))
+	}
+	s.l.LogAttrs(context.Background(), slogLevel(level), msg, attrs...)
+}
+
+func slogLevel(level LogLevel) slog.Level {
+	switch level {
+	case LevelDebug:
+		return slog.LevelDebug
+	case LevelInfo:
+		return slog.LevelInfo
+	case LevelWarn:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
@@ -0,0 +1,70 @@
+package appstoreserverapi
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestRedactLogger(t *testing.T) {
+	buf := bytes.Buffer{}
+	logger := newRedactLogger(NewStdLogger(log.New(&buf, "", 0)))
+	fields := []Field{
+		{FieldTransactionId, "2000000123456789"},
+		{"appAccountToken", "0c4b3f8e-5d7a-4b2f-9a3e-1b2c3d4e5f60"},
+		{FieldEndpoint, endpointGetTransactionHistory},
+	}
+	logger.Log(LevelInfo, "hello", fields...)
+
+	out := buf.String()
+	if strings.Contains(out, "2000000123456789") || !strings.Contains(out, "transactionId=****6789") {
+		t.Errorf("transactionId not redacted: %s", out)
+	}
+	if strings.Contains(out, "0c4b3f8e") || !strings.Contains(out, "endpoint=GetTransactionHistory") {
+		t.Errorf("output = %s", out)
+	}
+	// 不修改调用方的 fields
+	if fields[0].Value != "2000000123456789" {
+		t.Errorf("caller fields changed: %+v", fields)
+	}
+}
+
+func TestLogger_SilentByDefault(t *testing.T) {
+	if _, ok := newRedactLogger(nil).(nopLogger); !ok {
+		t.Error("a nil Logger should log nothing")
+	}
+	_, pk := newTestKey(t)
+	c, err := NewClient(&Config{Iss: ISS, Kid: KID, Bid: BID, Pk: pk})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.(*client).logger.(nopLogger); !ok {
+		t.Errorf("logger = %T, want nopLogger", c.(*client).logger)
+	}
+}
+
+func TestLogger_RequestFailedRedactsUrl(t *testing.T) {
+	buf := bytes.Buffer{}
+	_, pk := newTestKey(t)
+	c, err := NewClient(&Config{
+		Iss:      ISS,
+		Kid:      KID,
+		Bid:      BID,
+		Pk:       pk,
+		Evn:      LocalTesting,
+		BaseUrls: map[Env]string{LocalTesting: "http://127.0.0.1:1"},
+		TryCount: 1,
+		Logger:   NewStdLogger(log.New(&buf, "", 0)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ApiGetTransactionHistory("2000000123456789", false); err == nil {
+		t.Fatal("expected a connection error")
+	}
+	out := buf.String()
+	if !strings.Contains(out, "request failed") || strings.Contains(out, "2000000123456789") {
+		t.Errorf("output = %s", out)
+	}
+}
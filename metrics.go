@@ -0,0 +1,39 @@
+package appstoreserverapi
+
+import "time"
+
+// Metrics 接口调用的指标，默认不记录，prommetrics 子模块提供 Prometheus 实现
+// Metrics of API calls, nothing is recorded by default, the prommetrics module provides a Prometheus implementation
+type Metrics interface {
+	// Request 每次 HTTP 请求（包括重试）完成后调用
+	// status: HTTP 状态码，没有收到响应时为 0
+	// errorCode: Apple 返回的 errorCode，没有时为 0
+	// Called after each HTTP request including retries
+	// status: the HTTP status, 0 when no response was received
+	// errorCode: the errorCode returned by Apple, 0 when none
+	Request(endpoint string, status int, errorCode int, duration time.Duration)
+	// Retry 每次重试前调用，收到 401 后重新签名再请求不算重试
+	// Called before each retry, resending with a new token after a 401 is not a retry
+	Retry(endpoint string)
+	// TokenRefresh 每次签名新的 token 后调用
+	// Called after each new token is signed
+	TokenRefresh()
+	// RateLimitWait 每次在 Config.RateLimiter 中等待后调用，wait 为等待的时间
+	// Called after each wait in Config.RateLimiter, wait is how long it took
+	RateLimitWait(endpoint string, wait time.Duration)
+}
+
+type nopMetrics struct{}
+
+func (nopMetrics) Request(string, int, int, time.Duration) {}
+func (nopMetrics) Retry(string)                            {}
+func (nopMetrics) TokenRefresh()                           {}
+func (nopMetrics) RateLimitWait(string, time.Duration)     {}
+
+// metrics 配置的 Metrics，未设置时不记录
+func (cfg *Config) metrics() Metrics {
+	if cfg.Metrics == nil {
+		return nopMetrics{}
+	}
+	return cfg.Metrics
+}
@@ -0,0 +1,99 @@
+package appstoreserverapi_test
+
+import (
+	"fmt"
+	"github.com/lhlyu/appstoreserverapi"
+	"github.com/lhlyu/appstoreserverapi/storetest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type recordingMetrics struct {
+	lock           sync.Mutex
+	requests       []string
+	durations      []time.Duration
+	retries        int
+	tokenRefreshes int
+	rateLimitWaits int
+}
+
+func (m *recordingMetrics) Request(endpoint string, status int, errorCode int, duration time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.requests = append(m.requests, fmt.Sprintf("%s %d %d", endpoint, status, errorCode))
+	m.durations = append(m.durations, duration)
+}
+
+func (m *recordingMetrics) Retry(endpoint string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.retries++
+}
+
+func (m *recordingMetrics) TokenRefresh() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.tokenRefreshes++
+}
+
+func (m *recordingMetrics) RateLimitWait(endpoint string, wait time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.rateLimitWaits++
+}
+
+func TestClient_Metrics(t *testing.T) {
+	m := &recordingMetrics{}
+	s, client := newStoreTestClient(t, func(cfg *appstoreserverapi.Config) {
+		cfg.Metrics = m
+		cfg.RateLimiter = appstoreserverapi.NewRateLimiter(100, time.Second, nil)
+	}, storetest.MonthlySubscription("4000", "monthly", time.Now()))
+
+	// 5000001 可以重试
+	s.InjectError(storetest.EndpointGetTransactionHistory, 500, 5000001, 1)
+	if _, err := client.ApiGetTransactionHistory("4000", false); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"GetTransactionHistory 500 5000001",
+		"GetTransactionHistory 200 0",
+	}
+	if fmt.Sprint(m.requests) != fmt.Sprint(want) {
+		t.Errorf("requests = %v, want %v", m.requests, want)
+	}
+	if m.retries != 1 || m.tokenRefreshes != 1 || m.rateLimitWaits != 2 {
+		t.Errorf("retries = %d, token refreshes = %d, rate limit waits = %d", m.retries, m.tokenRefreshes, m.rateLimitWaits)
+	}
+}
+
+func TestClient_MetricsReSign(t *testing.T) {
+	// 收到 401 后重新签名再请求不算重试
+	m := &recordingMetrics{}
+	s, client := newStoreTestClient(t, func(cfg *appstoreserverapi.Config) {
+		cfg.Metrics = m
+	}, storetest.MonthlySubscription("4000", "monthly", time.Now()))
+	s.InjectError(storetest.EndpointGetTransactionHistory, 401, 0, 1)
+	if _, err := client.ApiGetTransactionHistory("4000", false); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.requests) != 2 || m.retries != 0 || m.tokenRefreshes != 2 {
+		t.Errorf("requests = %v, retries = %d, token refreshes = %d", m.requests, m.retries, m.tokenRefreshes)
+	}
+}
+
+func TestClient_MetricsClock(t *testing.T) {
+	// 请求耗时来自 Config.Clock，时钟不前进时耗时为 0
+	m := &recordingMetrics{}
+	s, client := newStoreTestClient(t, func(cfg *appstoreserverapi.Config) {
+		cfg.Metrics = m
+		cfg.Clock = storetest.NewClock(time.Now())
+	}, storetest.MonthlySubscription("4000", "monthly", time.Now()))
+	s.SetLatency(20 * time.Millisecond)
+	if _, err := client.ApiGetTransactionHistory("4000", false); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.durations) != 1 || m.durations[0] != 0 {
+		t.Errorf("durations = %v, want [0]", m.durations)
+	}
+}
@@ -0,0 +1,140 @@
+package appstoreserverapi
+
+// App Store 服务器通知 V2
+// App Store Server Notifications V2
+// doc: https://developer.apple.com/documentation/appstoreservernotifications/responsebodyv2decodedpayload
+
+// 通知类型
+// doc: https://developer.apple.com/documentation/appstoreservernotifications/notificationtype
+const (
+	NotificationTypeConsumptionRequest     = "CONSUMPTION_REQUEST"
+	NotificationTypeDidChangeRenewalPref   = "DID_CHANGE_RENEWAL_PREF"
+	NotificationTypeDidChangeRenewalStatus = "DID_CHANGE_RENEWAL_STATUS"
+	NotificationTypeDidFailToRenew         = "DID_FAIL_TO_RENEW"
+	NotificationTypeDidRenew               = "DID_RENEW"
+	NotificationTypeExpired                = "EXPIRED"
+	NotificationTypeGracePeriodExpired     = "GRACE_PERIOD_EXPIRED"
+	NotificationTypeOfferRedeemed          = "OFFER_REDEEMED"
+	NotificationTypeOneTimeCharge          = "ONE_TIME_CHARGE"
+	NotificationTypePriceIncrease          = "PRICE_INCREASE"
+	NotificationTypeRefund                 = "REFUND"
+	NotificationTypeRefundDeclined         = "REFUND_DECLINED"
+	NotificationTypeRefundReversed         = "REFUND_REVERSED"
+	NotificationTypeRenewalExtended        = "RENEWAL_EXTENDED"
+	NotificationTypeRenewalExtension       = "RENEWAL_EXTENSION"
+	NotificationTypeRevoke                 = "REVOKE"
+	NotificationTypeSubscribed             = "SUBSCRIBED"
+	NotificationTypeTest                   = "TEST"
+)
+
+// 通知子类型
+// doc: https://developer.apple.com/documentation/appstoreservernotifications/subtype
+const (
+	SubtypeAccepted          = "ACCEPTED"
+	SubtypeAutoRenewDisabled = "AUTO_RENEW_DISABLED"
+	SubtypeAutoRenewEnabled  = "AUTO_RENEW_ENABLED"
+	SubtypeBillingRecovery   = "BILLING_RECOVERY"
+	SubtypeBillingRetry      = "BILLING_RETRY"
+	SubtypeDowngrade         = "DOWNGRADE"
+	SubtypeFailure           = "FAILURE"
+	SubtypeGracePeriod       = "GRACE_PERIOD"
+	SubtypeInitialBuy        = "INITIAL_BUY"
+	SubtypePending           = "PENDING"
+	SubtypePriceIncrease     = "PRICE_INCREASE"
+	SubtypeProductNotForSale = "PRODUCT_NOT_FOR_SALE"
+	SubtypeResubscribe       = "RESUBSCRIBE"
+	SubtypeSummary           = "SUMMARY"
+	SubtypeUpgrade           = "UPGRADE"
+	SubtypeVoluntary         = "VOLUNTARY"
+)
+
+// ResponseBodyV2DecodedPayload 通知解码后的内容
+// doc: https://developer.apple.com/documentation/appstoreservernotifications/responsebodyv2decodedpayload
+type ResponseBodyV2DecodedPayload struct {
+	NotificationType string               `json:"notificationType"`
+	Subtype          string               `json:"subtype,omitempty"`
+	NotificationUUID string               `json:"notificationUUID"`
+	Data             NotificationData     `json:"data"`
+	Summary          *NotificationSummary `json:"summary,omitempty"`
+	Version          string               `json:"version"`
+	SignedDate       int64                `json:"signedDate"`
+}
+
+// NotificationData 通知中的应用和交易信息
+// doc: https://developer.apple.com/documentation/appstoreservernotifications/data
+type NotificationData struct {
+	AppAppleId            int64                        `json:"appAppleId,omitempty"`
+	BundleId              string                       `json:"bundleId"`
+	BundleVersion         string                       `json:"bundleVersion,omitempty"`
+	Environment           string                       `json:"environment"`
+	Status                int64                        `json:"status,omitempty"`
+	SignedTransactionInfo JWSTransactionDecodedPayload `json:"signedTransactionInfo"`
+	SignedRenewalInfo     JWSRenewalInfoDecodedPayload `json:"signedRenewalInfo"`
+}
+
+// NotificationSummary 批量延长续订日期完成后的汇总
+// doc: https://developer.apple.com/documentation/appstoreservernotifications/summary
+type NotificationSummary struct {
+	RequestIdentifier      string   `json:"requestIdentifier"`
+	Environment            string   `json:"environment"`
+	AppAppleId             int64    `json:"appAppleId,omitempty"`
+	BundleId               string   `json:"bundleId"`
+	ProductId              string   `json:"productId"`
+	StorefrontCountryCodes []string `json:"storefrontCountryCodes,omitempty"`
+	FailedCount            int64    `json:"failedCount"`
+	SucceededCount         int64    `json:"succeededCount"`
+}
+
+// BundleId 通知所属应用，汇总通知中取 summary 的 bundleId
+// The bundle ID the notification belongs to, taken from the summary for summary notifications
+func (p *ResponseBodyV2DecodedPayload) BundleId() string {
+	if p.Data.BundleId == "" && p.Summary != nil {
+		return p.Summary.BundleId
+	}
+	return p.Data.BundleId
+}
+
+// DecodeNotification 解码通知的 signedPayload，以及其中的 signedTransactionInfo、signedRenewalInfo
+// verifier 为空时不校验签名
+// Decodes the signedPayload of a notification along with its signedTransactionInfo and signedRenewalInfo
+// the signatures are not verified when verifier is nil
+func DecodeNotification(signedPayload string, verifier *Verifier) (*ResponseBodyV2DecodedPayload, error) {
+	parse := Parse
+	if verifier != nil {
+		parse = verifier.Verify
+	}
+	raw := struct {
+		ResponseBodyV2DecodedPayload
+		Data struct {
+			AppAppleId            int64  `json:"appAppleId"`
+			BundleId              string `json:"bundleId"`
+			BundleVersion         string `json:"bundleVersion"`
+			Environment           string `json:"environment"`
+			Status                int64  `json:"status"`
+			SignedTransactionInfo string `json:"signedTransactionInfo"`
+			SignedRenewalInfo     string `json:"signedRenewalInfo"`
+		} `json:"data"`
+	}{}
+	if err := parse(signedPayload, &raw); err != nil {
+		return nil, err
+	}
+	result := raw.ResponseBodyV2DecodedPayload
+	result.Data = NotificationData{
+		AppAppleId:    raw.Data.AppAppleId,
+		BundleId:      raw.Data.BundleId,
+		BundleVersion: raw.Data.BundleVersion,
+		Environment:   raw.Data.Environment,
+		Status:        raw.Data.Status,
+	}
+	if raw.Data.SignedTransactionInfo != "" {
+		if err := parse(raw.Data.SignedTransactionInfo, &result.Data.SignedTransactionInfo); err != nil {
+			return nil, err
+		}
+	}
+	if raw.Data.SignedRenewalInfo != "" {
+		if err := parse(raw.Data.SignedRenewalInfo, &result.Data.SignedRenewalInfo); err != nil {
+			return nil, err
+		}
+	}
+	return &result, nil
+}
@@ -0,0 +1,109 @@
+package appstoreserverapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+)
+
+// 通知请求体的大小上限
+const maxNotificationBodySize = 1 << 20
+
+// NotificationHandler 接收 App Store 服务器通知 V2 的 http.Handler
+// 解码成功后写入 Store 并调用 Handle，都成功时返回 200，否则 Apple 会稍后重试
+// An http.Handler receiving App Store Server Notifications V2
+// After decoding, the data is written to Store and Handle is called, 200 is returned when both succeed, otherwise Apple retries later
+// doc: https://developer.apple.com/documentation/appstoreservernotifications/responsebodyv2
+type NotificationHandler struct {
+	// 校验器：必填，为空时响应 500，除非设置了 InsecureSkipVerify
+	// Verifier: required, a nil Verifier answers 500 unless InsecureSkipVerify is set
+	Verifier *Verifier
+	// 不校验签名：只用于测试，任何人都可以伪造通知写入 Store
+	// Skips signature verification: tests only, anyone could forge notifications into Store
+	InsecureSkipVerify bool
+	// 存储：可选，通知中的交易和续订信息会写入
+	// Store: optional, the transaction and renewal info of the notification are written into it
+	Store TransactionStore
+	// 订阅状态缓存：可选，收到通知时删除该 originalTransactionId 的结果
+	// Status cache: optional, results of the notified originalTransactionId are dropped
+	StatusCache StatusCache
+	// 处理通知：可选，返回错误时响应 500
+	// Handles the notification: optional, an error answers 500
+	Handle func(notification *ResponseBodyV2DecodedPayload) error
+	// 日志：默认不输出
+	// Logger: silent by default
+	Logger Logger
+}
+
+func (h *NotificationHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	logger := newRedactLogger(h.Logger)
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	body := struct {
+		SignedPayload string `json:"signedPayload"`
+	}{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxNotificationBodySize)).Decode(&body); err != nil || body.SignedPayload == "" {
+		logger.Log(LevelWarn, "notification body invalid")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if h.Verifier == nil && !h.InsecureSkipVerify {
+		logger.Log(LevelError, "notification handler has no verifier")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	notification, err := DecodeNotification(body.SignedPayload, h.Verifier)
+	if err != nil {
+		logger.Log(LevelWarn, "decode notification failed", Field{FieldError, err.Error()})
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if h.StatusCache != nil {
+		invalidateNotification(h.StatusCache, notification)
+	}
+	if err := storeNotification(h.Store, notification); err != nil {
+		logger.Log(LevelError, "store notification failed",
+			Field{FieldTransactionId, notification.Data.SignedTransactionInfo.TransactionId},
+			Field{FieldError, err.Error()},
+		)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if h.Handle != nil {
+		if err := h.Handle(notification); err != nil {
+			logger.Log(LevelError, "handle notification failed",
+				Field{FieldTransactionId, notification.Data.SignedTransactionInfo.TransactionId},
+				Field{FieldError, err.Error()},
+			)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// storeNotification 写入通知中的交易和续订信息
+func storeNotification(store TransactionStore, notification *ResponseBodyV2DecodedPayload) error {
+	transactions := make([]JWSTransactionDecodedPayload, 0, 1)
+	if tx := notification.Data.SignedTransactionInfo; tx.TransactionId != "" {
+		transactions = append(transactions, tx)
+	}
+	renewals := make([]JWSRenewalInfoDecodedPayload, 0, 1)
+	if renewal := notification.Data.SignedRenewalInfo; renewal.OriginalTransactionId != "" {
+		renewals = append(renewals, renewal)
+	}
+	return storePayloads(store, transactions, renewals)
+}
+
+// invalidateNotification 删除通知所属应用中 originalTransactionId 的缓存
+func invalidateNotification(cache StatusCache, notification *ResponseBodyV2DecodedPayload) {
+	bundleId := notification.BundleId()
+	if original := notification.Data.SignedTransactionInfo.OriginalTransactionId; original != "" {
+		cache.Invalidate(bundleId, original)
+	}
+	if original := notification.Data.SignedRenewalInfo.OriginalTransactionId; original != "" && original != notification.Data.SignedTransactionInfo.OriginalTransactionId {
+		cache.Invalidate(bundleId, original)
+	}
+}
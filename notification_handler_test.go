@@ -0,0 +1,50 @@
+package appstoreserverapi_test
+
+import (
+	"encoding/json"
+	"github.com/lhlyu/appstoreserverapi"
+	"github.com/lhlyu/appstoreserverapi/storetest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNotificationHandler_ForgedSignature(t *testing.T) {
+	s, err := storetest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	// 另一条证书链签名的通知，结构完整但根证书不受信任
+	forger, err := storetest.NewCertificateChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := storetest.MonthlySubscription("7000", "monthly", time.Now()).Refund(time.Now())
+	tx, err := forger.Sign(sc.Latest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := forger.Sign(map[string]interface{}{
+		"notificationType": appstoreserverapi.NotificationTypeRefund,
+		"signedDate":       1,
+		"data":             map[string]interface{}{"bundleId": storetest.Bid, "signedTransactionInfo": tx},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := appstoreserverapi.NewMemoryStore()
+	h := &appstoreserverapi.NotificationHandler{Verifier: s.Chain.Verifier(), Store: store}
+	body, _ := json.Marshal(map[string]string{"signedPayload": payload})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/notifications", strings.NewReader(string(body))))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d, want 400", w.Code)
+	}
+	if txs, _ := store.TransactionsByOriginalTransactionId("7000"); len(txs) != 0 {
+		t.Errorf("forged transactions reached the store: %+v", txs)
+	}
+}
@@ -0,0 +1,91 @@
+// Package oteltrace 把 appstoreserverapi.Tracer 的 span 记录到 OpenTelemetry
+// Records the spans of appstoreserverapi.Tracer with OpenTelemetry
+package oteltrace
+
+import (
+	"context"
+	"fmt"
+	"github.com/lhlyu/appstoreserverapi"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InstrumentationName Tracer 的名称
+// The name of the Tracer
+const InstrumentationName = "github.com/lhlyu/appstoreserverapi"
+
+// 属性名称的前缀，例如：appstore.endpoint
+// The prefix of attribute keys, eg: appstore.endpoint
+const attributePrefix = "appstore."
+
+// Tracer OpenTelemetry 实现的 appstoreserverapi.Tracer
+// An appstoreserverapi.Tracer backed by OpenTelemetry
+type Tracer struct {
+	tracer trace.Tracer
+}
+
+var _ appstoreserverapi.Tracer = (*Tracer)(nil)
+
+// New 创建 Tracer，tp 为空时使用 otel.GetTracerProvider()
+// Creates a Tracer, tp defaults to otel.GetTracerProvider()
+func New(tp trace.TracerProvider) *Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return &Tracer{tracer: tp.Tracer(InstrumentationName)}
+}
+
+// Start 签名校验在本地进行，span 类型为 Internal，其它 span 为 Client
+// Signature verification runs locally so its span is Internal, other spans are Client
+func (t *Tracer) Start(ctx context.Context, name string, fields ...appstoreserverapi.Field) (context.Context, appstoreserverapi.Span) {
+	kind := trace.SpanKindClient
+	if name == appstoreserverapi.SpanVerify {
+		kind = trace.SpanKindInternal
+	}
+	ctx, span := t.tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attributes(fields)...))
+	return ctx, &Span{span: span}
+}
+
+// Span OpenTelemetry 实现的 appstoreserverapi.Span
+// An appstoreserverapi.Span backed by OpenTelemetry
+type Span struct {
+	span trace.Span
+}
+
+func (s *Span) SetAttributes(fields ...appstoreserverapi.Field) {
+	s.span.SetAttributes(attributes(fields)...)
+}
+
+func (s *Span) End(err error) {
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+// attributes 字段转换为属性，HTTP 状态码使用语义约定中的名称
+func attributes(fields []appstoreserverapi.Field) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(fields))
+	for _, f := range fields {
+		key := attributePrefix + f.Key
+		if f.Key == appstoreserverapi.FieldStatus {
+			key = "http.response.status_code"
+		}
+		switch v := f.Value.(type) {
+		case string:
+			attrs = append(attrs, attribute.String(key, v))
+		case int:
+			attrs = append(attrs, attribute.Int(key, v))
+		case int64:
+			attrs = append(attrs, attribute.Int64(key, v))
+		case bool:
+			attrs = append(attrs, attribute.Bool(key, v))
+		default:
+			attrs = append(attrs, attribute.String(key, fmt.Sprint(v)))
+		}
+	}
+	return attrs
+}
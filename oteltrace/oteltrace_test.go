@@ -0,0 +1,86 @@
+package oteltrace
+
+import (
+	"context"
+	"github.com/lhlyu/appstoreserverapi"
+	"github.com/lhlyu/appstoreserverapi/storetest"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+	"time"
+)
+
+func TestTracer(t *testing.T) {
+	s, err := storetest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.AddScenario(storetest.MonthlySubscription("7000", "monthly", time.Now()))
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	cfg := s.Config()
+	cfg.Tracer = New(tp)
+	client, err := appstoreserverapi.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := tp.Tracer("test").Start(context.Background(), "caller")
+	s.InjectError(storetest.EndpointGetAllSubscriptionStatuses, 500, 5000001, 1)
+	if _, err := client.ApiGetAllSubscriptionStatuses("7000", appstoreserverapi.WithContext(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	spans := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = append(spans[span.Name()], span)
+	}
+	calls := spans[appstoreserverapi.SpanPrefix+"GetAllSubscriptionStatuses"]
+	if len(calls) != 1 {
+		t.Fatalf("call spans = %d, want 1", len(calls))
+	}
+	call := calls[0]
+	if call.Parent().SpanID() != parent.SpanContext().SpanID() {
+		t.Error("the call span should be a child of the caller's span")
+	}
+	attrs := attribute.NewSet(call.Attributes()...)
+	if v, _ := attrs.Value("appstore.bundleId"); v.AsString() != storetest.Bid {
+		t.Errorf("bundleId = %v", v.AsString())
+	}
+	if v, _ := attrs.Value("appstore.environment"); v.AsString() != string(appstoreserverapi.LocalTesting) {
+		t.Errorf("environment = %v", v.AsString())
+	}
+	if v, _ := attrs.Value("http.response.status_code"); v.AsInt64() != 200 {
+		t.Errorf("status = %v", v.AsInt64())
+	}
+
+	attempts := spans[appstoreserverapi.SpanAttempt]
+	if len(attempts) != 2 {
+		t.Fatalf("attempt spans = %d, want 2", len(attempts))
+	}
+	if attempts[0].Status().Code != codes.Error || attempts[0].Parent().SpanID() != call.SpanContext().SpanID() {
+		t.Errorf("first attempt status = %v", attempts[0].Status())
+	}
+	attemptAttrs := attribute.NewSet(attempts[0].Attributes()...)
+	if v, _ := attemptAttrs.Value("appstore.errorCode"); v.AsInt64() != 5000001 {
+		t.Errorf("errorCode = %v", v.AsInt64())
+	}
+	verifies := spans[appstoreserverapi.SpanVerify]
+	if len(verifies) != 2 {
+		t.Errorf("verify spans = %d, want 2", len(verifies))
+	}
+	for _, span := range verifies {
+		if span.SpanKind() != trace.SpanKindInternal {
+			t.Errorf("verify span kind = %s, want internal", span.SpanKind())
+		}
+	}
+	if call.SpanKind() != trace.SpanKindClient || attempts[0].SpanKind() != trace.SpanKindClient {
+		t.Errorf("call kind = %s, attempt kind = %s, want client", call.SpanKind(), attempts[0].SpanKind())
+	}
+}
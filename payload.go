@@ -41,3 +41,23 @@ type JWSRenewalInfoDecodedPayload struct {
 	ProductId              string `json:"productId,omitempty"`
 	SignedDate             int64  `json:"signedDate,omitempty"`
 }
+
+// AppTransaction 应用的购买信息，由 StoreKit 2 客户端签名发送
+// doc: https://developer.apple.com/documentation/storekit/apptransaction
+type AppTransaction struct {
+	AppAppleId                 int64  `json:"appAppleId,omitempty"`
+	AppTransactionId           string `json:"appTransactionId,omitempty"`
+	ApplicationVersion         string `json:"applicationVersion,omitempty"`
+	BundleId                   string `json:"bundleId,omitempty"`
+	DeviceVerification         string `json:"deviceVerification,omitempty"`
+	DeviceVerificationNonce    string `json:"deviceVerificationNonce,omitempty"`
+	Environment                string `json:"environment,omitempty"`
+	OriginalApplicationVersion string `json:"originalApplicationVersion,omitempty"`
+	OriginalPlatform           string `json:"originalPlatform,omitempty"`
+	OriginalPurchaseDate       int64  `json:"originalPurchaseDate,omitempty"`
+	PreorderDate               int64  `json:"preorderDate,omitempty"`
+	ReceiptCreationDate        int64  `json:"receiptCreationDate,omitempty"`
+	ReceiptType                string `json:"receiptType,omitempty"`
+	SignedDate                 int64  `json:"signedDate,omitempty"`
+	VersionExternalIdentifier  int64  `json:"versionExternalIdentifier,omitempty"`
+}
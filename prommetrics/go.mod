@@ -0,0 +1,10 @@
+module github.com/lhlyu/appstoreserverapi/prommetrics
+
+go 1.16
+
+require (
+	github.com/lhlyu/appstoreserverapi v0.0.0
+	github.com/prometheus/client_golang v1.9.0
+)
+
+replace github.com/lhlyu/appstoreserverapi => ../
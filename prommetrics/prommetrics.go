@@ -0,0 +1,94 @@
+// Package prommetrics 把 appstoreserverapi.Metrics 记录到 Prometheus
+// Records appstoreserverapi.Metrics with Prometheus
+package prommetrics
+
+import (
+	"github.com/lhlyu/appstoreserverapi"
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+	"time"
+)
+
+// DefaultNamespace 指标名称的默认前缀
+// The default prefix of metric names
+const DefaultNamespace = "appstoreserverapi"
+
+// Metrics Prometheus 实现的 appstoreserverapi.Metrics
+// An appstoreserverapi.Metrics backed by Prometheus
+type Metrics struct {
+	requests       *prometheus.CounterVec
+	latency        *prometheus.HistogramVec
+	retries        *prometheus.CounterVec
+	tokenRefreshes prometheus.Counter
+	rateLimitWaits *prometheus.HistogramVec
+}
+
+var _ appstoreserverapi.Metrics = (*Metrics)(nil)
+
+// New 创建指标并注册到 reg，namespace 为空时使用 DefaultNamespace
+// 指标：
+//   - <namespace>_requests_total{endpoint,status,error_code}
+//   - <namespace>_request_duration_seconds{endpoint}
+//   - <namespace>_retries_total{endpoint}
+//   - <namespace>_token_refreshes_total
+//   - <namespace>_rate_limit_wait_seconds{endpoint}
+//
+// Creates the metrics and registers them with reg, namespace defaults to DefaultNamespace
+func New(reg prometheus.Registerer, namespace string) (*Metrics, error) {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	m := &Metrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_total",
+			Help:      "HTTP requests to the App Store Server API, including retries.",
+		}, []string{"endpoint", "status", "error_code"}),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "request_duration_seconds",
+			Help:      "Latency of HTTP requests to the App Store Server API.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"endpoint"}),
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "retries_total",
+			Help:      "Retried requests to the App Store Server API.",
+		}, []string{"endpoint"}),
+		tokenRefreshes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "token_refreshes_total",
+			Help:      "Signed API tokens.",
+		}),
+		rateLimitWaits: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "rate_limit_wait_seconds",
+			Help:      "Time spent waiting in the rate limiter before a request.",
+			Buckets:   []float64{0, .01, .05, .1, .5, 1, 5, 10, 60},
+		}, []string{"endpoint"}),
+	}
+	collectors := []prometheus.Collector{m.requests, m.latency, m.retries, m.tokenRefreshes, m.rateLimitWaits}
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+func (m *Metrics) Request(endpoint string, status int, errorCode int, duration time.Duration) {
+	m.requests.WithLabelValues(endpoint, strconv.Itoa(status), strconv.Itoa(errorCode)).Inc()
+	m.latency.WithLabelValues(endpoint).Observe(duration.Seconds())
+}
+
+func (m *Metrics) Retry(endpoint string) {
+	m.retries.WithLabelValues(endpoint).Inc()
+}
+
+func (m *Metrics) TokenRefresh() {
+	m.tokenRefreshes.Inc()
+}
+
+func (m *Metrics) RateLimitWait(endpoint string, wait time.Duration) {
+	m.rateLimitWaits.WithLabelValues(endpoint).Observe(wait.Seconds())
+}
@@ -0,0 +1,56 @@
+package prommetrics
+
+import (
+	"github.com/lhlyu/appstoreserverapi"
+	"github.com/lhlyu/appstoreserverapi/storetest"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	s, err := storetest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.AddScenario(storetest.MonthlySubscription("5000", "monthly", time.Now()))
+
+	reg := prometheus.NewRegistry()
+	m, err := New(reg, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(reg, ""); err == nil {
+		t.Error("registering twice should fail")
+	}
+	cfg := s.Config()
+	cfg.Metrics = m
+	cfg.RateLimiter = appstoreserverapi.NewRateLimiter(100, time.Second, nil)
+	client, err := appstoreserverapi.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.InjectError(storetest.EndpointGetAllSubscriptionStatuses, 500, 5000001, 1)
+	if _, err := client.ApiGetAllSubscriptionStatuses("5000"); err != nil {
+		t.Fatal(err)
+	}
+
+	endpoint := "GetAllSubscriptionStatuses"
+	if v := testutil.ToFloat64(m.requests.WithLabelValues(endpoint, "500", "5000001")); v != 1 {
+		t.Errorf("failed requests = %v, want 1", v)
+	}
+	if v := testutil.ToFloat64(m.requests.WithLabelValues(endpoint, "200", "0")); v != 1 {
+		t.Errorf("succeeded requests = %v, want 1", v)
+	}
+	if v := testutil.ToFloat64(m.retries.WithLabelValues(endpoint)); v != 1 {
+		t.Errorf("retries = %v, want 1", v)
+	}
+	if v := testutil.ToFloat64(m.tokenRefreshes); v != 1 {
+		t.Errorf("token refreshes = %v, want 1", v)
+	}
+	if n, err := testutil.GatherAndCount(reg, "appstoreserverapi_request_duration_seconds", "appstoreserverapi_rate_limit_wait_seconds"); err != nil || n != 2 {
+		t.Errorf("histograms = %d, err = %v", n, err)
+	}
+}
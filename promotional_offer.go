@@ -0,0 +1,172 @@
+package appstoreserverapi
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 促销优惠签名
+// Promotional offer signatures
+// doc: https://developer.apple.com/documentation/storekit/in-app_purchase/original_api_for_in-app_purchase/subscriptions_and_offers/generating_a_signature_for_promotional_offers
+// doc: https://developer.apple.com/documentation/storekit/generating-jws-to-sign-app-store-requests
+
+const (
+	audiencePromotionalOffer             = "promotional-offer"
+	audienceIntroductoryOfferEligibility = "introductory-offer-eligibility"
+)
+
+// PromotionalOfferSigner 使用 App Store Connect 中的秘钥生成促销优惠签名
+// Signs promotional offers with the App Store Connect key
+type PromotionalOfferSigner struct {
+	iss   string
+	kid   string
+	bid   string
+	key   crypto.Signer
+	clock Clock
+}
+
+// NewPromotionalOfferSigner 使用配置中的 Iss、Kid、Bid、Pk（或 Key）创建签名器
+// 设置了 Keys 时使用其中的第一个秘钥，即轮换开始时使用的秘钥
+// Creates a signer from the Iss, Kid, Bid and Pk (or Key) of the config
+// with Keys set, the first key is used, the one key rotation starts with
+func NewPromotionalOfferSigner(cfg *Config) (*PromotionalOfferSigner, error) {
+	if cfg == nil {
+		return nil, ErrConfigIsNil
+	}
+	if cfg.Iss == "" || cfg.Bid == "" || !cfg.hasKey() {
+		return nil, ErrConfigInvalid
+	}
+	signingKey := cfg.signingKeys()[0]
+	key, err := signingKey.keyProvider().Signer()
+	if err != nil {
+		return nil, err
+	}
+	clock := cfg.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	return &PromotionalOfferSigner{
+		iss:   cfg.Iss,
+		kid:   signingKey.Kid,
+		bid:   cfg.Bid,
+		key:   key,
+		clock: clock,
+	}, nil
+}
+
+// PromotionalOfferSignature 促销优惠签名，客户端用于 SKPaymentDiscount
+// The promotional offer signature, used by the client to create a SKPaymentDiscount
+type PromotionalOfferSignature struct {
+	KeyIdentifier string `json:"keyIdentifier"`
+	Nonce         string `json:"nonce"`
+	Timestamp     int64  `json:"timestamp"`
+	Signature     string `json:"signature"`
+}
+
+// Sign 生成促销优惠签名
+// productIdentifier: 产品ID
+// offerIdentifier: App Store Connect 中的优惠ID
+// appAccountToken: 可选，客户端购买时使用的 appAccountToken
+func (s *PromotionalOfferSigner) Sign(productIdentifier, offerIdentifier, appAccountToken string) (*PromotionalOfferSignature, error) {
+	nonce, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	timestamp := s.clock.Now().UnixNano() / int64(time.Millisecond)
+	payload := strings.Join([]string{
+		s.bid,
+		s.kid,
+		productIdentifier,
+		offerIdentifier,
+		strings.ToLower(appAccountToken),
+		nonce,
+		strconv.FormatInt(timestamp, 10),
+	}, "\u2063")
+	digest := sha256.Sum256([]byte(payload))
+	signature, err := s.key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	return &PromotionalOfferSignature{
+		KeyIdentifier: s.kid,
+		Nonce:         nonce,
+		Timestamp:     timestamp,
+		Signature:     base64.StdEncoding.EncodeToString(signature),
+	}, nil
+}
+
+// SignPromotionalOfferV2 生成 JWS 格式的促销优惠签名
+// transactionId: 可选，客户的任意一笔交易ID
+// Creates the JWS promotional offer signature, transactionId is optional
+func (s *PromotionalOfferSigner) SignPromotionalOfferV2(productId, offerIdentifier, transactionId string) (string, error) {
+	claims := map[string]interface{}{
+		"productId":       productId,
+		"offerIdentifier": offerIdentifier,
+	}
+	if transactionId != "" {
+		claims["transactionId"] = transactionId
+	}
+	return s.signJws(audiencePromotionalOffer, claims)
+}
+
+// SignWinBackOffer 生成赢回优惠签名，赢回优惠使用和促销优惠 V2 相同的 JWS 格式
+// Creates the win-back offer signature, win-back offers use the same JWS as promotional offer V2
+func (s *PromotionalOfferSigner) SignWinBackOffer(productId, offerIdentifier, transactionId string) (string, error) {
+	return s.SignPromotionalOfferV2(productId, offerIdentifier, transactionId)
+}
+
+// SignIntroductoryOfferEligibility 生成推介优惠资格签名
+// allowIntroductoryOffer: 是否允许客户使用推介优惠
+// transactionId: 客户的任意一笔交易ID
+// Creates the introductory offer eligibility signature
+func (s *PromotionalOfferSigner) SignIntroductoryOfferEligibility(productId string, allowIntroductoryOffer bool, transactionId string) (string, error) {
+	return s.signJws(audienceIntroductoryOfferEligibility, map[string]interface{}{
+		"productId":              productId,
+		"allowIntroductoryOffer": allowIntroductoryOffer,
+		"transactionId":          transactionId,
+	})
+}
+
+func (s *PromotionalOfferSigner) signJws(aud string, claims map[string]interface{}) (string, error) {
+	nonce, err := newUUID()
+	if err != nil {
+		return "", err
+	}
+	j := jwt.New()
+	j.Options().Enable(jwt.FlattenAudience)
+	j.Set(jwt.IssuerKey, s.iss)
+	j.Set(jwt.IssuedAtKey, s.clock.Now())
+	j.Set(jwt.AudienceKey, aud)
+	j.Set("bid", s.bid)
+	j.Set("nonce", nonce)
+	for k, v := range claims {
+		j.Set(k, v)
+	}
+
+	headers := jws.NewHeaders()
+	headers.Set(jws.KeyIDKey, s.kid)
+	headers.Set(jws.TypeKey, "JWT")
+
+	signed, err := jwt.Sign(j, jwt.WithKey(jwa.ES256, s.key, jws.WithProtectedHeaders(headers)))
+	return string(signed), err
+}
+
+// newUUID 生成小写的 UUID v4
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
@@ -0,0 +1,161 @@
+package appstoreserverapi
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestOfferSigner(t *testing.T) (*PromotionalOfferSigner, *ecdsa.PrivateKey, time.Time) {
+	key, pk := newTestKey(t)
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	s, err := NewPromotionalOfferSigner(&Config{
+		Iss:   ISS,
+		Kid:   KID,
+		Bid:   BID,
+		Pk:    pk,
+		Clock: ClockFunc(func() time.Time { return now }),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, key, now
+}
+
+func TestPromotionalOfferSigner_Sign(t *testing.T) {
+	s, key, now := newTestOfferSigner(t)
+	sig, err := s.Sign("com.example.monthly", "OFFER1", "0C4B3F8E-5D7A-4B2F-9A3E-1B2C3D4E5F60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.KeyIdentifier != KID || sig.Timestamp != now.UnixNano()/int64(time.Millisecond) || len(sig.Nonce) != 36 {
+		t.Fatalf("signature = %+v", sig)
+	}
+	payload := strings.Join([]string{
+		BID,
+		KID,
+		"com.example.monthly",
+		"OFFER1",
+		"0c4b3f8e-5d7a-4b2f-9a3e-1b2c3d4e5f60",
+		sig.Nonce,
+		strconv.FormatInt(sig.Timestamp, 10),
+	}, "⁣")
+	digest := sha256.Sum256([]byte(payload))
+	b, err := base64.StdEncoding.DecodeString(sig.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], b) {
+		t.Error("signature does not verify over the \\u2063 joined payload")
+	}
+	// 其它分隔符的 payload 不能通过
+	wrong := sha256.Sum256([]byte(strings.ReplaceAll(payload, "⁣", "|")))
+	if ecdsa.VerifyASN1(&key.PublicKey, wrong[:], b) {
+		t.Error("signature verifies over the wrong payload")
+	}
+}
+
+// parseOfferJws 校验 JWS 头和签名，返回 claims
+func parseOfferJws(t *testing.T, signed string, key *ecdsa.PrivateKey, aud string) jwt.Token {
+	t.Helper()
+	msg, err := jws.ParseString(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers := msg.Signatures()[0].ProtectedHeaders()
+	if headers.Algorithm() != jwa.ES256 || headers.KeyID() != KID || headers.Type() != "JWT" {
+		t.Errorf("headers: alg = %s, kid = %s, typ = %s", headers.Algorithm(), headers.KeyID(), headers.Type())
+	}
+	token, err := jwt.ParseString(signed, jwt.WithKey(jwa.ES256, &key.PublicKey), jwt.WithValidate(true),
+		jwt.WithAudience(aud), jwt.WithIssuer(ISS), jwt.WithClock(jwt.ClockFunc(func() time.Time {
+			return time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bid, _ := token.Get("bid"); bid != BID {
+		t.Errorf("bid = %v", bid)
+	}
+	if nonce, _ := token.Get("nonce"); len(nonce.(string)) != 36 {
+		t.Errorf("nonce = %v", nonce)
+	}
+	return token
+}
+
+func TestPromotionalOfferSigner_Jws(t *testing.T) {
+	s, key, _ := newTestOfferSigner(t)
+
+	signers := map[string]func(string, string, string) (string, error){
+		"v2":      s.SignPromotionalOfferV2,
+		"winBack": s.SignWinBackOffer,
+	}
+	for name, sign := range signers {
+		signed, err := sign("com.example.monthly", "OFFER1", "2000000123456789")
+		if err != nil {
+			t.Fatal(err)
+		}
+		token := parseOfferJws(t, signed, key, audiencePromotionalOffer)
+		for k, want := range map[string]string{"productId": "com.example.monthly", "offerIdentifier": "OFFER1", "transactionId": "2000000123456789"} {
+			if v, _ := token.Get(k); v != want {
+				t.Errorf("%s: %s = %v, want %s", name, k, v, want)
+			}
+		}
+		// transactionId 可选
+		signed, err = sign("com.example.monthly", "OFFER1", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := parseOfferJws(t, signed, key, audiencePromotionalOffer).Get("transactionId"); ok {
+			t.Errorf("%s: transactionId should be omitted", name)
+		}
+	}
+
+	signed, err := s.SignIntroductoryOfferEligibility("com.example.monthly", true, "2000000123456789")
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := parseOfferJws(t, signed, key, audienceIntroductoryOfferEligibility)
+	if v, _ := token.Get("allowIntroductoryOffer"); v != true {
+		t.Errorf("allowIntroductoryOffer = %v", v)
+	}
+	if v, _ := token.Get("transactionId"); v != "2000000123456789" {
+		t.Errorf("transactionId = %v", v)
+	}
+}
+
+func TestNewPromotionalOfferSigner_Keys(t *testing.T) {
+	key, pk := newTestKey(t)
+	_, other := newTestKey(t)
+	s, err := NewPromotionalOfferSigner(&Config{
+		Iss:  ISS,
+		Bid:  BID,
+		Keys: []SigningKey{{Kid: "CURRENT", Pk: pk}, {Kid: "NEXT", Pk: other}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := s.Sign("com.example.monthly", "OFFER1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sig.KeyIdentifier != "CURRENT" {
+		t.Errorf("kid = %s, want CURRENT", sig.KeyIdentifier)
+	}
+	payload := strings.Join([]string{BID, "CURRENT", "com.example.monthly", "OFFER1", "", sig.Nonce, strconv.FormatInt(sig.Timestamp, 10)}, "⁣")
+	digest := sha256.Sum256([]byte(payload))
+	b, _ := base64.StdEncoding.DecodeString(sig.Signature)
+	if !ecdsa.VerifyASN1(&key.PublicKey, digest[:], b) {
+		t.Error("signature does not verify with the first key")
+	}
+
+	if _, err := NewPromotionalOfferSigner(&Config{Iss: ISS, Bid: BID, Keys: []SigningKey{{Kid: "NOKEY"}}}); err != ErrConfigInvalid {
+		t.Errorf("invalid key: err = %v, want %v", err, ErrConfigInvalid)
+	}
+}
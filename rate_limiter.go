@@ -0,0 +1,95 @@
+package appstoreserverapi
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter 请求限流，每次请求（包括重试）之前调用 Wait
+// Rate limits requests, Wait is called before each request including retries
+// doc: https://developer.apple.com/documentation/appstoreserverapi/identifying_rate_limits
+type RateLimiter interface {
+	Wait(ctx context.Context) error
+}
+
+// NewRateLimiter 令牌桶限流：每个 interval 最多 limit 个请求
+// limit: 至少为 1
+// interval: 不大于 0 时为 1 秒
+// clock: 用于计算补充的令牌，为空时使用 SystemClock；等待本身仍然使用系统计时器
+// Token bucket: at most limit requests per interval
+// limit: at least 1
+// interval: defaults to one second when not above 0
+// clock: used to refill the tokens, defaults to SystemClock; the wait itself still uses a system timer
+func NewRateLimiter(limit int, interval time.Duration, clock Clock) RateLimiter {
+	if limit < 1 {
+		limit = 1
+	}
+	if interval <= 0 {
+		interval = time.Second
+	}
+	// interval 小于 limit 纳秒时 perToken 为 0，补充令牌时会除以 0
+	perToken := interval / time.Duration(limit)
+	if perToken < 1 {
+		perToken = 1
+	}
+	if clock == nil {
+		clock = SystemClock
+	}
+	return &rateLimiter{
+		capacity: float64(limit),
+		tokens:   float64(limit),
+		perToken: perToken,
+		clock:    clock,
+		last:     clock.Now(),
+	}
+}
+
+type rateLimiter struct {
+	lock     sync.Mutex
+	capacity float64
+	tokens   float64
+	perToken time.Duration
+	clock    Clock
+	last     time.Time
+}
+
+func (r *rateLimiter) Wait(ctx context.Context) error {
+	wait := r.reserve()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		r.cancel()
+		return ctx.Err()
+	}
+}
+
+// reserve 取一个令牌，返回需要等待的时间
+func (r *rateLimiter) reserve() time.Duration {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	now := r.clock.Now()
+	r.tokens += float64(now.Sub(r.last)) / float64(r.perToken)
+	if r.tokens > r.capacity {
+		r.tokens = r.capacity
+	}
+	r.last = now
+	r.tokens--
+	if r.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-r.tokens * float64(r.perToken))
+}
+
+// cancel 归还未使用的令牌
+func (r *rateLimiter) cancel() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.tokens++
+}
@@ -0,0 +1,69 @@
+package appstoreserverapi
+
+import (
+	"context"
+	"math"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestNewRateLimiter_ZeroLimit(t *testing.T) {
+	limiter := NewRateLimiter(0, time.Hour, nil)
+	if err := limiter.Wait(context.Background()); err != nil {
+		t.Fatalf("first request: err = %v", err)
+	}
+	// limit 为 0 时按 1 处理，第二个请求需要等待
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := limiter.Wait(ctx); err != context.DeadlineExceeded {
+		t.Errorf("second request: err = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+func TestNewRateLimiter_InvalidInterval(t *testing.T) {
+	for _, interval := range []time.Duration{0, -time.Second, time.Nanosecond} {
+		limiter := NewRateLimiter(10, interval, ClockFunc(func() time.Time {
+			return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		})).(*rateLimiter)
+		if limiter.perToken <= 0 {
+			t.Fatalf("interval %s: perToken = %s", interval, limiter.perToken)
+		}
+		for i := 0; i < 11; i++ {
+			if wait := limiter.reserve(); wait < 0 {
+				t.Fatalf("interval %s: wait = %s", interval, wait)
+			}
+		}
+		if math.IsNaN(limiter.tokens) || limiter.tokens < -1 {
+			t.Errorf("interval %s: tokens = %v", interval, limiter.tokens)
+		}
+	}
+}
+
+func TestRateLimiter_Clock(t *testing.T) {
+	lock := sync.Mutex{}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	advance := func(d time.Duration) {
+		lock.Lock()
+		defer lock.Unlock()
+		now = now.Add(d)
+	}
+	limiter := NewRateLimiter(2, time.Hour, ClockFunc(func() time.Time {
+		lock.Lock()
+		defer lock.Unlock()
+		return now
+	})).(*rateLimiter)
+
+	if limiter.reserve() != 0 || limiter.reserve() != 0 {
+		t.Fatal("the first two requests should not wait")
+	}
+	if wait := limiter.reserve(); wait != 30*time.Minute {
+		t.Errorf("wait = %s, want 30m", wait)
+	}
+	limiter.cancel()
+	// 令牌按时钟补充，不需要真的等待
+	advance(30 * time.Minute)
+	if wait := limiter.reserve(); wait != 0 {
+		t.Errorf("wait after advancing the clock = %s, want 0", wait)
+	}
+}
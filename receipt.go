@@ -0,0 +1,274 @@
+package appstoreserverapi
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+// 解析 App 收据（PKCS#7 格式），用于从 verifyReceipt 迁移到 App Store Server API
+// Parsing the app receipt (PKCS#7), used to migrate from verifyReceipt to the App Store Server API
+// doc: https://developer.apple.com/documentation/appstorereceipts
+
+var (
+	ErrReceiptNotBase64    = errors.New("receipt is not valid base64")
+	ErrReceiptNotPKCS7     = errors.New("receipt is not a PKCS#7 signed data")
+	ErrReceiptNoContent    = errors.New("receipt has no content")
+	ErrReceiptInvalidField = errors.New("receipt field invalid")
+)
+
+var (
+	// 1.2.840.113549.1.7.2
+	oidPKCS7SignedData = []byte{0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x07, 0x02}
+	// 1.2.840.113549.1.7.1
+	oidPKCS7Data = []byte{0x2a, 0x86, 0x48, 0x86, 0xf7, 0x0d, 0x01, 0x07, 0x01}
+)
+
+// 收据字段类型
+// Receipt field types
+const (
+	receiptFieldBundleId                   = 2
+	receiptFieldApplicationVersion         = 3
+	receiptFieldCreationDate               = 12
+	receiptFieldInApp                      = 17
+	receiptFieldOriginalApplicationVersion = 19
+	receiptFieldExpirationDate             = 21
+
+	inAppFieldQuantity              = 1701
+	inAppFieldProductId             = 1702
+	inAppFieldTransactionId         = 1703
+	inAppFieldPurchaseDate          = 1704
+	inAppFieldOriginalTransactionId = 1705
+	inAppFieldOriginalPurchaseDate  = 1706
+	inAppFieldExpiresDate           = 1708
+	inAppFieldWebOrderLineItemId    = 1711
+	inAppFieldCancellationDate      = 1712
+)
+
+// AppReceipt App 收据，日期为毫秒时间戳
+// The app receipt, dates are milliseconds since epoch
+type AppReceipt struct {
+	BundleId                   string         `json:"bundleId,omitempty"`
+	ApplicationVersion         string         `json:"applicationVersion,omitempty"`
+	OriginalApplicationVersion string         `json:"originalApplicationVersion,omitempty"`
+	CreationDate               int64          `json:"creationDate,omitempty"`
+	ExpirationDate             int64          `json:"expirationDate,omitempty"`
+	InApp                      []InAppReceipt `json:"inApp"`
+}
+
+// InAppReceipt App 收据中的内购记录
+// An in-app purchase record of the app receipt
+type InAppReceipt struct {
+	Quantity              int64  `json:"quantity,omitempty"`
+	ProductId             string `json:"productId,omitempty"`
+	TransactionId         string `json:"transactionId,omitempty"`
+	OriginalTransactionId string `json:"originalTransactionId,omitempty"`
+	PurchaseDate          int64  `json:"purchaseDate,omitempty"`
+	OriginalPurchaseDate  int64  `json:"originalPurchaseDate,omitempty"`
+	ExpiresDate           int64  `json:"expiresDate,omitempty"`
+	WebOrderLineItemId    int64  `json:"webOrderLineItemId,omitempty"`
+	CancellationDate      int64  `json:"cancellationDate,omitempty"`
+}
+
+// ParseAppReceipt 在本地解析 base64 编码的 App 收据，不校验签名，只用于提取字段
+// Parses a base64 encoded app receipt locally, the signature is not verified, use it only to extract fields
+func ParseAppReceipt(receipt string) (*AppReceipt, error) {
+	content, err := receiptContent(receipt)
+	if err != nil {
+		return nil, err
+	}
+	result := &AppReceipt{
+		InApp: make([]InAppReceipt, 0),
+	}
+	err = eachReceiptField(content, func(typ int, value []byte) error {
+		var err error
+		switch typ {
+		case receiptFieldBundleId:
+			result.BundleId, err = receiptString(value)
+		case receiptFieldApplicationVersion:
+			result.ApplicationVersion, err = receiptString(value)
+		case receiptFieldOriginalApplicationVersion:
+			result.OriginalApplicationVersion, err = receiptString(value)
+		case receiptFieldCreationDate:
+			result.CreationDate, err = receiptDate(value)
+		case receiptFieldExpirationDate:
+			result.ExpirationDate, err = receiptDate(value)
+		case receiptFieldInApp:
+			var inApp *InAppReceipt
+			inApp, err = parseInAppReceipt(value)
+			if err == nil {
+				result.InApp = append(result.InApp, *inApp)
+			}
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func parseInAppReceipt(b []byte) (*InAppReceipt, error) {
+	result := &InAppReceipt{}
+	err := eachReceiptField(b, func(typ int, value []byte) error {
+		var err error
+		switch typ {
+		case inAppFieldQuantity:
+			result.Quantity, err = receiptInt(value)
+		case inAppFieldProductId:
+			result.ProductId, err = receiptString(value)
+		case inAppFieldTransactionId:
+			result.TransactionId, err = receiptString(value)
+		case inAppFieldOriginalTransactionId:
+			result.OriginalTransactionId, err = receiptString(value)
+		case inAppFieldPurchaseDate:
+			result.PurchaseDate, err = receiptDate(value)
+		case inAppFieldOriginalPurchaseDate:
+			result.OriginalPurchaseDate, err = receiptDate(value)
+		case inAppFieldExpiresDate:
+			result.ExpiresDate, err = receiptDate(value)
+		case inAppFieldWebOrderLineItemId:
+			result.WebOrderLineItemId, err = receiptInt(value)
+		case inAppFieldCancellationDate:
+			result.CancellationDate, err = receiptDate(value)
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// receiptContent 从 PKCS#7 中取出收据内容
+// ContentInfo ::= SEQUENCE { contentType OID, content [0] EXPLICIT SignedData }
+// SignedData ::= SEQUENCE { version, digestAlgorithms, contentInfo, ... }
+func receiptContent(receipt string) ([]byte, error) {
+	b, err := base64.StdEncoding.DecodeString(receipt)
+	if err != nil {
+		return nil, ErrReceiptNotBase64
+	}
+	contentInfo, _, err := readBER(b)
+	if err != nil || !contentInfo.is(berClassUniversal, berTagSequence) {
+		return nil, ErrReceiptNotPKCS7
+	}
+	items, err := contentInfo.children()
+	if err != nil || len(items) < 2 || !items[0].is(berClassUniversal, berTagOID) || !bytes.Equal(items[0].content, oidPKCS7SignedData) {
+		return nil, ErrReceiptNotPKCS7
+	}
+	explicit, err := items[1].children()
+	if err != nil || len(explicit) != 1 {
+		return nil, ErrReceiptNotPKCS7
+	}
+	signedData, err := explicit[0].children()
+	if err != nil || len(signedData) < 3 {
+		return nil, ErrReceiptNotPKCS7
+	}
+	inner, err := signedData[2].children()
+	if err != nil || len(inner) < 1 || !bytes.Equal(inner[0].content, oidPKCS7Data) {
+		return nil, ErrReceiptNotPKCS7
+	}
+	if len(inner) < 2 {
+		return nil, ErrReceiptNoContent
+	}
+	explicit, err = inner[1].children()
+	if err != nil || len(explicit) != 1 {
+		return nil, ErrReceiptNoContent
+	}
+	return explicit[0].octets()
+}
+
+// eachReceiptField 遍历收据字段
+// ReceiptAttribute ::= SEQUENCE { type INTEGER, version INTEGER, value OCTET STRING }
+func eachReceiptField(b []byte, fn func(typ int, value []byte) error) error {
+	set, _, err := readBER(b)
+	if err != nil || !set.is(berClassUniversal, berTagSet) {
+		return ErrReceiptInvalidField
+	}
+	attributes, err := set.children()
+	if err != nil {
+		return ErrReceiptInvalidField
+	}
+	for _, attribute := range attributes {
+		items, err := attribute.children()
+		if err != nil || len(items) != 3 {
+			return ErrReceiptInvalidField
+		}
+		typ, err := items[0].int64()
+		if err != nil {
+			return ErrReceiptInvalidField
+		}
+		value, err := items[2].octets()
+		if err != nil {
+			return ErrReceiptInvalidField
+		}
+		if err := fn(int(typ), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func receiptString(b []byte) (string, error) {
+	e, _, err := readBER(b)
+	if err != nil {
+		return "", ErrReceiptInvalidField
+	}
+	v, err := e.octets()
+	if err != nil {
+		return "", ErrReceiptInvalidField
+	}
+	return string(v), nil
+}
+
+func receiptInt(b []byte) (int64, error) {
+	e, _, err := readBER(b)
+	if err != nil || !e.is(berClassUniversal, berTagInteger) {
+		return 0, ErrReceiptInvalidField
+	}
+	return e.int64()
+}
+
+// receiptDate 日期为 RFC 3339 格式的 IA5String，空字符串表示没有值
+func receiptDate(b []byte) (int64, error) {
+	s, err := receiptString(b)
+	if err != nil {
+		return 0, err
+	}
+	if s == "" {
+		return 0, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, ErrReceiptInvalidField
+	}
+	return t.UnixNano() / int64(time.Millisecond), nil
+}
+
+var ErrReceiptNoTransactions = errors.New("receipt has no in-app transactions")
+
+// ExtractTransactionIdFromAppReceipt 从 App 收据中提取最近一笔交易的 ID，不发起网络请求
+// 返回值可以直接用于 ApiGetTransactionHistory
+// Extracts the ID of the most recent transaction from the app receipt, without a network call
+// The result can be passed straight to ApiGetTransactionHistory
+func ExtractTransactionIdFromAppReceipt(receipt string) (string, error) {
+	r, err := ParseAppReceipt(receipt)
+	if err != nil {
+		return "", err
+	}
+	var latest *InAppReceipt
+	for i := range r.InApp {
+		inApp := &r.InApp[i]
+		if inApp.TransactionId == "" {
+			continue
+		}
+		if latest == nil || inApp.PurchaseDate > latest.PurchaseDate {
+			latest = inApp
+		}
+	}
+	if latest == nil {
+		return "", ErrReceiptNoTransactions
+	}
+	return latest.TransactionId, nil
+}
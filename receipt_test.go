@@ -0,0 +1,130 @@
+package appstoreserverapi
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+// 本地构造的收据，结构和 App Store 的收据一致，但没有签名
+
+func berTLV(tag byte, content []byte) []byte {
+	l := len(content)
+	var b []byte
+	switch {
+	case l < 0x80:
+		b = []byte{tag, byte(l)}
+	case l < 0x100:
+		b = []byte{tag, 0x81, byte(l)}
+	default:
+		b = []byte{tag, 0x82, byte(l >> 8), byte(l)}
+	}
+	return append(b, content...)
+}
+
+// berIndefinite 不定长编码，App Store 的收据会使用这种编码
+func berIndefinite(tag byte, content []byte) []byte {
+	b := append([]byte{tag, 0x80}, content...)
+	return append(b, 0x00, 0x00)
+}
+
+func berInt(v int) []byte {
+	b := []byte{byte(v)}
+	for v > 0x7f {
+		v >>= 8
+		b = append([]byte{byte(v)}, b...)
+	}
+	return berTLV(0x02, b)
+}
+
+func receiptAttribute(typ int, value []byte) []byte {
+	content := append(berInt(typ), berInt(1)...)
+	content = append(content, berTLV(0x04, value)...)
+	return berTLV(0x30, content)
+}
+
+func receiptUTF8(typ int, s string) []byte {
+	return receiptAttribute(typ, berTLV(0x0c, []byte(s)))
+}
+
+func receiptIA5(typ int, s string) []byte {
+	return receiptAttribute(typ, berTLV(0x16, []byte(s)))
+}
+
+func buildInApp(transactionId, originalTransactionId, purchaseDate string) []byte {
+	content := receiptAttribute(1701, berInt(1))
+	content = append(content, receiptUTF8(1702, "com.example.monthly")...)
+	content = append(content, receiptUTF8(1703, transactionId)...)
+	content = append(content, receiptIA5(1704, purchaseDate)...)
+	content = append(content, receiptUTF8(1705, originalTransactionId)...)
+	return receiptAttribute(17, berTLV(0x31, content))
+}
+
+func buildReceipt(indefinite bool, inApps ...[]byte) string {
+	content := receiptUTF8(2, BID)
+	content = append(content, receiptUTF8(3, "1.2")...)
+	content = append(content, receiptUTF8(19, "1.0")...)
+	content = append(content, receiptIA5(12, "2023-05-01T08:00:00Z")...)
+	for _, inApp := range inApps {
+		content = append(content, inApp...)
+	}
+	payload := berTLV(0x31, content)
+
+	wrap := berTLV
+	if indefinite {
+		wrap = berIndefinite
+	}
+	oidData := berTLV(0x06, oidPKCS7Data)
+	oidSignedData := berTLV(0x06, oidPKCS7SignedData)
+	inner := wrap(0x30, append(oidData, wrap(0xa0, berTLV(0x04, payload))...))
+	signedData := append(berInt(1), berTLV(0x31, nil)...)
+	signedData = append(signedData, inner...)
+	signedData = append(signedData, berTLV(0x31, nil)...)
+	contentInfo := wrap(0x30, append(oidSignedData, wrap(0xa0, wrap(0x30, signedData))...))
+	return base64.StdEncoding.EncodeToString(contentInfo)
+}
+
+func TestParseAppReceipt(t *testing.T) {
+	for _, indefinite := range []bool{false, true} {
+		receipt := buildReceipt(indefinite,
+			buildInApp("2000000100", "2000000100", "2023-05-01T08:00:00Z"),
+		)
+		r, err := ParseAppReceipt(receipt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.BundleId != BID || r.ApplicationVersion != "1.2" || r.OriginalApplicationVersion != "1.0" {
+			t.Errorf("unexpected receipt: %+v", r)
+		}
+		if r.CreationDate != 1682928000000 {
+			t.Errorf("creationDate = %d", r.CreationDate)
+		}
+		if len(r.InApp) != 1 || r.InApp[0].TransactionId != "2000000100" || r.InApp[0].Quantity != 1 {
+			t.Errorf("unexpected in app: %+v", r.InApp)
+		}
+	}
+}
+
+func TestExtractTransactionIdFromAppReceipt(t *testing.T) {
+	receipt := buildReceipt(true,
+		buildInApp("2000000100", "2000000100", "2023-05-01T08:00:00Z"),
+		buildInApp("2000000300", "2000000100", "2023-07-01T08:00:00Z"),
+		buildInApp("2000000200", "2000000100", "2023-06-01T08:00:00Z"),
+	)
+	transactionId, err := ExtractTransactionIdFromAppReceipt(receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transactionId != "2000000300" {
+		t.Errorf("transactionId = %s, want 2000000300", transactionId)
+	}
+
+	_, err = ExtractTransactionIdFromAppReceipt(buildReceipt(false))
+	if err != ErrReceiptNoTransactions {
+		t.Errorf("err = %v, want %v", err, ErrReceiptNoTransactions)
+	}
+
+	_, err = ExtractTransactionIdFromAppReceipt("not a receipt")
+	if err != ErrReceiptNotBase64 {
+		t.Errorf("err = %v, want %v", err, ErrReceiptNotBase64)
+	}
+}
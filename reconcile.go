@@ -0,0 +1,281 @@
+package appstoreserverapi
+
+import (
+	"context"
+	"errors"
+	"reflect"
+)
+
+// DiffKind 本地存储和 Apple 不一致的类型
+// How the local store differs from Apple
+type DiffKind string
+
+const (
+	// DiffMissingTransaction 本地没有这笔交易
+	DiffMissingTransaction DiffKind = "missingTransaction"
+	// DiffStaleExpiry 本地的过期时间和 Apple 不一致，例如漏掉了续订或延期
+	DiffStaleExpiry DiffKind = "staleExpiry"
+	// DiffUnrecordedRefund Apple 已退款，本地没有记录
+	DiffUnrecordedRefund DiffKind = "unrecordedRefund"
+	// DiffReversedRefund Apple 已撤销退款，本地仍然记录为已退款
+	DiffReversedRefund DiffKind = "reversedRefund"
+	// DiffStaleRenewalInfo 本地没有续订信息，或者比 Apple 的旧
+	DiffStaleRenewalInfo DiffKind = "staleRenewalInfo"
+)
+
+// Diff 一处不一致
+// One difference
+type Diff struct {
+	Kind                  DiffKind `json:"kind"`
+	OriginalTransactionId string   `json:"originalTransactionId"`
+	TransactionId         string   `json:"transactionId,omitempty"`
+	// 本地的数据，本地没有时为空
+	// The local data, nil when missing locally
+	LocalTransaction *JWSTransactionDecodedPayload `json:"localTransaction,omitempty"`
+	LocalRenewalInfo *JWSRenewalInfoDecodedPayload `json:"localRenewalInfo,omitempty"`
+	// Apple 的数据
+	// Apple's data
+	RemoteTransaction *JWSTransactionDecodedPayload `json:"remoteTransaction,omitempty"`
+	RemoteRenewalInfo *JWSRenewalInfoDecodedPayload `json:"remoteRenewalInfo,omitempty"`
+	// 是否已经用 Apple 的数据修复，存储保留了更新的本地数据时为 false
+	// Whether it was repaired with Apple's data, false when the store kept a newer local copy
+	Repaired bool `json:"repaired"`
+}
+
+// ReconcileReport 一个 originalTransactionId 的对账结果
+// The reconciliation result of one originalTransactionId
+type ReconcileReport struct {
+	OriginalTransactionId string `json:"originalTransactionId"`
+	Diffs                 []Diff `json:"diffs"`
+	Err                   error  `json:"-"`
+}
+
+// Reconciler 对比本地存储和 ApiGetAllSubscriptionStatuses、ApiGetTransactionHistory 的结果
+// 客户端设置了 Config.Store 时调用接口会写入存储，所以本地数据在调用接口之前读取；只报告不修复时应使用没有 Store 的客户端
+// Compares a local store with ApiGetAllSubscriptionStatuses and ApiGetTransactionHistory
+// A client with Config.Store writes into the store while calling, so local data is read before calling; use a client without Store to report without repairing
+type Reconciler struct {
+	client      Client
+	store       TransactionStore
+	repair      bool
+	concurrency int
+}
+
+// NewReconciler 创建对账
+// repair: 是否用 Apple 的数据修复本地存储
+// concurrency: 同时对账的数量，至少为 1
+// repair: whether to repair the local store with Apple's data
+// concurrency: how many are reconciled at once, at least 1
+func NewReconciler(client Client, store TransactionStore, repair bool, concurrency int) *Reconciler {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Reconciler{
+		client:      client,
+		store:       store,
+		repair:      repair,
+		concurrency: concurrency,
+	}
+}
+
+// Reconcile 对账多个 originalTransactionId，结果和参数的顺序一致
+// ctx 取消后不再开始新的对账，未开始的 Err 为 ctx.Err()
+// Reconciles several originalTransactionIds, results are in the same order
+// once ctx is done no new reconciliation is started, those not started get ctx.Err()
+func (rc *Reconciler) Reconcile(ctx context.Context, originalTransactionIds []string) []ReconcileReport {
+	reports := make([]ReconcileReport, len(originalTransactionIds))
+	fanOut(ctx, len(originalTransactionIds), rc.concurrency, func(i int) {
+		reports[i] = rc.ReconcileOne(ctx, originalTransactionIds[i])
+	}, func(i int, err error) {
+		reports[i] = ReconcileReport{OriginalTransactionId: originalTransactionIds[i], Err: err}
+	})
+	return reports
+}
+
+// ReconcileOne 对账一个 originalTransactionId，ctx 通过 WithContext 传给每次调用
+// 订阅状态不读取 Config.StatusCache，总是和 Apple 的最新数据对比
+// Reconciles one originalTransactionId, ctx is passed to each call with WithContext
+// subscription statuses skip Config.StatusCache, so Apple's latest data is always compared
+func (rc *Reconciler) ReconcileOne(ctx context.Context, originalTransactionId string) ReconcileReport {
+	report := ReconcileReport{OriginalTransactionId: originalTransactionId, Diffs: make([]Diff, 0)}
+
+	localTransactions, err := rc.store.TransactionsByOriginalTransactionId(originalTransactionId)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+	local := make(map[string]JWSTransactionDecodedPayload, len(localTransactions))
+	for _, tx := range localTransactions {
+		local[tx.TransactionId] = tx
+	}
+	localRenewal, err := rc.store.RenewalInfo(originalTransactionId)
+	if err != nil && !errors.Is(err, ErrStoreNotFound) {
+		report.Err = err
+		return report
+	}
+
+	remote, remoteRenewal, err := rc.fetch(ctx, originalTransactionId)
+	if err != nil {
+		report.Err = err
+		return report
+	}
+
+	for i := range remote {
+		tx := &remote[i]
+		diff := Diff{
+			OriginalTransactionId: originalTransactionId,
+			TransactionId:         tx.TransactionId,
+			RemoteTransaction:     tx,
+		}
+		old, ok := local[tx.TransactionId]
+		switch {
+		case !ok:
+			diff.Kind = DiffMissingTransaction
+		case old.SignedDate > tx.SignedDate:
+			// 本地的数据签名更晚，不是不一致
+			continue
+		case tx.RevocationDate > 0 && old.RevocationDate == 0:
+			diff.Kind = DiffUnrecordedRefund
+		case tx.RevocationDate == 0 && old.RevocationDate > 0:
+			diff.Kind = DiffReversedRefund
+		case tx.ExpiresDate != old.ExpiresDate:
+			diff.Kind = DiffStaleExpiry
+		default:
+			continue
+		}
+		if ok {
+			diff.LocalTransaction = &old
+		}
+		report.Diffs = append(report.Diffs, diff)
+	}
+	if remoteRenewal != nil && (localRenewal == nil || localRenewal.SignedDate < remoteRenewal.SignedDate) {
+		report.Diffs = append(report.Diffs, Diff{
+			Kind:                  DiffStaleRenewalInfo,
+			OriginalTransactionId: originalTransactionId,
+			LocalRenewalInfo:      localRenewal,
+			RemoteRenewalInfo:     remoteRenewal,
+		})
+	}
+
+	if rc.repair {
+		report.Err = rc.repairDiffs(report.Diffs)
+	}
+	return report
+}
+
+// fetch Apple 的交易和续订信息，只保留该 originalTransactionId 的，同一笔交易取 signedDate 最新的
+// 还有更多历史记录但 revision 没有变化时返回 ErrSyncNoProgress
+func (rc *Reconciler) fetch(ctx context.Context, originalTransactionId string) ([]JWSTransactionDecodedPayload, *JWSRenewalInfoDecodedPayload, error) {
+	byId := make(map[string]JWSTransactionDecodedPayload)
+	add := func(tx JWSTransactionDecodedPayload) {
+		if tx.OriginalTransactionId != originalTransactionId {
+			return
+		}
+		if old, ok := byId[tx.TransactionId]; ok && old.SignedDate > tx.SignedDate {
+			return
+		}
+		byId[tx.TransactionId] = tx
+	}
+
+	revision := ""
+	for {
+		r, err := rc.client.ApiGetTransactionHistory(originalTransactionId, false, WithContext(ctx), WithRevision(revision))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, tx := range r.SignedTransactions {
+			add(tx)
+		}
+		if !r.HasMore {
+			break
+		}
+		if r.Revision == revision {
+			return nil, nil, ErrSyncNoProgress
+		}
+		revision = r.Revision
+	}
+
+	var renewal *JWSRenewalInfoDecodedPayload
+	statuses, err := rc.client.ApiGetAllSubscriptionStatuses(originalTransactionId, WithContext(ctx), WithoutCache())
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, data := range statuses.Data {
+		for _, last := range data.LastTransactions {
+			if last.OriginalTransactionId != originalTransactionId {
+				continue
+			}
+			add(last.SignedTransactionInfo)
+			if last.SignedRenewalInfo.OriginalTransactionId != "" {
+				r := last.SignedRenewalInfo
+				renewal = &r
+			}
+		}
+	}
+
+	transactions := make([]JWSTransactionDecodedPayload, 0, len(byId))
+	for _, tx := range byId {
+		transactions = append(transactions, tx)
+	}
+	sortTransactions(transactions)
+	return transactions, renewal, nil
+}
+
+func (rc *Reconciler) repairDiffs(diffs []Diff) error {
+	transactions := make([]JWSTransactionDecodedPayload, 0)
+	renewals := make([]JWSRenewalInfoDecodedPayload, 0)
+	for _, diff := range diffs {
+		if diff.RemoteTransaction != nil {
+			transactions = append(transactions, *diff.RemoteTransaction)
+		}
+		if diff.RemoteRenewalInfo != nil {
+			renewals = append(renewals, *diff.RemoteRenewalInfo)
+		}
+	}
+	if err := storePayloads(rc.store, transactions, renewals); err != nil {
+		return err
+	}
+	for i := range diffs {
+		repaired, err := rc.stored(diffs[i])
+		if err != nil {
+			return err
+		}
+		diffs[i].Repaired = repaired
+	}
+	return nil
+}
+
+// stored 存储中的数据是否就是 Apple 的数据：签名更晚的本地数据不会被覆盖
+func (rc *Reconciler) stored(diff Diff) (bool, error) {
+	if diff.RemoteTransaction != nil {
+		tx, err := rc.store.Transaction(diff.RemoteTransaction.TransactionId)
+		if errors.Is(err, ErrStoreNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return reflect.DeepEqual(*tx, *diff.RemoteTransaction), nil
+	}
+	if diff.RemoteRenewalInfo != nil {
+		renewal, err := rc.store.RenewalInfo(diff.RemoteRenewalInfo.OriginalTransactionId)
+		if errors.Is(err, ErrStoreNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return reflect.DeepEqual(*renewal, *diff.RemoteRenewalInfo), nil
+	}
+	return false, nil
+}
+
+// Kinds 各类不一致的数量
+// The number of differences of each kind
+func (r ReconcileReport) Kinds() map[DiffKind]int {
+	kinds := make(map[DiffKind]int)
+	for _, diff := range r.Diffs {
+		kinds[diff.Kind]++
+	}
+	return kinds
+}
@@ -0,0 +1,203 @@
+package appstoreserverapi_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/lhlyu/appstoreserverapi"
+	"github.com/lhlyu/appstoreserverapi/storetest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReconciler(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	sc := storetest.MonthlySubscription("1000", "monthly", start).Renew(3)
+
+	// 本地漏掉了一次续订通知、退款通知，另一笔交易的过期时间是旧的
+	local := appstoreserverapi.NewMemoryStore()
+	stale := sc.Transactions[1]
+	stale.ExpiresDate -= int64(24 * time.Hour / time.Millisecond)
+	local.PutTransactions(sc.Transactions[0], stale, sc.Transactions[3])
+
+	sc.Refund(start.AddDate(0, 3, 5))
+	_, client := newStoreTestClient(t, func(cfg *appstoreserverapi.Config) {
+		cfg.TryCount = 1
+	}, sc)
+
+	report := appstoreserverapi.NewReconciler(client, local, false, 1).ReconcileOne(context.Background(), "1000")
+	if report.Err != nil {
+		t.Fatal(report.Err)
+	}
+	want := []appstoreserverapi.DiffKind{
+		appstoreserverapi.DiffStaleExpiry,
+		appstoreserverapi.DiffMissingTransaction,
+		appstoreserverapi.DiffUnrecordedRefund,
+		appstoreserverapi.DiffStaleRenewalInfo,
+	}
+	if len(report.Diffs) != len(want) {
+		t.Fatalf("diffs = %+v, want %v", report.Diffs, want)
+	}
+	for i, diff := range report.Diffs {
+		if diff.Kind != want[i] || diff.Repaired {
+			t.Errorf("diff %d = %s (repaired %v), want %s", i, diff.Kind, diff.Repaired, want[i])
+		}
+	}
+	if tx, _ := local.Transaction(sc.Transactions[2].TransactionId); tx != nil {
+		t.Error("report-only reconciliation should not write to the store")
+	}
+
+	reports := appstoreserverapi.NewReconciler(client, local, true, 2).Reconcile(context.Background(), []string{"1000"})
+	if reports[0].Err != nil || len(reports[0].Diffs) != len(want) || !reports[0].Diffs[0].Repaired {
+		t.Fatalf("repair report = %+v", reports[0])
+	}
+	report = appstoreserverapi.NewReconciler(client, local, false, 1).ReconcileOne(context.Background(), "1000")
+	if report.Err != nil || len(report.Diffs) != 0 {
+		t.Errorf("after repair: diffs = %+v, err = %v", report.Diffs, report.Err)
+	}
+}
+
+func TestReconciler_LocalNewer(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	sc := storetest.MonthlySubscription("1500", "monthly", start)
+
+	// 本地的交易签名更晚，不是不一致
+	local := appstoreserverapi.NewMemoryStore()
+	newer := sc.Transactions[0]
+	newer.ExpiresDate -= int64(24 * time.Hour / time.Millisecond)
+	newer.SignedDate = time.Now().AddDate(1, 0, 0).UnixNano() / int64(time.Millisecond)
+	local.PutTransactions(newer)
+
+	_, client := newStoreTestClient(t, nil, sc)
+	report := appstoreserverapi.NewReconciler(client, local, true, 1).ReconcileOne(context.Background(), "1500")
+	if report.Err != nil {
+		t.Fatal(report.Err)
+	}
+	if kinds := report.Kinds(); len(kinds) != 1 || kinds[appstoreserverapi.DiffStaleRenewalInfo] != 1 {
+		t.Errorf("kinds = %v, want only %s", kinds, appstoreserverapi.DiffStaleRenewalInfo)
+	}
+	if tx, _ := local.Transaction(newer.TransactionId); tx.SignedDate != newer.SignedDate {
+		t.Error("the newer local transaction should be kept")
+	}
+}
+
+func TestReconciler_ReversedRefund(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	sc := storetest.MonthlySubscription("1600", "monthly", start)
+
+	// 本地记录了退款，Apple 之后撤销了退款
+	local := appstoreserverapi.NewMemoryStore()
+	refunded := sc.Transactions[0]
+	refunded.RevocationDate = start.AddDate(0, 0, 5).UnixNano() / int64(time.Millisecond)
+	local.PutTransactions(refunded)
+
+	_, client := newStoreTestClient(t, nil, sc)
+	report := appstoreserverapi.NewReconciler(client, local, true, 1).ReconcileOne(context.Background(), "1600")
+	if report.Err != nil {
+		t.Fatal(report.Err)
+	}
+	if kinds := report.Kinds(); kinds[appstoreserverapi.DiffReversedRefund] != 1 {
+		t.Fatalf("kinds = %v, want %s", kinds, appstoreserverapi.DiffReversedRefund)
+	}
+	if tx, _ := local.Transaction(refunded.TransactionId); tx.RevocationDate != 0 {
+		t.Error("the reversed refund should be repaired")
+	}
+}
+
+// keepingStore 不写入交易，找不到时返回包装过的 ErrStoreNotFound
+type keepingStore struct {
+	*appstoreserverapi.MemoryStore
+}
+
+func (s keepingStore) PutTransactions(transactions ...appstoreserverapi.JWSTransactionDecodedPayload) error {
+	return nil
+}
+
+func (s keepingStore) Transaction(transactionId string) (*appstoreserverapi.JWSTransactionDecodedPayload, error) {
+	tx, err := s.MemoryStore.Transaction(transactionId)
+	if err != nil {
+		return nil, fmt.Errorf("transaction %s: %w", transactionId, err)
+	}
+	return tx, nil
+}
+
+func (s keepingStore) RenewalInfo(originalTransactionId string) (*appstoreserverapi.JWSRenewalInfoDecodedPayload, error) {
+	renewal, err := s.MemoryStore.RenewalInfo(originalTransactionId)
+	if err != nil {
+		return nil, fmt.Errorf("renewal info %s: %w", originalTransactionId, err)
+	}
+	return renewal, nil
+}
+
+func TestReconciler_NotRepaired(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	sc := storetest.MonthlySubscription("1700", "monthly", start)
+
+	_, client := newStoreTestClient(t, nil, sc)
+	report := appstoreserverapi.NewReconciler(client, keepingStore{appstoreserverapi.NewMemoryStore()}, true, 1).ReconcileOne(context.Background(), "1700")
+	if report.Err != nil {
+		t.Fatal(report.Err)
+	}
+	for _, diff := range report.Diffs {
+		if diff.Kind == appstoreserverapi.DiffMissingTransaction && diff.Repaired {
+			t.Error("a transaction the store did not write should not be marked repaired")
+		}
+		if diff.Kind == appstoreserverapi.DiffStaleRenewalInfo && !diff.Repaired {
+			t.Error("the written renewal info should be marked repaired")
+		}
+	}
+	if kinds := report.Kinds(); kinds[appstoreserverapi.DiffMissingTransaction] != 1 || kinds[appstoreserverapi.DiffStaleRenewalInfo] != 1 {
+		t.Errorf("kinds = %v", kinds)
+	}
+}
+
+func TestReconciler_NoProgress(t *testing.T) {
+	// 还有更多历史记录，但 revision 没有变化
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"revision":"r1","hasMore":true,"signedTransactions":[]}`))
+	}))
+	defer srv.Close()
+	_, client := newStoreTestClient(t, func(cfg *appstoreserverapi.Config) {
+		cfg.TryCount = 1
+		cfg.BaseUrls = map[appstoreserverapi.Env]string{cfg.Evn: srv.URL}
+	})
+	rc := appstoreserverapi.NewReconciler(client, appstoreserverapi.NewMemoryStore(), false, 1)
+	if report := rc.ReconcileOne(context.Background(), "1600"); report.Err != appstoreserverapi.ErrSyncNoProgress {
+		t.Errorf("err = %v, want %v", report.Err, appstoreserverapi.ErrSyncNoProgress)
+	}
+}
+
+func TestReconciler_ContextAndCache(t *testing.T) {
+	s, client := newStoreTestClient(t, func(cfg *appstoreserverapi.Config) {
+		cfg.TryCount = 1
+		cfg.StatusCache = appstoreserverapi.NewLRUStatusCache(10, 0, nil)
+	}, storetest.MonthlySubscription("2000", "monthly", time.Now().AddDate(0, -1, 0)).Renew(1))
+	if _, err := client.ApiGetAllSubscriptionStatuses("2000"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 缓存中已有结果，对账仍然请求 Apple
+	calls := s.Calls(storetest.EndpointGetAllSubscriptionStatuses)
+	rc := appstoreserverapi.NewReconciler(client, appstoreserverapi.NewMemoryStore(), false, 1)
+	if report := rc.ReconcileOne(context.Background(), "2000"); report.Err != nil {
+		t.Fatal(report.Err)
+	}
+	if got := s.Calls(storetest.EndpointGetAllSubscriptionStatuses) - calls; got != 1 {
+		t.Errorf("status calls = %d, want 1: reconciliation should bypass the cache", got)
+	}
+
+	// ctx 通过 WithContext 传给每次调用
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	calls = s.Calls(storetest.EndpointGetTransactionHistory)
+	if report := rc.ReconcileOne(ctx, "2000"); report.Err != context.Canceled {
+		t.Errorf("err = %v, want %v", report.Err, context.Canceled)
+	}
+	if got := s.Calls(storetest.EndpointGetTransactionHistory) - calls; got != 0 {
+		t.Errorf("history calls after cancel = %d, want 0", got)
+	}
+	if reports := rc.Reconcile(ctx, []string{"2000", "3000"}); reports[0].Err != context.Canceled || reports[1].Err != context.Canceled {
+		t.Errorf("reports = %+v, want canceled", reports)
+	}
+}
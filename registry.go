@@ -0,0 +1,119 @@
+package appstoreserverapi
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var ErrBundleIdNotRegistered = errors.New("bundle id not registered")
+
+// Registry 同一个发行人下多个应用的客户端
+// 所有客户端共用一个秘钥、HTTP 客户端和配置的限流，每个客户端的 JWT 使用自己的 bid
+// Clients for several apps under one issuer
+// They share one key, HTTP client and the configured rate limiter, each client signs its JWT with its own bid
+type Registry struct {
+	cfg Config
+
+	lock    sync.RWMutex
+	clients map[string]Client
+}
+
+// NewRegistry 创建客户端注册表
+// cfg: 所有应用共用的配置，Bid 会被忽略；RateLimiter 为空时和 NewClient 一样不限流
+// bundleIds: 需要注册的应用
+// cfg: the config shared by all apps, Bid is ignored; without a RateLimiter requests are not throttled, same as NewClient
+func NewRegistry(cfg *Config, bundleIds ...string) (*Registry, error) {
+	if cfg == nil {
+		return nil, ErrConfigIsNil
+	}
+	shared := *cfg
+	if shared.Iss == "" || !shared.hasKey() {
+		return nil, ErrConfigInvalid
+	}
+	// 只解析一次私钥
+	keys := make([]SigningKey, 0)
+	for _, k := range shared.signingKeys() {
+		if k.Key == nil {
+			key, err := privateKeyFromReader(strings.NewReader(k.Pk))
+			if err != nil {
+				return nil, err
+			}
+			k.Key = StaticSigner(key)
+		}
+		keys = append(keys, k)
+	}
+	shared.Keys = keys
+	if shared.HttpClient == nil {
+		shared.HttpClient = &http.Client{}
+	}
+	r := &Registry{
+		cfg:     shared,
+		clients: make(map[string]Client),
+	}
+	for _, bundleId := range bundleIds {
+		if _, err := r.Register(bundleId); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Register 注册应用，已经注册时返回已有的客户端
+// Registers an app, returns the existing client when already registered
+func (r *Registry) Register(bundleId string) (Client, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if c, ok := r.clients[bundleId]; ok {
+		return c, nil
+	}
+	cfg := r.cfg
+	cfg.Bid = bundleId
+	c, err := NewClient(&cfg)
+	if err != nil {
+		return nil, err
+	}
+	r.clients[bundleId] = c
+	return c, nil
+}
+
+// Client 按 bundleId 获取客户端
+// Returns the client of the bundle ID
+func (r *Registry) Client(bundleId string) (Client, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	c, ok := r.clients[bundleId]
+	if !ok {
+		return nil, ErrBundleIdNotRegistered
+	}
+	return c, nil
+}
+
+// ClientForNotification 解码通知，按通知中的 bundleId 获取客户端
+// Decodes the notification and returns the client of its bundleId
+func (r *Registry) ClientForNotification(signedPayload string) (Client, *ResponseBodyV2DecodedPayload, error) {
+	notification, err := DecodeNotification(signedPayload, r.cfg.Verifier)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := r.Client(notification.BundleId())
+	if err != nil {
+		return nil, notification, err
+	}
+	return c, notification, nil
+}
+
+// BundleIds 已注册的应用
+// The registered bundle IDs
+func (r *Registry) BundleIds() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	bundleIds := make([]string, 0, len(r.clients))
+	for bundleId := range r.clients {
+		bundleIds = append(bundleIds, bundleId)
+	}
+	sort.Strings(bundleIds)
+	return bundleIds
+}
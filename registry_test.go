@@ -0,0 +1,154 @@
+package appstoreserverapi
+
+import (
+	"encoding/json"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newRegistryTestServer 返回订单查询的结果，并记录每个请求 JWT 中的 bid
+func newRegistryTestServer(t *testing.T) (*httptest.Server, func() []string) {
+	lock := sync.Mutex{}
+	bids := make([]string, 0)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := jwt.ParseString(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "), jwt.WithVerify(false), jwt.WithValidate(false))
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		bid, _ := token.Get("bid")
+		lock.Lock()
+		bids = append(bids, bid.(string))
+		lock.Unlock()
+		w.Write([]byte(`{"status":1,"signedTransactions":[]}`))
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string(nil), bids...)
+	}
+}
+
+func newTestRegistry(t *testing.T, cfg *Config, bundleIds ...string) *Registry {
+	r, err := NewRegistry(cfg, bundleIds...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestNewRegistry(t *testing.T) {
+	_, pk := newTestKey(t)
+	if _, err := NewRegistry(nil); err != ErrConfigIsNil {
+		t.Errorf("nil config: err = %v", err)
+	}
+	if _, err := NewRegistry(&Config{Iss: ISS, Kid: KID}); err != ErrConfigInvalid {
+		t.Errorf("no key: err = %v", err)
+	}
+	if _, err := NewRegistry(&Config{Iss: ISS, Kid: KID, Pk: "not a key"}); err == nil {
+		t.Error("bad key should fail")
+	}
+
+	r := newTestRegistry(t, &Config{Iss: ISS, Kid: KID, Pk: pk}, "com.example.b", "com.example.a")
+	if got := r.BundleIds(); len(got) != 2 || got[0] != "com.example.a" || got[1] != "com.example.b" {
+		t.Errorf("bundle ids = %v", got)
+	}
+	// 没有设置 RateLimiter 时和 NewClient 一样不限流
+	a, _ := r.Client("com.example.a")
+	b, _ := r.Client("com.example.b")
+	if a.(*client).cfg.RateLimiter != nil || b.(*client).cfg.RateLimiter != nil {
+		t.Error("clients should not be throttled without a configured rate limiter")
+	}
+	if a.(*client).cfg.HttpClient != b.(*client).cfg.HttpClient {
+		t.Error("clients should share the HTTP client")
+	}
+
+	limiter := NewRateLimiter(5, time.Second, nil)
+	r = newTestRegistry(t, &Config{Iss: ISS, Kid: KID, Pk: pk, RateLimiter: limiter}, "com.example.a", "com.example.b")
+	a, _ = r.Client("com.example.a")
+	b, _ = r.Client("com.example.b")
+	if a.(*client).cfg.RateLimiter != limiter || b.(*client).cfg.RateLimiter != limiter {
+		t.Error("clients should share the configured rate limiter")
+	}
+}
+
+func TestRegistry_Register(t *testing.T) {
+	_, pk := newTestKey(t)
+	srv, bids := newRegistryTestServer(t)
+	r := newTestRegistry(t, &Config{Iss: ISS, Kid: KID, Pk: pk, Bid: "ignored", BaseUrls: map[Env]string{Production: srv.URL}})
+
+	if _, err := r.Client("com.example.a"); err != ErrBundleIdNotRegistered {
+		t.Errorf("err = %v, want %v", err, ErrBundleIdNotRegistered)
+	}
+	a, err := r.Register("com.example.a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := r.Register("com.example.a")
+	if again != a {
+		t.Error("registering twice should return the existing client")
+	}
+	b, _ := r.Register("com.example.b")
+	if _, err := r.Register(""); err != ErrConfigInvalid {
+		t.Errorf("empty bundle id: err = %v", err)
+	}
+
+	// 每个客户端的 JWT 使用自己的 bid
+	if _, err := a.ApiLookUpOrderId("ORDER1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ApiLookUpOrderId("ORDER1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := bids(); len(got) != 2 || got[0] != "com.example.a" || got[1] != "com.example.b" {
+		t.Errorf("bids = %v", got)
+	}
+}
+
+func TestRegistry_ClientForNotification(t *testing.T) {
+	key, pk := newTestKey(t)
+	r := newTestRegistry(t, &Config{Iss: ISS, Kid: KID, Pk: pk}, "com.example.a", "com.example.b")
+	sign := func(bundleId string) string {
+		b, _ := json.Marshal(map[string]interface{}{
+			"notificationType": "DID_RENEW",
+			"notificationUUID": "5f7b3d1e-4c2a-4b8e-9f6d-0a1b2c3d4e5f",
+			"data":             map[string]interface{}{"bundleId": bundleId, "environment": "Sandbox"},
+		})
+		signed, err := jws.Sign(b, jws.WithKey(jwa.ES256, key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(signed)
+	}
+
+	for _, bundleId := range []string{"com.example.a", "com.example.b"} {
+		c, notification, err := r.ClientForNotification(sign(bundleId))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := r.Client(bundleId)
+		if c != want {
+			t.Errorf("%s: routed to the wrong client", bundleId)
+		}
+		if notification.BundleId() != bundleId || notification.NotificationType != "DID_RENEW" {
+			t.Errorf("notification = %+v", notification)
+		}
+	}
+
+	// 未注册的应用仍然返回解码后的通知
+	c, notification, err := r.ClientForNotification(sign("com.example.other"))
+	if err != ErrBundleIdNotRegistered || c != nil || notification == nil || notification.BundleId() != "com.example.other" {
+		t.Errorf("unregistered: client = %v, notification = %v, err = %v", c, notification, err)
+	}
+	if _, _, err := r.ClientForNotification("not a jws"); err == nil {
+		t.Error("bad payload should fail")
+	}
+}
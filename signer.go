@@ -0,0 +1,131 @@
+package appstoreserverapi
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+var ErrSignerNotP256 = errors.New("signer must use an ECDSA P-256 key")
+
+// KeyProvider 提供签名用的秘钥，可以是内存中的私钥，也可以是 HSM、KMS 等外部存储
+// Provides the signing key, either an in-memory private key or one kept in an HSM, KMS etc.
+type KeyProvider interface {
+	Signer() (crypto.Signer, error)
+}
+
+// KeyProviderFunc 函数形式的 KeyProvider
+type KeyProviderFunc func() (crypto.Signer, error)
+
+func (f KeyProviderFunc) Signer() (crypto.Signer, error) {
+	return f()
+}
+
+// PemKey PKCS#8 PEM 格式的私钥，即 Config.Pk
+// A PKCS#8 PEM private key, the same as Config.Pk
+func PemKey(pk string) KeyProvider {
+	return KeyProviderFunc(func() (crypto.Signer, error) {
+		return privateKeyFromReader(strings.NewReader(pk))
+	})
+}
+
+// KeyFile 从 App Store Connect 下载的 .p8 秘钥文件，每次签名时读取，替换文件即可更换秘钥
+// The .p8 key file downloaded from App Store Connect, read on each signature so the file can be replaced
+func KeyFile(path string) KeyProvider {
+	return KeyProviderFunc(func() (crypto.Signer, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return privateKeyFromReader(f)
+	})
+}
+
+// KeyReader 从 io.Reader 中读取一次私钥
+// Reads the private key from an io.Reader once
+func KeyReader(rd io.Reader) KeyProvider {
+	var (
+		once sync.Once
+		key  crypto.Signer
+		err  error
+	)
+	return KeyProviderFunc(func() (crypto.Signer, error) {
+		once.Do(func() {
+			key, err = privateKeyFromReader(rd)
+		})
+		return key, err
+	})
+}
+
+// StaticSigner 任意的 crypto.Signer，例如 PKCS#11、云 KMS，公钥必须是 ECDSA P-256
+// Any crypto.Signer such as PKCS#11 or a cloud KMS, the public key must be ECDSA P-256
+func StaticSigner(signer crypto.Signer) KeyProvider {
+	return KeyProviderFunc(func() (crypto.Signer, error) {
+		pub, ok := signer.Public().(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() {
+			return nil, ErrSignerNotP256
+		}
+		return signer, nil
+	})
+}
+
+// SigningKey App Store Connect 中的一个秘钥
+// A key from App Store Connect
+type SigningKey struct {
+	// 秘钥ID
+	// Key ID
+	Kid string
+	// PKCS#8 PEM 格式的私钥
+	// PKCS#8 PEM private key
+	Pk string
+	// 秘钥提供者：可选，设置后代替 Pk
+	// Key provider: optional, replaces Pk
+	Key KeyProvider
+}
+
+func (k SigningKey) keyProvider() KeyProvider {
+	if k.Key != nil {
+		return k.Key
+	}
+	return PemKey(k.Pk)
+}
+
+func (k SigningKey) valid() bool {
+	return k.Kid != "" && (k.Pk != "" || k.Key != nil)
+}
+
+// signingKeys 设置了 Keys 时使用 Keys，否则使用 Kid、Pk、Key
+func (cfg *Config) signingKeys() []SigningKey {
+	if len(cfg.Keys) > 0 {
+		return cfg.Keys
+	}
+	return []SigningKey{{Kid: cfg.Kid, Pk: cfg.Pk, Key: cfg.Key}}
+}
+
+func (cfg *Config) hasKey() bool {
+	for _, k := range cfg.signingKeys() {
+		if !k.valid() {
+			return false
+		}
+	}
+	return true
+}
+
+// RotationPolicy 秘钥轮换策略
+// How the signing keys are rotated
+type RotationPolicy string
+
+const (
+	// RotateOnUnauthorized 一直使用当前秘钥，收到 401 后切换到下一个秘钥
+	// Keep using the current key, switch to the next one after a 401
+	RotateOnUnauthorized RotationPolicy = "unauthorized"
+	// RotateRoundRobin 每次签名轮流使用秘钥，收到 401 后同样切换到下一个秘钥
+	// Use the keys in turn for each signature, a 401 also switches to the next one
+	RotateRoundRobin RotationPolicy = "roundRobin"
+)
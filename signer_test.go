@@ -0,0 +1,102 @@
+package appstoreserverapi
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// softSigner 模拟 PKCS#11 之类的外部签名，私钥不对外暴露
+type softSigner struct {
+	key   *ecdsa.PrivateKey
+	calls int
+}
+
+func (s *softSigner) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+func (s *softSigner) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	s.calls++
+	return ecdsa.SignASN1(rand, s.key, digest)
+}
+
+func newTestKey(t *testing.T) (*ecdsa.PrivateKey, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b}))
+}
+
+func verifyTestJwt(t *testing.T, signed string, pub crypto.PublicKey) {
+	token, err := jwt.ParseString(signed, jwt.WithKey(jwa.ES256, pub), jwt.WithAudience(AUD))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bid, _ := token.Get("bid"); bid != BID {
+		t.Errorf("bid = %v, want %s", bid, BID)
+	}
+}
+
+func TestSignJwt_KeyProvider(t *testing.T) {
+	key, pk := newTestKey(t)
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "AuthKey.p8")
+	if err := os.WriteFile(keyFile, []byte(pk), 0600); err != nil {
+		t.Fatal(err)
+	}
+	soft := &softSigner{key: key}
+
+	providers := map[string]KeyProvider{
+		"pem":    PemKey(pk),
+		"file":   KeyFile(keyFile),
+		"reader": KeyReader(strings.NewReader(pk)),
+		"signer": StaticSigner(soft),
+	}
+	for name, provider := range providers {
+		t.Run(name, func(t *testing.T) {
+			signed, err := SignJwt(&Config{
+				Iss:      ISS,
+				Kid:      KID,
+				Bid:      BID,
+				Aud:      AUD,
+				Key:      provider,
+				ExpiryIn: time.Minute,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			verifyTestJwt(t, signed, key.Public())
+		})
+	}
+	if soft.calls != 1 {
+		t.Errorf("soft signer calls = %d, want 1", soft.calls)
+	}
+}
+
+func TestStaticSigner_NotP256(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = StaticSigner(&softSigner{key: key}).Signer()
+	if err != ErrSignerNotP256 {
+		t.Errorf("err = %v, want %v", err, ErrSignerNotP256)
+	}
+}
@@ -0,0 +1,233 @@
+package appstoreserverapi
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+var ErrStoreNotFound = errors.New("not found in store")
+
+// TransactionStore 保存解码后的交易和续订信息
+// 设置 Config.Store 后，Api 方法和 NotificationHandler 会自动写入
+// Keeps decoded transactions and renewal infos
+// With Config.Store set, the Api methods and NotificationHandler write into it
+type TransactionStore interface {
+	// PutTransactions 按 transactionId 插入或更新，已保存的交易 signedDate 更新时不会被覆盖
+	// Upserts by transactionId, a stored transaction with a newer signedDate is kept
+	PutTransactions(transactions ...JWSTransactionDecodedPayload) error
+	// PutRenewalInfos 按 originalTransactionId 插入或更新，已保存的续订信息 signedDate 更新时不会被覆盖
+	// Upserts by originalTransactionId, a stored renewal info with a newer signedDate is kept
+	PutRenewalInfos(renewals ...JWSRenewalInfoDecodedPayload) error
+	// Transaction 不存在时返回 ErrStoreNotFound
+	// Returns ErrStoreNotFound when missing
+	Transaction(transactionId string) (*JWSTransactionDecodedPayload, error)
+	// TransactionsByOriginalTransactionId 按购买时间排序
+	// Ordered by purchase date
+	TransactionsByOriginalTransactionId(originalTransactionId string) ([]JWSTransactionDecodedPayload, error)
+	// TransactionsByAppAccountToken 按购买时间排序
+	// Ordered by purchase date
+	TransactionsByAppAccountToken(appAccountToken string) ([]JWSTransactionDecodedPayload, error)
+	// RenewalInfo 不存在时返回 ErrStoreNotFound
+	// Returns ErrStoreNotFound when missing
+	RenewalInfo(originalTransactionId string) (*JWSRenewalInfoDecodedPayload, error)
+}
+
+// MemoryStore 内存中的 TransactionStore
+// An in-memory TransactionStore
+type MemoryStore struct {
+	lock         sync.RWMutex
+	transactions map[string]JWSTransactionDecodedPayload
+	byOriginal   map[string]map[string]bool
+	byToken      map[string]map[string]bool
+	renewals     map[string]JWSRenewalInfoDecodedPayload
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		transactions: make(map[string]JWSTransactionDecodedPayload),
+		byOriginal:   make(map[string]map[string]bool),
+		byToken:      make(map[string]map[string]bool),
+		renewals:     make(map[string]JWSRenewalInfoDecodedPayload),
+	}
+}
+
+func (s *MemoryStore) PutTransactions(transactions ...JWSTransactionDecodedPayload) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, tx := range transactions {
+		old, ok := s.transactions[tx.TransactionId]
+		if ok {
+			if old.SignedDate > tx.SignedDate {
+				continue
+			}
+			unindex(s.byOriginal, old.OriginalTransactionId, old.TransactionId)
+			unindex(s.byToken, old.AppAccountToken, old.TransactionId)
+		}
+		s.transactions[tx.TransactionId] = tx
+		index(s.byOriginal, tx.OriginalTransactionId, tx.TransactionId)
+		index(s.byToken, tx.AppAccountToken, tx.TransactionId)
+	}
+	return nil
+}
+
+func (s *MemoryStore) PutRenewalInfos(renewals ...JWSRenewalInfoDecodedPayload) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, renewal := range renewals {
+		if old, ok := s.renewals[renewal.OriginalTransactionId]; ok && old.SignedDate > renewal.SignedDate {
+			continue
+		}
+		s.renewals[renewal.OriginalTransactionId] = renewal
+	}
+	return nil
+}
+
+func (s *MemoryStore) Transaction(transactionId string) (*JWSTransactionDecodedPayload, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	tx, ok := s.transactions[transactionId]
+	if !ok {
+		return nil, ErrStoreNotFound
+	}
+	return &tx, nil
+}
+
+func (s *MemoryStore) TransactionsByOriginalTransactionId(originalTransactionId string) ([]JWSTransactionDecodedPayload, error) {
+	return s.lookup(s.byOriginal, originalTransactionId), nil
+}
+
+func (s *MemoryStore) TransactionsByAppAccountToken(appAccountToken string) ([]JWSTransactionDecodedPayload, error) {
+	return s.lookup(s.byToken, appAccountToken), nil
+}
+
+func (s *MemoryStore) RenewalInfo(originalTransactionId string) (*JWSRenewalInfoDecodedPayload, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	renewal, ok := s.renewals[originalTransactionId]
+	if !ok {
+		return nil, ErrStoreNotFound
+	}
+	return &renewal, nil
+}
+
+// all 保存的所有交易和续订信息
+func (s *MemoryStore) all() ([]JWSTransactionDecodedPayload, []JWSRenewalInfoDecodedPayload) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	transactions := make([]JWSTransactionDecodedPayload, 0, len(s.transactions))
+	for _, tx := range s.transactions {
+		transactions = append(transactions, tx)
+	}
+	sortTransactions(transactions)
+	renewals := make([]JWSRenewalInfoDecodedPayload, 0, len(s.renewals))
+	for _, renewal := range s.renewals {
+		renewals = append(renewals, renewal)
+	}
+	sort.Slice(renewals, func(i, j int) bool {
+		return renewals[i].OriginalTransactionId < renewals[j].OriginalTransactionId
+	})
+	return transactions, renewals
+}
+
+func (s *MemoryStore) lookup(idx map[string]map[string]bool, key string) []JWSTransactionDecodedPayload {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	result := make([]JWSTransactionDecodedPayload, 0, len(idx[key]))
+	if key == "" {
+		return result
+	}
+	for id := range idx[key] {
+		result = append(result, s.transactions[id])
+	}
+	sortTransactions(result)
+	return result
+}
+
+func index(idx map[string]map[string]bool, key, id string) {
+	if key == "" {
+		return
+	}
+	if idx[key] == nil {
+		idx[key] = make(map[string]bool)
+	}
+	idx[key][id] = true
+}
+
+func unindex(idx map[string]map[string]bool, key, id string) {
+	delete(idx[key], id)
+	if len(idx[key]) == 0 {
+		delete(idx, key)
+	}
+}
+
+func sortTransactions(transactions []JWSTransactionDecodedPayload) {
+	sort.Slice(transactions, func(i, j int) bool {
+		if transactions[i].PurchaseDate != transactions[j].PurchaseDate {
+			return transactions[i].PurchaseDate < transactions[j].PurchaseDate
+		}
+		return transactions[i].TransactionId < transactions[j].TransactionId
+	})
+}
+
+// StoreError Api 方法写入 Config.Store 失败，Apple 的结果仍然和这个错误一起返回
+// A write into Config.Store failed in an Api method, Apple's result is still returned along with this error
+type StoreError struct {
+	// 存储返回的错误
+	// The error returned by the store
+	Err error
+	// 部分模式下同一次调用中的解码错误
+	// The decode errors of the same call in partial mode
+	DecodeErrors DecodeErrors
+}
+
+func (e *StoreError) Error() string {
+	if len(e.DecodeErrors) > 0 {
+		return "store write failed: " + e.Err.Error() + "; " + e.DecodeErrors.Error()
+	}
+	return "store write failed: " + e.Err.Error()
+}
+
+// Unwrap 返回存储的错误，errors.As 取 DecodeErrors 时由 As 处理
+// Unwrap returns the store's error, As handles errors.As for DecodeErrors
+func (e *StoreError) Unwrap() error {
+	return e.Err
+}
+
+func (e *StoreError) As(target interface{}) bool {
+	if errs, ok := target.(*DecodeErrors); ok && len(e.DecodeErrors) > 0 {
+		*errs = e.DecodeErrors
+		return true
+	}
+	return false
+}
+
+// writeThrough 把解码后的数据写入 Config.Store，未设置时什么也不做
+// 返回 decodeErr，写入失败时返回包含 decodeErr 的 *StoreError，调用方仍然返回结果
+func (c *client) writeThrough(transactions []JWSTransactionDecodedPayload, renewals []JWSRenewalInfoDecodedPayload, decodeErr error) error {
+	err := storePayloads(c.cfg.Store, transactions, renewals)
+	if err == nil {
+		return decodeErr
+	}
+	c.logger.Log(LevelError, "store write failed", Field{FieldError, err.Error()})
+	storeErr := &StoreError{Err: err}
+	storeErr.DecodeErrors, _ = decodeErr.(DecodeErrors)
+	return storeErr
+}
+
+func storePayloads(store TransactionStore, transactions []JWSTransactionDecodedPayload, renewals []JWSRenewalInfoDecodedPayload) error {
+	if store == nil {
+		return nil
+	}
+	if len(transactions) > 0 {
+		if err := store.PutTransactions(transactions...); err != nil {
+			return err
+		}
+	}
+	if len(renewals) > 0 {
+		if err := store.PutRenewalInfos(renewals...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
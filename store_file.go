@@ -0,0 +1,297 @@
+package appstoreserverapi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	ErrFileStoreCorrupt  = errors.New("file store log is corrupt")
+	ErrFileStoreUnusable = errors.New("file store log is unusable")
+)
+
+// logFile 日志文件，测试时可以替换
+type logFile interface {
+	io.Writer
+	io.Seeker
+	io.Closer
+	Sync() error
+	Truncate(size int64) error
+}
+
+// fileRecord 日志中的一行
+type fileRecord struct {
+	Transaction *JWSTransactionDecodedPayload `json:"transaction,omitempty"`
+	RenewalInfo *JWSRenewalInfoDecodedPayload `json:"renewalInfo,omitempty"`
+}
+
+// FileStore 文件中的 TransactionStore：只追加的 JSONL 日志，打开时读入内存索引
+// 每次写入后调用 fsync；写入失败时截断不完整的行，写入中途崩溃留下的不完整的最后一行会在下次打开时丢弃
+// A TransactionStore in a file: an append-only JSONL log, loaded into an in-memory index on open
+// Each write is followed by fsync; a failed write truncates its partial line, one left by a crash is dropped on the next open
+type FileStore struct {
+	// 保护 file 和 mem：Compact 会替换它们
+	lock sync.RWMutex
+	path string
+	file logFile
+	// 最后一个完整的行之后的位置
+	size int64
+	mem  *MemoryStore
+	// 无法截断写入失败的行，或者 Compact 失败且无法重新打开日志时的错误，之后的写入都返回该错误，读取仍然使用内存索引
+	broken error
+}
+
+// NewFileStore 打开或创建日志文件
+// Opens or creates the log file
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *FileStore) open() error {
+	file, err := os.OpenFile(s.path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	mem := NewMemoryStore()
+	rd := bufio.NewReader(file)
+	offset := int64(0)
+	for n := 1; ; n++ {
+		line, err := rd.ReadBytes('\n')
+		if err == io.EOF {
+			// 不完整的最后一行
+			if len(line) > 0 {
+				if err := file.Truncate(offset); err != nil {
+					file.Close()
+					return err
+				}
+			}
+			break
+		}
+		if err != nil {
+			file.Close()
+			return err
+		}
+		offset += int64(len(line))
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		record := fileRecord{}
+		if err := json.Unmarshal(line, &record); err != nil {
+			file.Close()
+			return fmt.Errorf("%w: %s line %d: %v", ErrFileStoreCorrupt, s.path, n, err)
+		}
+		if record.Transaction != nil {
+			mem.PutTransactions(*record.Transaction)
+		}
+		if record.RenewalInfo != nil {
+			mem.PutRenewalInfos(*record.RenewalInfo)
+		}
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.size = offset
+	s.mem = mem
+	return nil
+}
+
+func (s *FileStore) PutTransactions(transactions ...JWSTransactionDecodedPayload) error {
+	records := make([]fileRecord, 0, len(transactions))
+	for i := range transactions {
+		records = append(records, fileRecord{Transaction: &transactions[i]})
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.append(records); err != nil {
+		return err
+	}
+	return s.mem.PutTransactions(transactions...)
+}
+
+func (s *FileStore) PutRenewalInfos(renewals ...JWSRenewalInfoDecodedPayload) error {
+	records := make([]fileRecord, 0, len(renewals))
+	for i := range renewals {
+		records = append(records, fileRecord{RenewalInfo: &renewals[i]})
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if err := s.append(records); err != nil {
+		return err
+	}
+	return s.mem.PutRenewalInfos(renewals...)
+}
+
+func (s *FileStore) Transaction(transactionId string) (*JWSTransactionDecodedPayload, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.mem.Transaction(transactionId)
+}
+
+func (s *FileStore) TransactionsByOriginalTransactionId(originalTransactionId string) ([]JWSTransactionDecodedPayload, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.mem.TransactionsByOriginalTransactionId(originalTransactionId)
+}
+
+func (s *FileStore) TransactionsByAppAccountToken(appAccountToken string) ([]JWSTransactionDecodedPayload, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.mem.TransactionsByAppAccountToken(appAccountToken)
+}
+
+func (s *FileStore) RenewalInfo(originalTransactionId string) (*JWSRenewalInfoDecodedPayload, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.mem.RenewalInfo(originalTransactionId)
+}
+
+// Compact 只保留每笔交易和续订信息的最新版本，重写日志
+// 重写失败时重新打开原来的日志；无法重新打开时之后的写入返回 ErrFileStoreUnusable，读取不受影响
+// Rewrites the log keeping only the latest version of each transaction and renewal info
+// on failure the original log is reopened; when it cannot be, later writes return ErrFileStoreUnusable while reads keep working
+func (s *FileStore) Compact() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.broken != nil {
+		return s.broken
+	}
+	transactions, renewals := s.mem.all()
+	records := make([]fileRecord, 0, len(transactions)+len(renewals))
+	for i := range transactions {
+		records = append(records, fileRecord{Transaction: &transactions[i]})
+	}
+	for i := range renewals {
+		records = append(records, fileRecord{RenewalInfo: &renewals[i]})
+	}
+	b, err := encodeRecords(records)
+	if err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := writeFileSync(tmp, b); err != nil {
+		return err
+	}
+	err = s.file.Close()
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+	if err != nil {
+		// 重写失败，重新打开原来的日志
+		os.Remove(tmp)
+		if openErr := s.open(); openErr != nil {
+			s.fail(openErr)
+		}
+		return err
+	}
+	// 目录 fsync 后重命名才能在崩溃后保留
+	syncErr := syncDir(filepath.Dir(s.path))
+	if err := s.open(); err != nil {
+		s.fail(err)
+		return err
+	}
+	return syncErr
+}
+
+// fail 日志无法使用，之后的写入都返回错误
+func (s *FileStore) fail(err error) {
+	s.file = nil
+	s.broken = fmt.Errorf("%w: %s: %v", ErrFileStoreUnusable, s.path, err)
+}
+
+// Close 关闭日志文件
+// Closes the log file
+func (s *FileStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	return s.file.Close()
+}
+
+func (s *FileStore) append(records []fileRecord) error {
+	if s.broken != nil {
+		return s.broken
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	b, err := encodeRecords(records)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(b); err != nil {
+		s.rollback()
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		s.rollback()
+		return err
+	}
+	s.size += int64(len(b))
+	return nil
+}
+
+// rollback 写入失败，截断到最后一个完整的行，之后的写入接在它后面；无法截断时之后的写入都返回错误
+func (s *FileStore) rollback() {
+	err := s.file.Truncate(s.size)
+	if err == nil {
+		_, err = s.file.Seek(s.size, io.SeekStart)
+	}
+	if err != nil {
+		s.file.Close()
+		s.fail(err)
+	}
+}
+
+func encodeRecords(records []fileRecord) ([]byte, error) {
+	buf := bytes.Buffer{}
+	enc := json.NewEncoder(&buf)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+func writeFileSync(path string, b []byte) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(b); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
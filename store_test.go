@@ -0,0 +1,391 @@
+package appstoreserverapi
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lhlyu/appstoreserverapi/cassette"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func testStore(t *testing.T, s TransactionStore) {
+	err := s.PutTransactions(
+		JWSTransactionDecodedPayload{TransactionId: "2", OriginalTransactionId: "1", AppAccountToken: "token", PurchaseDate: 2, SignedDate: 2},
+		JWSTransactionDecodedPayload{TransactionId: "1", OriginalTransactionId: "1", AppAccountToken: "token", PurchaseDate: 1, SignedDate: 1},
+		JWSTransactionDecodedPayload{TransactionId: "3", OriginalTransactionId: "3", PurchaseDate: 3, SignedDate: 3},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 旧的快照不覆盖新的
+	err = s.PutTransactions(
+		JWSTransactionDecodedPayload{TransactionId: "2", OriginalTransactionId: "1", AppAccountToken: "token", PurchaseDate: 2, SignedDate: 1},
+		JWSTransactionDecodedPayload{TransactionId: "1", OriginalTransactionId: "1", AppAccountToken: "token", PurchaseDate: 1, SignedDate: 5, RevocationDate: 5},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutRenewalInfos(JWSRenewalInfoDecodedPayload{OriginalTransactionId: "1", AutoRenewStatus: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	txs, err := s.TransactionsByOriginalTransactionId("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txs) != 2 || txs[0].TransactionId != "1" || txs[1].TransactionId != "2" {
+		t.Fatalf("by original = %+v", txs)
+	}
+	if txs[0].RevocationDate != 5 || txs[1].SignedDate != 2 {
+		t.Errorf("upsert kept the wrong versions: %+v", txs)
+	}
+	txs, err = s.TransactionsByAppAccountToken("token")
+	if err != nil || len(txs) != 2 {
+		t.Errorf("by token = %+v, err = %v", txs, err)
+	}
+	if tx, err := s.Transaction("3"); err != nil || tx.OriginalTransactionId != "3" {
+		t.Errorf("transaction = %+v, err = %v", tx, err)
+	}
+	if _, err := s.Transaction("4"); err != ErrStoreNotFound {
+		t.Errorf("err = %v, want %v", err, ErrStoreNotFound)
+	}
+	if renewal, err := s.RenewalInfo("1"); err != nil || renewal.AutoRenewStatus != 1 {
+		t.Errorf("renewal = %+v, err = %v", renewal, err)
+	}
+	if _, err := s.RenewalInfo("3"); err != ErrStoreNotFound {
+		t.Errorf("err = %v, want %v", err, ErrStoreNotFound)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, s)
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟写入中途崩溃留下的不完整的一行
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"transaction":{"transactionId":"9"`)
+	f.Close()
+
+	s, err = NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if tx, err := s.Transaction("1"); err != nil || tx.RevocationDate != 5 {
+		t.Errorf("reopened transaction = %+v, err = %v", tx, err)
+	}
+	if _, err := s.Transaction("9"); err != ErrStoreNotFound {
+		t.Errorf("partial line: err = %v, want %v", err, ErrStoreNotFound)
+	}
+
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(path)
+	if lines := strings.Count(string(b), "\n"); lines != 4 {
+		t.Errorf("compacted log has %d lines, want 4", lines)
+	}
+	if err := s.PutTransactions(JWSTransactionDecodedPayload{TransactionId: "4", OriginalTransactionId: "3"}); err != nil {
+		t.Fatal(err)
+	}
+	if txs, _ := s.TransactionsByOriginalTransactionId("3"); len(txs) != 2 {
+		t.Errorf("by original after compact = %+v", txs)
+	}
+}
+
+func TestFileStore_CompactFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.PutTransactions(JWSTransactionDecodedPayload{TransactionId: "1", OriginalTransactionId: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	// 日志被替换成非空目录：重命名失败，也无法重新打开
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(path, "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Compact(); err == nil {
+		t.Fatal("compact should fail")
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary file was left behind: %v", err)
+	}
+	if err := s.PutTransactions(JWSTransactionDecodedPayload{TransactionId: "2", OriginalTransactionId: "1"}); !errors.Is(err, ErrFileStoreUnusable) {
+		t.Errorf("write after failed compact: err = %v, want %v", err, ErrFileStoreUnusable)
+	}
+	if err := s.Compact(); !errors.Is(err, ErrFileStoreUnusable) {
+		t.Errorf("compact again: err = %v, want %v", err, ErrFileStoreUnusable)
+	}
+	if tx, err := s.Transaction("1"); err != nil || tx.TransactionId != "1" {
+		t.Errorf("read after failed compact = %+v, err = %v", tx, err)
+	}
+}
+
+func TestFileStore_CompactConcurrentReads(t *testing.T) {
+	s, err := NewFileStore(filepath.Join(t.TempDir(), "ledger.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.PutTransactions(JWSTransactionDecodedPayload{TransactionId: "1", OriginalTransactionId: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Compact 替换内存索引时，读取仍然可以得到数据
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 50; i++ {
+			if err := s.Compact(); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if tx, err := s.Transaction("1"); err != nil || tx.TransactionId != "1" {
+			t.Fatalf("read during compact = %+v, err = %v", tx, err)
+		}
+	}
+}
+
+// tornFile 只写入一半后失败，truncateErr 不为空时截断也失败
+type tornFile struct {
+	logFile
+	truncateErr error
+}
+
+var errTornWrite = errors.New("torn write")
+
+func (f tornFile) Write(b []byte) (int, error) {
+	n, _ := f.logFile.Write(b[:len(b)/2])
+	return n, errTornWrite
+}
+
+func (f tornFile) Truncate(size int64) error {
+	if f.truncateErr != nil {
+		return f.truncateErr
+	}
+	return f.logFile.Truncate(size)
+}
+
+func TestFileStore_WriteFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ledger.jsonl")
+	s, err := NewFileStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.PutTransactions(JWSTransactionDecodedPayload{TransactionId: "1", OriginalTransactionId: "1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 写入失败的行被截断，之后的写入仍然可以读取
+	file := s.file
+	s.file = tornFile{logFile: file}
+	if err := s.PutTransactions(JWSTransactionDecodedPayload{TransactionId: "2", OriginalTransactionId: "1"}); err != errTornWrite {
+		t.Fatalf("err = %v, want %v", err, errTornWrite)
+	}
+	s.file = file
+	if err := s.PutTransactions(JWSTransactionDecodedPayload{TransactionId: "3", OriginalTransactionId: "1"}); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("reopen after a failed write: %v", err)
+	}
+	txs, _ := reopened.TransactionsByOriginalTransactionId("1")
+	reopened.Close()
+	if len(txs) != 2 {
+		t.Errorf("reopened transactions = %+v, want 1 and 3", txs)
+	}
+
+	// 无法截断时之后的写入都返回错误
+	s.file = tornFile{logFile: file, truncateErr: errors.New("truncate failed")}
+	if err := s.PutTransactions(JWSTransactionDecodedPayload{TransactionId: "4", OriginalTransactionId: "1"}); err != errTornWrite {
+		t.Fatalf("err = %v, want %v", err, errTornWrite)
+	}
+	if err := s.PutTransactions(JWSTransactionDecodedPayload{TransactionId: "5", OriginalTransactionId: "1"}); !errors.Is(err, ErrFileStoreUnusable) {
+		t.Errorf("write after failed truncate: err = %v, want %v", err, ErrFileStoreUnusable)
+	}
+	if tx, err := s.Transaction("3"); err != nil || tx.TransactionId != "3" {
+		t.Errorf("read after failed truncate = %+v, err = %v", tx, err)
+	}
+}
+
+// failingStore 写入总是失败
+type failingStore struct {
+	*MemoryStore
+}
+
+var errStoreDown = errors.New("store down")
+
+func (s failingStore) PutTransactions(transactions ...JWSTransactionDecodedPayload) error {
+	return errStoreDown
+}
+
+func TestClient_WriteThroughError(t *testing.T) {
+	rec, err := cassette.New(filepath.Join("testdata", "cassettes", "TestClient_GetTransactionHistory.json"), cassette.ModeReplay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pk := newTestKey(t)
+	c, err := NewClient(&Config{
+		Iss:        ISS,
+		Kid:        KID,
+		Bid:        BID,
+		Pk:         pk,
+		Evn:        Sandbox,
+		HttpClient: &http.Client{Transport: rec},
+		Store:      failingStore{NewMemoryStore()},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 写入失败时仍然返回 Apple 的结果
+	r, err := c.ApiGetTransactionHistory("52000104826360", false)
+	storeErr := &StoreError{}
+	if !errors.As(err, &storeErr) || !errors.Is(err, errStoreDown) {
+		t.Fatalf("err = %v, want a *StoreError wrapping %v", err, errStoreDown)
+	}
+	if r == nil || len(r.SignedTransactions) == 0 {
+		t.Errorf("result = %+v, want Apple's result", r)
+	}
+}
+
+func TestStoreError_DecodeErrors(t *testing.T) {
+	decodeErrs := DecodeErrors{{Path: "signedTransactions.1", Index: 1, Err: errors.New("bad payload")}}
+	var err error = &StoreError{Err: errStoreDown, DecodeErrors: decodeErrs}
+	var errs DecodeErrors
+	if !errors.As(err, &errs) || len(errs) != 1 || !errors.Is(err, errStoreDown) {
+		t.Errorf("err = %v, want both the store error and the decode errors", err)
+	}
+	if errors.As(&StoreError{Err: errStoreDown}, &errs) {
+		t.Error("a StoreError without decode errors should not match DecodeErrors")
+	}
+}
+
+func TestClient_WriteThrough(t *testing.T) {
+	rec, err := cassette.New(filepath.Join("testdata", "cassettes", "TestClient_GetTransactionHistory.json"), cassette.ModeReplay, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, pk := newTestKey(t)
+	store := NewMemoryStore()
+	c, err := NewClient(&Config{
+		Iss:        ISS,
+		Kid:        KID,
+		Bid:        BID,
+		Pk:         pk,
+		Evn:        Sandbox,
+		HttpClient: &http.Client{Transport: rec},
+		Store:      store,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := c.ApiGetTransactionHistory("52000104826360", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	txs, _ := store.TransactionsByOriginalTransactionId("52000104826360")
+	if len(txs) == 0 || len(txs) != len(r.SignedTransactions) {
+		t.Errorf("stored %d transactions, want %d", len(txs), len(r.SignedTransactions))
+	}
+}
+
+func TestNotificationHandler(t *testing.T) {
+	key, _ := newTestKey(t)
+	sign := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		signed, err := jws.Sign(b, jws.WithKey(jwa.ES256, key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(signed)
+	}
+	payload := sign(map[string]interface{}{
+		"notificationType": NotificationTypeDidRenew,
+		"notificationUUID": "uuid",
+		"version":          "2.0",
+		"signedDate":       1,
+		"data": map[string]interface{}{
+			"bundleId":              BID,
+			"environment":           "Sandbox",
+			"signedTransactionInfo": sign(JWSTransactionDecodedPayload{TransactionId: "2", OriginalTransactionId: "1"}),
+			"signedRenewalInfo":     sign(JWSRenewalInfoDecodedPayload{OriginalTransactionId: "1", AutoRenewStatus: 1}),
+		},
+	})
+
+	store := NewMemoryStore()
+	handled := 0
+	h := &NotificationHandler{
+		InsecureSkipVerify: true,
+		Store:              store,
+		Handle: func(n *ResponseBodyV2DecodedPayload) error {
+			handled++
+			return nil
+		},
+	}
+	body, _ := json.Marshal(map[string]string{"signedPayload": payload})
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/notifications", strings.NewReader(string(body))))
+	if w.Code != http.StatusOK || handled != 1 {
+		t.Fatalf("status = %d, handled = %d", w.Code, handled)
+	}
+	if _, err := store.Transaction("2"); err != nil {
+		t.Error(err)
+	}
+	if _, err := store.RenewalInfo("1"); err != nil {
+		t.Error(err)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/notifications", strings.NewReader(`{}`)))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("empty body: status = %d, want 400", w.Code)
+	}
+
+	// 没有校验器时不接受通知
+	store = NewMemoryStore()
+	h = &NotificationHandler{Store: store}
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/notifications", strings.NewReader(string(body))))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("without verifier: status = %d, want 500", w.Code)
+	}
+	if _, err := store.Transaction("2"); err != ErrStoreNotFound {
+		t.Errorf("without verifier: err = %v, want %v", err, ErrStoreNotFound)
+	}
+}
@@ -0,0 +1,139 @@
+package storetest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/cert"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lhlyu/appstoreserverapi"
+	"math/big"
+	"time"
+)
+
+var (
+	// 和 Apple 证书相同的扩展 OID，Verifier 会检查
+	// The same extension OIDs as the Apple certificates, checked by the Verifier
+	oidAppleLeaf         = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 11, 1}
+	oidAppleIntermediate = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 2, 1}
+)
+
+// CertificateChain 本地生成的根证书、中间证书、签名证书，结构和 Apple 的证书链一致
+// A locally generated root, intermediate and leaf certificate, shaped like the Apple chain
+type CertificateChain struct {
+	// RootDER 根证书，传给 appstoreserverapi.NewVerifier
+	// RootDER the root certificate, pass it to appstoreserverapi.NewVerifier
+	RootDER         []byte
+	IntermediateDER []byte
+	LeafDER         []byte
+
+	leafKey *ecdsa.PrivateKey
+}
+
+// 证书的有效期覆盖测试中可能使用的任何时钟，例如 SetClock 设置的历史时间
+var (
+	notBefore = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	notAfter  = time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC)
+)
+
+// NewCertificateChain 生成新的证书链
+// Generates a new chain
+func NewCertificateChain() (*CertificateChain, error) {
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	root := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "storetest Root CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, root, root, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		return nil, err
+	}
+	root, _ = x509.ParseCertificate(rootDER)
+
+	intermediateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	intermediate := &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "storetest Intermediate CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		ExtraExtensions:       []pkix.Extension{{Id: oidAppleIntermediate, Value: []byte{0x05, 0x00}}},
+	}
+	intermediateDER, err := x509.CreateCertificate(rand.Reader, intermediate, root, &intermediateKey.PublicKey, rootKey)
+	if err != nil {
+		return nil, err
+	}
+	intermediate, _ = x509.ParseCertificate(intermediateDER)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	leaf := &x509.Certificate{
+		SerialNumber:    big.NewInt(3),
+		Subject:         pkix.Name{CommonName: "storetest Signing"},
+		NotBefore:       notBefore,
+		NotAfter:        notAfter,
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtraExtensions: []pkix.Extension{{Id: oidAppleLeaf, Value: []byte{0x05, 0x00}}},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leaf, intermediate, &leafKey.PublicKey, intermediateKey)
+	if err != nil {
+		return nil, err
+	}
+	return &CertificateChain{
+		RootDER:         rootDER,
+		IntermediateDER: intermediateDER,
+		LeafDER:         leafDER,
+		leafKey:         leafKey,
+	}, nil
+}
+
+// Verifier 只信任这条证书链根证书的校验器
+// A verifier that trusts only the root of this chain
+func (c *CertificateChain) Verifier() *appstoreserverapi.Verifier {
+	v, _ := appstoreserverapi.NewVerifier(c.RootDER)
+	return v
+}
+
+// Sign 把 v 编码为 JSON 后签名，x5c 头包含整条证书链
+// Signs v as JSON, the x5c header carries the whole chain
+func (c *CertificateChain) Sign(v interface{}) (string, error) {
+	if c.leafKey == nil {
+		return "", fmt.Errorf("certificate chain has no leaf key")
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	chain := &cert.Chain{}
+	for _, der := range [][]byte{c.LeafDER, c.IntermediateDER, c.RootDER} {
+		if err := chain.AddString(base64.StdEncoding.EncodeToString(der)); err != nil {
+			return "", err
+		}
+	}
+	headers := jws.NewHeaders()
+	headers.Set(jws.X509CertChainKey, chain)
+	signed, err := jws.Sign(payload, jws.WithKey(jwa.ES256, c.leafKey, jws.WithProtectedHeaders(headers)))
+	return string(signed), err
+}
@@ -0,0 +1,41 @@
+package storetest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock 手动推进的时钟，实现 appstoreserverapi.Clock，测试 token 过期和订阅到期时不需要等待
+// A manually advanced clock implementing appstoreserverapi.Clock, tests of token and subscription expiry need not sleep
+type Clock struct {
+	lock sync.Mutex
+	now  time.Time
+}
+
+// NewClock 从 now 开始的时钟
+// A clock starting at now
+func NewClock(now time.Time) *Clock {
+	return &Clock{now: now}
+}
+
+func (c *Clock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.now
+}
+
+// Advance 时钟前进 d
+// Moves the clock forward by d
+func (c *Clock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set 设置时钟
+// Sets the clock
+func (c *Clock) Set(now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now = now
+}
@@ -0,0 +1,30 @@
+package storetest
+
+import (
+	"github.com/lhlyu/appstoreserverapi"
+	"testing"
+	"time"
+)
+
+func TestClock_Verifier(t *testing.T) {
+	f, err := NewFixtures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := f.SignTransaction(appstoreserverapi.JWSTransactionDecodedPayload{TransactionId: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := NewClock(time.Now())
+	verifier := f.Verifier().WithClock(clock)
+	if err := verifier.Verify(signed, nil); err != nil {
+		t.Fatal(err)
+	}
+	// 证书链在有效期之外不被接受
+	for _, at := range []time.Time{notBefore.Add(-time.Hour), notAfter.Add(time.Hour)} {
+		clock.Set(at)
+		if err := verifier.Verify(signed, nil); err != appstoreserverapi.ErrVerifyInvalidChain {
+			t.Errorf("at %s: err = %v, want %v", at, err, appstoreserverapi.ErrVerifyInvalidChain)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package storetest
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/lhlyu/appstoreserverapi"
+	"time"
+)
+
+// Fixtures 用本地证书链签名交易、续订信息和通知
+// 签名结果可以用 Verifier 校验，和 Apple 返回的数据格式一致
+// Signs transactions, renewal infos and notifications with a local chain
+// The results verify with Verifier and look like what Apple returns
+type Fixtures struct {
+	Chain *CertificateChain
+}
+
+// NewFixtures 使用新生成的证书链
+// Uses a freshly generated chain
+func NewFixtures() (*Fixtures, error) {
+	chain, err := NewCertificateChain()
+	if err != nil {
+		return nil, err
+	}
+	return &Fixtures{Chain: chain}, nil
+}
+
+// Fixtures 和模拟服务使用同一条证书链
+// Fixtures sharing the chain of the fake server
+func (s *Server) Fixtures() *Fixtures {
+	return &Fixtures{Chain: s.Chain}
+}
+
+// Verifier 信任该证书链的校验器
+// A verifier trusting the chain
+func (f *Fixtures) Verifier() *appstoreserverapi.Verifier {
+	return f.Chain.Verifier()
+}
+
+// SignTransaction 签名交易
+// Signs a transaction
+func (f *Fixtures) SignTransaction(tx appstoreserverapi.JWSTransactionDecodedPayload) (string, error) {
+	return f.Chain.Sign(tx)
+}
+
+// SignRenewalInfo 签名续订信息
+// Signs a renewal info
+func (f *Fixtures) SignRenewalInfo(renewal appstoreserverapi.JWSRenewalInfoDecodedPayload) (string, error) {
+	return f.Chain.Sign(renewal)
+}
+
+// SignNotification 签名 V2 通知，data 中的交易和续订信息会先单独签名
+// 交易或续订信息的 originalTransactionId 为空时不会包含在通知中
+// Signs a V2 notification, the transaction and renewal info in data are signed first
+// a transaction or renewal info without originalTransactionId is left out
+func (f *Fixtures) SignNotification(n appstoreserverapi.ResponseBodyV2DecodedPayload) (string, error) {
+	data := map[string]interface{}{
+		"bundleId":    n.Data.BundleId,
+		"environment": n.Data.Environment,
+	}
+	if n.Data.AppAppleId != 0 {
+		data["appAppleId"] = n.Data.AppAppleId
+	}
+	if n.Data.BundleVersion != "" {
+		data["bundleVersion"] = n.Data.BundleVersion
+	}
+	if n.Data.Status != 0 {
+		data["status"] = n.Data.Status
+	}
+	if n.Data.SignedTransactionInfo.OriginalTransactionId != "" {
+		signed, err := f.SignTransaction(n.Data.SignedTransactionInfo)
+		if err != nil {
+			return "", err
+		}
+		data["signedTransactionInfo"] = signed
+	}
+	if n.Data.SignedRenewalInfo.OriginalTransactionId != "" {
+		signed, err := f.SignRenewalInfo(n.Data.SignedRenewalInfo)
+		if err != nil {
+			return "", err
+		}
+		data["signedRenewalInfo"] = signed
+	}
+	payload := map[string]interface{}{
+		"notificationType": n.NotificationType,
+		"notificationUUID": n.NotificationUUID,
+		"version":          n.Version,
+		"signedDate":       n.SignedDate,
+	}
+	if n.Subtype != "" {
+		payload["subtype"] = n.Subtype
+	}
+	if n.Summary != nil {
+		payload["summary"] = n.Summary
+	} else {
+		payload["data"] = data
+	}
+	return f.Chain.Sign(payload)
+}
+
+// millis 毫秒时间戳，和 Apple 的日期格式一致
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// newUUID 随机的 UUID v4
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,172 @@
+package storetest
+
+import (
+	"fmt"
+	"github.com/lhlyu/appstoreserverapi"
+	"time"
+)
+
+const (
+	typeAutoRenewable = "Auto-Renewable Subscription"
+	notificationV2    = "2.0"
+)
+
+// Scenario 一个订阅的经历：所有交易、当前的续订信息，以及期间 Apple 会发送的通知
+// 例如：每月订阅，续订 3 次后退款
+//
+//	MonthlySubscription("1000", "monthly", start).Renew(3).Refund(at)
+//
+// The history of one subscription: every transaction, the current renewal info and the notifications Apple would send
+type Scenario struct {
+	Transactions  []appstoreserverapi.JWSTransactionDecodedPayload
+	Renewal       appstoreserverapi.JWSRenewalInfoDecodedPayload
+	Notifications []appstoreserverapi.ResponseBodyV2DecodedPayload
+
+	next func(time.Time) time.Time
+}
+
+// Subscription 在 start 首次购买的自动续期订阅，next 计算一个周期的结束时间
+// 续订交易的 transactionId 为 originalTransactionId 加上三位序号
+// An auto-renewable subscription first bought at start, next returns the end of a period
+// renewal transactions are numbered originalTransactionId followed by a three digit sequence
+func Subscription(originalTransactionId, productId string, start time.Time, next func(time.Time) time.Time) *Scenario {
+	s := &Scenario{
+		Renewal: appstoreserverapi.JWSRenewalInfoDecodedPayload{
+			AutoRenewProductId:    productId,
+			AutoRenewStatus:       1,
+			Environment:           Environment,
+			OriginalTransactionId: originalTransactionId,
+			ProductId:             productId,
+		},
+		next: next,
+	}
+	s.Transactions = append(s.Transactions, appstoreserverapi.JWSTransactionDecodedPayload{
+		BundleId:                    Bid,
+		Environment:                 Environment,
+		ExpiresDate:                 millis(next(start)),
+		InAppOwnershipType:          "PURCHASED",
+		OriginalPurchaseDate:        millis(start),
+		OriginalTransactionId:       originalTransactionId,
+		ProductId:                   productId,
+		PurchaseDate:                millis(start),
+		Quantity:                    1,
+		SignedDate:                  millis(start),
+		SubscriptionGroupIdentifier: "group." + productId,
+		TransactionId:               originalTransactionId,
+		Type:                        typeAutoRenewable,
+		WebOrderLineItemId:          originalTransactionId,
+	})
+	s.notify(appstoreserverapi.NotificationTypeSubscribed, appstoreserverapi.SubtypeInitialBuy, start)
+	return s
+}
+
+// MonthlySubscription 每月续订的订阅
+// A subscription renewing every month
+func MonthlySubscription(originalTransactionId, productId string, start time.Time) *Scenario {
+	return Subscription(originalTransactionId, productId, start, func(t time.Time) time.Time {
+		return t.AddDate(0, 1, 0)
+	})
+}
+
+// Latest 最新的交易
+// The latest transaction
+func (s *Scenario) Latest() appstoreserverapi.JWSTransactionDecodedPayload {
+	return s.Transactions[len(s.Transactions)-1]
+}
+
+// Renew 在每个周期结束时续订，共 times 次
+// Renews at the end of each period, times times
+func (s *Scenario) Renew(times int) *Scenario {
+	for i := 0; i < times; i++ {
+		latest := s.Latest()
+		at := time.Unix(0, latest.ExpiresDate*int64(time.Millisecond))
+		tx := latest
+		tx.TransactionId = fmt.Sprintf("%s%03d", tx.OriginalTransactionId, len(s.Transactions))
+		tx.WebOrderLineItemId = tx.TransactionId
+		tx.PurchaseDate = millis(at)
+		tx.ExpiresDate = millis(s.next(at))
+		tx.SignedDate = millis(at)
+		s.Transactions = append(s.Transactions, tx)
+		s.Renewal.IsInBillingRetryPeriod = false
+		s.Renewal.GracePeriodExpiresDate = 0
+		s.Renewal.ExpirationIntent = 0
+		s.notify(appstoreserverapi.NotificationTypeDidRenew, "", at)
+	}
+	return s
+}
+
+// Refund 在 at 退款最新的交易
+// Refunds the latest transaction at at
+func (s *Scenario) Refund(at time.Time) *Scenario {
+	latest := &s.Transactions[len(s.Transactions)-1]
+	latest.RevocationDate = millis(at)
+	latest.SignedDate = millis(at)
+	s.notify(appstoreserverapi.NotificationTypeRefund, "", at)
+	return s
+}
+
+// Cancel 在 at 关闭自动续订，并在当前周期结束时过期
+// Turns auto-renew off at at and expires at the end of the current period
+func (s *Scenario) Cancel(at time.Time) *Scenario {
+	s.Renewal.AutoRenewStatus = 0
+	s.notify(appstoreserverapi.NotificationTypeDidChangeRenewalStatus, appstoreserverapi.SubtypeAutoRenewDisabled, at)
+	s.Renewal.ExpirationIntent = 1
+	expires := time.Unix(0, s.Latest().ExpiresDate*int64(time.Millisecond))
+	s.notify(appstoreserverapi.NotificationTypeExpired, appstoreserverapi.SubtypeVoluntary, expires)
+	return s
+}
+
+// FailToRenew 当前周期结束时扣款失败，进入账单重试，grace 大于 0 时进入宽限期
+// Billing fails at the end of the current period and retry starts, with a grace period when grace is positive
+func (s *Scenario) FailToRenew(grace time.Duration) *Scenario {
+	at := time.Unix(0, s.Latest().ExpiresDate*int64(time.Millisecond))
+	s.Renewal.IsInBillingRetryPeriod = true
+	s.Renewal.ExpirationIntent = 2
+	subtype := ""
+	if grace > 0 {
+		s.Renewal.GracePeriodExpiresDate = millis(at.Add(grace))
+		subtype = appstoreserverapi.SubtypeGracePeriod
+	}
+	s.notify(appstoreserverapi.NotificationTypeDidFailToRenew, subtype, at)
+	return s
+}
+
+// Sign 按顺序签名所有通知
+// Signs every notification in order
+func (s *Scenario) Sign(f *Fixtures) ([]string, error) {
+	signed := make([]string, 0, len(s.Notifications))
+	for _, n := range s.Notifications {
+		payload, err := f.SignNotification(n)
+		if err != nil {
+			return nil, err
+		}
+		signed = append(signed, payload)
+	}
+	return signed, nil
+}
+
+// notify 记录通知，包含此刻的最新交易和续订信息
+func (s *Scenario) notify(notificationType, subtype string, at time.Time) {
+	renewal := s.Renewal
+	renewal.SignedDate = millis(at)
+	s.Notifications = append(s.Notifications, appstoreserverapi.ResponseBodyV2DecodedPayload{
+		NotificationType: notificationType,
+		Subtype:          subtype,
+		NotificationUUID: newUUID(),
+		Data: appstoreserverapi.NotificationData{
+			BundleId:              Bid,
+			Environment:           Environment,
+			SignedTransactionInfo: s.Latest(),
+			SignedRenewalInfo:     renewal,
+		},
+		Version:    notificationV2,
+		SignedDate: millis(at),
+	})
+}
+
+// AddScenario 添加场景中的交易和续订信息
+// Adds the transactions and renewal info of a scenario
+func (s *Server) AddScenario(sc *Scenario) {
+	s.AddTransaction(sc.Transactions...)
+	s.SetRenewalInfo(sc.Renewal)
+}
@@ -0,0 +1,73 @@
+package storetest
+
+import (
+	"github.com/lhlyu/appstoreserverapi"
+	"testing"
+	"time"
+)
+
+func TestScenario_RenewedThenRefunded(t *testing.T) {
+	start := time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)
+	sc := MonthlySubscription("1000", "monthly", start).Renew(3).Refund(start.AddDate(0, 3, 2))
+
+	if len(sc.Transactions) != 4 {
+		t.Fatalf("transactions = %d, want 4", len(sc.Transactions))
+	}
+	latest := sc.Latest()
+	if latest.TransactionId != "1000003" || latest.ExpiresDate != millis(start.AddDate(0, 4, 0)) || latest.RevocationDate == 0 {
+		t.Errorf("latest = %+v", latest)
+	}
+
+	f, err := NewFixtures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := sc.Sign(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		appstoreserverapi.NotificationTypeSubscribed,
+		appstoreserverapi.NotificationTypeDidRenew,
+		appstoreserverapi.NotificationTypeDidRenew,
+		appstoreserverapi.NotificationTypeDidRenew,
+		appstoreserverapi.NotificationTypeRefund,
+	}
+	if len(signed) != len(want) {
+		t.Fatalf("notifications = %d, want %d", len(signed), len(want))
+	}
+	for i, payload := range signed {
+		n, err := appstoreserverapi.DecodeNotification(payload, f.Verifier())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n.NotificationType != want[i] {
+			t.Errorf("notification %d = %s, want %s", i, n.NotificationType, want[i])
+		}
+		if n.Data.SignedTransactionInfo.OriginalTransactionId != "1000" || n.Data.SignedRenewalInfo.OriginalTransactionId != "1000" {
+			t.Errorf("notification %d data = %+v", i, n.Data)
+		}
+	}
+
+	other, err := NewFixtures()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := appstoreserverapi.DecodeNotification(signed[0], other.Verifier()); err == nil {
+		t.Error("a verifier trusting another chain should reject the fixture")
+	}
+}
+
+func TestScenario_FailToRenew(t *testing.T) {
+	s, c := newTestServer(t)
+	start := time.Now().AddDate(0, -1, -1)
+	s.AddScenario(MonthlySubscription("3000", "monthly", start).FailToRenew(16 * 24 * time.Hour))
+
+	status, err := c.ApiGetAllSubscriptionStatuses("3000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := status.Data[0].LastTransactions[0].Status; got != 4 {
+		t.Errorf("status = %d, want 4", got)
+	}
+}
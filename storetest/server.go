@@ -0,0 +1,655 @@
+// Package storetest 进程内的 App Store Server API 模拟服务，用于离线测试
+// Package storetest is an in-process fake App Store Server API for offline tests
+package storetest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"github.com/lhlyu/appstoreserverapi"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 接口名称，用于注入错误和统计调用次数
+// Endpoint names, used to inject errors and count calls
+const (
+	EndpointGetAllSubscriptionStatuses     = "GetAllSubscriptionStatuses"
+	EndpointLookUpOrderId                  = "LookUpOrderId"
+	EndpointGetTransactionHistory          = "GetTransactionHistory"
+	EndpointGetRefundHistory               = "GetRefundHistory"
+	EndpointGetRefundHistoryV1             = "GetRefundHistoryV1"
+	EndpointExtendASubscriptionRenewalDate = "ExtendASubscriptionRenewalDate"
+	EndpointSendConsumptionInformation     = "SendConsumptionInformation"
+)
+
+const (
+	// Iss 模拟服务使用的发行人
+	Iss = "7e1f0a3c-5b2d-4c6e-8f90-123456789abc"
+	// Kid 模拟服务默认的秘钥ID
+	Kid = "STORETEST1"
+	// Bid 模拟服务默认的应用
+	Bid = "com.example.storetest"
+	// Environment 响应和签名数据中的环境
+	Environment = "LocalTesting"
+
+	// 交易记录每页的数量
+	historyPageSize = 20
+	// 超出限流时的错误码
+	rateLimitExceededErrorCode = 4290000
+)
+
+type fault struct {
+	endpoint  string
+	status    int
+	errorCode int
+	remaining int
+}
+
+// Server 模拟的 App Store Server API
+// 交易按 originalTransactionId 分组，同一个 originalTransactionId 的交易视为同一个客户
+// The fake App Store Server API
+// Transactions are grouped by originalTransactionId, the transactions of one originalTransactionId belong to one customer
+type Server struct {
+	*httptest.Server
+	Chain *CertificateChain
+
+	lock         sync.Mutex
+	pk           string
+	keys         map[string]*ecdsa.PublicKey
+	bundleIds    map[string]bool
+	transactions []appstoreserverapi.JWSTransactionDecodedPayload
+	renewals     map[string]appstoreserverapi.JWSRenewalInfoDecodedPayload
+	orders       map[string][]string
+	consumptions map[string][]appstoreserverapi.ConsumptionRequest
+	faults       []*fault
+	latency      time.Duration
+	calls        map[string]int
+	clock        appstoreserverapi.Clock
+}
+
+// NewServer 启动模拟服务，使用完后调用 Close
+// Starts the fake server, call Close when done
+func NewServer() (*Server, error) {
+	chain, err := NewCertificateChain()
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		Chain:        chain,
+		keys:         make(map[string]*ecdsa.PublicKey),
+		bundleIds:    map[string]bool{Bid: true},
+		renewals:     make(map[string]appstoreserverapi.JWSRenewalInfoDecodedPayload),
+		orders:       make(map[string][]string),
+		consumptions: make(map[string][]appstoreserverapi.ConsumptionRequest),
+		calls:        make(map[string]int),
+		clock:        appstoreserverapi.SystemClock,
+	}
+	s.pk, err = s.AddKey(Kid)
+	if err != nil {
+		return nil, err
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s, nil
+}
+
+// Config 指向模拟服务的客户端配置，签名数据使用模拟服务的证书链校验，时钟和模拟服务相同
+// A client config pointing at the fake server, signed data is verified with the fake chain, it shares the server's clock
+func (s *Server) Config() *appstoreserverapi.Config {
+	s.lock.Lock()
+	clock := s.clock
+	s.lock.Unlock()
+	return &appstoreserverapi.Config{
+		Iss:      Iss,
+		Kid:      Kid,
+		Bid:      Bid,
+		Pk:       s.pk,
+		Evn:      appstoreserverapi.LocalTesting,
+		BaseUrls: map[appstoreserverapi.Env]string{appstoreserverapi.LocalTesting: s.URL},
+		Verifier: s.Chain.Verifier(),
+		Clock:    clock,
+	}
+}
+
+// AddKey 生成新的 API 秘钥，返回 PKCS#8 PEM 格式的私钥
+// Generates a new API key and returns its PKCS#8 PEM private key
+func (s *Server) AddKey(kid string) (string, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	b, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	s.lock.Lock()
+	s.keys[kid] = &key.PublicKey
+	s.lock.Unlock()
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: b})), nil
+}
+
+// RevokeKey 吊销秘钥，之后使用该秘钥的请求返回 401
+// Revokes a key, requests signed with it get a 401
+func (s *Server) RevokeKey(kid string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.keys, kid)
+}
+
+// AddBundleId 允许 JWT 中使用其它的 bid
+// Accepts another bid in the JWT
+func (s *Server) AddBundleId(bundleId string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.bundleIds[bundleId] = true
+}
+
+// AddTransaction 添加交易，transactionId 相同时覆盖
+// Adds transactions, replacing those with the same transactionId
+func (s *Server) AddTransaction(transactions ...appstoreserverapi.JWSTransactionDecodedPayload) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, tx := range transactions {
+		replaced := false
+		for i := range s.transactions {
+			if s.transactions[i].TransactionId == tx.TransactionId {
+				s.transactions[i] = tx
+				replaced = true
+			}
+		}
+		if !replaced {
+			s.transactions = append(s.transactions, tx)
+		}
+	}
+}
+
+// SetRenewalInfo 设置订阅的续订信息
+// Sets the renewal info of a subscription
+func (s *Server) SetRenewalInfo(renewal appstoreserverapi.JWSRenewalInfoDecodedPayload) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.renewals[renewal.OriginalTransactionId] = renewal
+}
+
+// AddOrder 添加订单和其中的交易
+// Adds an order and its transactions
+func (s *Server) AddOrder(orderId string, transactionIds ...string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.orders[orderId] = append(s.orders[orderId], transactionIds...)
+}
+
+// ConsumptionRequests 收到的消费信息
+// The consumption information received for a transaction
+func (s *Server) ConsumptionRequests(transactionId string) []appstoreserverapi.ConsumptionRequest {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]appstoreserverapi.ConsumptionRequest(nil), s.consumptions[transactionId]...)
+}
+
+// InjectError 接下来 times 次请求 endpoint 时返回错误，endpoint 为空表示所有接口
+// The next times requests to endpoint fail, an empty endpoint matches every endpoint
+func (s *Server) InjectError(endpoint string, status, errorCode, times int) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.faults = append(s.faults, &fault{
+		endpoint:  endpoint,
+		status:    status,
+		errorCode: errorCode,
+		remaining: times,
+	})
+}
+
+// InjectRateLimit 接下来 times 次请求 endpoint 时返回 429
+// The next times requests to endpoint get a 429
+func (s *Server) InjectRateLimit(endpoint string, times int) {
+	s.InjectError(endpoint, http.StatusTooManyRequests, rateLimitExceededErrorCode, times)
+}
+
+// SetLatency 每个请求的延迟
+// Delay added to each request
+func (s *Server) SetLatency(d time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.latency = d
+}
+
+// SetClock 订阅状态和 JWT 过期时间使用的时钟，默认 appstoreserverapi.SystemClock；在 Config 之前调用，客户端使用相同的时钟
+// The clock used for subscription statuses and JWT expiry, defaults to appstoreserverapi.SystemClock; call it before Config so the client shares it
+func (s *Server) SetClock(clock appstoreserverapi.Clock) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.clock = clock
+}
+
+// Calls 接口被调用的次数，包括失败的请求
+// How many times an endpoint was called, failed requests included
+func (s *Server) Calls(endpoint string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.calls[endpoint]
+}
+
+type route struct {
+	endpoint string
+	method   string
+	prefix   string
+	handle   func(s *Server, id string, r *http.Request) (int, interface{})
+}
+
+// 前缀长的在前面
+var routes = []route{
+	{EndpointExtendASubscriptionRenewalDate, http.MethodPut, "/inApps/v1/subscriptions/extend/", (*Server).extendRenewalDate},
+	{EndpointGetAllSubscriptionStatuses, http.MethodGet, "/inApps/v1/subscriptions/", (*Server).subscriptionStatuses},
+	{EndpointLookUpOrderId, http.MethodGet, "/inApps/v1/lookup/", (*Server).lookUpOrderId},
+	{EndpointGetTransactionHistory, http.MethodGet, "/inApps/v1/history/", (*Server).transactionHistory},
+	{EndpointGetRefundHistory, http.MethodGet, "/inApps/v2/refund/lookup/", (*Server).refundHistory},
+	{EndpointGetRefundHistoryV1, http.MethodGet, "/inApps/v1/refund/lookup/", (*Server).refundHistoryV1},
+	{EndpointSendConsumptionInformation, http.MethodPut, "/inApps/v1/transactions/consumption/", (*Server).sendConsumption},
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	var rt *route
+	for i := range routes {
+		if r.Method == routes[i].method && strings.HasPrefix(r.URL.Path, routes[i].prefix) {
+			rt = &routes[i]
+			break
+		}
+	}
+	if rt == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	s.lock.Lock()
+	s.calls[rt.endpoint]++
+	latency := s.latency
+	s.lock.Unlock()
+
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+	if err := s.checkBearer(r); err != nil {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	// 未授权的请求不消耗注入的错误
+	s.lock.Lock()
+	f := s.takeFault(rt.endpoint)
+	s.lock.Unlock()
+	if f != nil {
+		writeJson(w, f.status, map[string]interface{}{
+			"errorCode":    f.errorCode,
+			"errorMessage": "injected error",
+		})
+		return
+	}
+
+	status, body := s.handle(rt, r)
+	writeJson(w, status, body)
+}
+
+// handle 在锁内调用 rt.handle，panic 时也会释放锁
+func (s *Server) handle(rt *route, r *http.Request) (int, interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return rt.handle(s, strings.TrimPrefix(r.URL.Path, rt.prefix), r)
+}
+
+func (s *Server) takeFault(endpoint string) *fault {
+	for i, f := range s.faults {
+		if f.endpoint != "" && f.endpoint != endpoint {
+			continue
+		}
+		f.remaining--
+		if f.remaining <= 0 {
+			s.faults = append(s.faults[:i], s.faults[i+1:]...)
+		}
+		return f
+	}
+	return nil
+}
+
+// checkBearer 校验 JWT：签名、kid、iss、aud、bid、过期时间
+func (s *Server) checkBearer(r *http.Request) error {
+	bearer := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	msg, err := jws.ParseString(bearer)
+	if err != nil {
+		return err
+	}
+	if len(msg.Signatures()) != 1 {
+		return fmt.Errorf("expected one signature")
+	}
+	kid := msg.Signatures()[0].ProtectedHeaders().KeyID()
+	s.lock.Lock()
+	key, ok := s.keys[kid]
+	bundleIds := s.bundleIds
+	clock := s.clock
+	s.lock.Unlock()
+	if !ok {
+		return fmt.Errorf("unknown kid %s", kid)
+	}
+	token, err := jwt.ParseString(bearer,
+		jwt.WithKey(jwa.ES256, key),
+		jwt.WithValidate(true),
+		jwt.WithIssuer(Iss),
+		jwt.WithAudience("appstoreconnect-v1"),
+		jwt.WithRequiredClaim(jwt.ExpirationKey),
+		jwt.WithAcceptableSkew(time.Second),
+		jwt.WithClock(jwt.ClockFunc(clock.Now)),
+	)
+	if err != nil {
+		return err
+	}
+	bid, _ := token.Get("bid")
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if b, _ := bid.(string); !bundleIds[b] {
+		return fmt.Errorf("unknown bid %v", bid)
+	}
+	return nil
+}
+
+func writeJson(w http.ResponseWriter, status int, body interface{}) {
+	if body == nil {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func errorBody(errorCode int, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"errorCode":    errorCode,
+		"errorMessage": message,
+	}
+}
+
+// customer 和 transactionId 同一个 originalTransactionId 的交易，按购买时间排序
+func (s *Server) customer(transactionId string) []appstoreserverapi.JWSTransactionDecodedPayload {
+	original := ""
+	for _, tx := range s.transactions {
+		if tx.TransactionId == transactionId || tx.OriginalTransactionId == transactionId {
+			original = tx.OriginalTransactionId
+			break
+		}
+	}
+	if original == "" {
+		return nil
+	}
+	result := make([]appstoreserverapi.JWSTransactionDecodedPayload, 0)
+	for _, tx := range s.transactions {
+		if tx.OriginalTransactionId == original {
+			result = append(result, tx)
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].PurchaseDate < result[j].PurchaseDate
+	})
+	return result
+}
+
+func (s *Server) signAll(transactions []appstoreserverapi.JWSTransactionDecodedPayload) ([]string, error) {
+	signed := make([]string, 0, len(transactions))
+	for _, tx := range transactions {
+		one, err := s.Chain.Sign(tx)
+		if err != nil {
+			return nil, err
+		}
+		signed = append(signed, one)
+	}
+	return signed, nil
+}
+
+// signFailed 签名失败时响应 500
+func signFailed(err error) (int, interface{}) {
+	return http.StatusInternalServerError, errorBody(5000000, "Sign failed: "+err.Error())
+}
+
+func (s *Server) subscriptionStatuses(id string, r *http.Request) (int, interface{}) {
+	transactions := s.customer(id)
+	if len(transactions) == 0 {
+		return http.StatusNotFound, errorBody(4040010, "Transaction id not found.")
+	}
+	// 每个订阅组的最后一笔交易
+	latest := make(map[string]appstoreserverapi.JWSTransactionDecodedPayload)
+	groups := make([]string, 0)
+	for _, tx := range transactions {
+		if tx.SubscriptionGroupIdentifier == "" {
+			continue
+		}
+		if _, ok := latest[tx.SubscriptionGroupIdentifier]; !ok {
+			groups = append(groups, tx.SubscriptionGroupIdentifier)
+		}
+		latest[tx.SubscriptionGroupIdentifier] = tx
+	}
+	now := millis(s.clock.Now())
+	data := make([]interface{}, 0)
+	for _, group := range groups {
+		tx := latest[group]
+		renewal, ok := s.renewals[tx.OriginalTransactionId]
+		if !ok {
+			renewal = appstoreserverapi.JWSRenewalInfoDecodedPayload{
+				OriginalTransactionId: tx.OriginalTransactionId,
+				ProductId:             tx.ProductId,
+				AutoRenewProductId:    tx.ProductId,
+				AutoRenewStatus:       1,
+				Environment:           tx.Environment,
+				SignedDate:            now,
+			}
+		}
+		signedTx, err := s.Chain.Sign(tx)
+		if err != nil {
+			return signFailed(err)
+		}
+		signedRenewal, err := s.Chain.Sign(renewal)
+		if err != nil {
+			return signFailed(err)
+		}
+		data = append(data, map[string]interface{}{
+			"subscriptionGroupIdentifier": group,
+			"lastTransactions": []interface{}{map[string]interface{}{
+				"originalTransactionId": tx.OriginalTransactionId,
+				"status":                subscriptionStatus(tx, renewal, now),
+				"signedTransactionInfo": signedTx,
+				"signedRenewalInfo":     signedRenewal,
+			}},
+		})
+	}
+	return http.StatusOK, map[string]interface{}{
+		"environment": Environment,
+		"bundleId":    Bid,
+		"data":        data,
+	}
+}
+
+// subscriptionStatus 1: 有效 2: 过期 3: 账单重试 4: 宽限期 5: 撤销
+// doc: https://developer.apple.com/documentation/appstoreserverapi/status
+func subscriptionStatus(tx appstoreserverapi.JWSTransactionDecodedPayload, renewal appstoreserverapi.JWSRenewalInfoDecodedPayload, now int64) int {
+	switch {
+	case tx.RevocationDate > 0:
+		return 5
+	case tx.ExpiresDate > now:
+		return 1
+	case renewal.GracePeriodExpiresDate > now:
+		return 4
+	case renewal.IsInBillingRetryPeriod:
+		return 3
+	}
+	return 2
+}
+
+func (s *Server) lookUpOrderId(id string, r *http.Request) (int, interface{}) {
+	transactionIds, ok := s.orders[id]
+	if !ok {
+		return http.StatusOK, map[string]interface{}{"status": 1}
+	}
+	transactions := make([]appstoreserverapi.JWSTransactionDecodedPayload, 0)
+	for _, transactionId := range transactionIds {
+		for _, tx := range s.transactions {
+			if tx.TransactionId == transactionId {
+				transactions = append(transactions, tx)
+			}
+		}
+	}
+	signed, err := s.signAll(transactions)
+	if err != nil {
+		return signFailed(err)
+	}
+	return http.StatusOK, map[string]interface{}{
+		"status":             0,
+		"signedTransactions": signed,
+	}
+}
+
+// transactionHistory revision 为已返回的交易数量
+func (s *Server) transactionHistory(id string, r *http.Request) (int, interface{}) {
+	transactions := s.customer(id)
+	if len(transactions) == 0 {
+		return http.StatusNotFound, errorBody(4040010, "Transaction id not found.")
+	}
+	return s.page(transactions, r)
+}
+
+// refundHistory v2 按 revision 分页，响应中只有 revision、hasMore、signedTransactions
+func (s *Server) refundHistory(id string, r *http.Request) (int, interface{}) {
+	refunded := s.refunded(id)
+	offset, end, ok := pageRange(len(refunded), r)
+	if !ok {
+		return http.StatusBadRequest, errorBody(4000005, "Invalid request revision.")
+	}
+	signed, err := s.signAll(refunded[offset:end])
+	if err != nil {
+		return signFailed(err)
+	}
+	return http.StatusOK, map[string]interface{}{
+		"revision":           strconv.Itoa(end),
+		"hasMore":            end < len(refunded),
+		"signedTransactions": signed,
+	}
+}
+
+// refundHistoryV1 已弃用的 v1 接口：不分页，忽略 revision，一次返回所有退款
+func (s *Server) refundHistoryV1(id string, r *http.Request) (int, interface{}) {
+	signed, err := s.signAll(s.refunded(id))
+	if err != nil {
+		return signFailed(err)
+	}
+	return http.StatusOK, map[string]interface{}{
+		"signedTransactions": signed,
+	}
+}
+
+// refunded 客户的退款，按退款时间排序，新的退款在最后
+func (s *Server) refunded(id string) []appstoreserverapi.JWSTransactionDecodedPayload {
+	refunded := make([]appstoreserverapi.JWSTransactionDecodedPayload, 0)
+	for _, tx := range s.customer(id) {
+		if tx.RevocationDate > 0 {
+			refunded = append(refunded, tx)
+		}
+	}
+	sort.SliceStable(refunded, func(i, j int) bool {
+		return refunded[i].RevocationDate < refunded[j].RevocationDate
+	})
+	return refunded
+}
+
+// page 按 revision 分页，revision 为已返回的数量
+func (s *Server) page(transactions []appstoreserverapi.JWSTransactionDecodedPayload, r *http.Request) (int, interface{}) {
+	offset, end, ok := pageRange(len(transactions), r)
+	if !ok {
+		return http.StatusBadRequest, errorBody(4000005, "Invalid request revision.")
+	}
+	signed, err := s.signAll(transactions[offset:end])
+	if err != nil {
+		return signFailed(err)
+	}
+	return http.StatusOK, map[string]interface{}{
+		"revision":           strconv.Itoa(end),
+		"bundleId":           Bid,
+		"environment":        Environment,
+		"hasMore":            end < len(transactions),
+		"signedTransactions": signed,
+	}
+}
+
+// pageRange 一页的范围，revision 无效时 ok 为 false
+func pageRange(total int, r *http.Request) (offset, end int, ok bool) {
+	if revision := r.URL.Query().Get("revision"); revision != "" {
+		var err error
+		offset, err = strconv.Atoi(revision)
+		if err != nil || offset < 0 {
+			return 0, 0, false
+		}
+		if offset > total {
+			offset = total
+		}
+	}
+	end = offset + historyPageSize
+	if end > total {
+		end = total
+	}
+	return offset, end, true
+}
+
+func (s *Server) extendRenewalDate(id string, r *http.Request) (int, interface{}) {
+	req := appstoreserverapi.ExtendRenewalDateRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return http.StatusBadRequest, errorBody(4000000, "Bad request.")
+	}
+	if req.ExtendByDays == 0 || req.ExtendByDays > 90 {
+		return http.StatusBadRequest, errorBody(4000009, "Invalid extend by days value.")
+	}
+	transactions := s.customer(id)
+	if len(transactions) == 0 {
+		return http.StatusNotFound, errorBody(4040005, "Original transaction id not found.")
+	}
+	latest := transactions[len(transactions)-1]
+	latest.ExpiresDate += int64(req.ExtendByDays) * int64(24*time.Hour/time.Millisecond)
+	for i := range s.transactions {
+		if s.transactions[i].TransactionId == latest.TransactionId {
+			s.transactions[i] = latest
+		}
+	}
+	return http.StatusOK, map[string]interface{}{
+		"effectiveDate":         latest.ExpiresDate,
+		"originalTransactionId": latest.OriginalTransactionId,
+		"success":               true,
+		"webOrderLineItemId":    latest.WebOrderLineItemId,
+	}
+}
+
+func (s *Server) sendConsumption(id string, r *http.Request) (int, interface{}) {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return http.StatusBadRequest, errorBody(4000000, "Bad request.")
+	}
+	req := appstoreserverapi.ConsumptionRequest{}
+	if err := json.Unmarshal(b, &req); err != nil {
+		return http.StatusBadRequest, errorBody(4000000, "Bad request.")
+	}
+	if len(s.customer(id)) == 0 {
+		return http.StatusNotFound, errorBody(4040010, "Transaction id not found.")
+	}
+	s.consumptions[id] = append(s.consumptions[id], req)
+	return http.StatusAccepted, nil
+}
@@ -0,0 +1,364 @@
+package storetest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/lhlyu/appstoreserverapi"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestServer(t *testing.T) (*Server, appstoreserverapi.Client) {
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	cfg := s.Config()
+	cfg.TryCount = 1
+	c, err := appstoreserverapi.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, c
+}
+
+func subscription(original, transactionId string, purchase time.Time, period time.Duration) appstoreserverapi.JWSTransactionDecodedPayload {
+	return appstoreserverapi.JWSTransactionDecodedPayload{
+		TransactionId:               transactionId,
+		OriginalTransactionId:       original,
+		WebOrderLineItemId:          "w" + transactionId,
+		BundleId:                    Bid,
+		ProductId:                   "monthly",
+		SubscriptionGroupIdentifier: "group",
+		PurchaseDate:                millis(purchase),
+		ExpiresDate:                 millis(purchase.Add(period)),
+		Quantity:                    1,
+		Type:                        "Auto-Renewable Subscription",
+		Environment:                 Environment,
+	}
+}
+
+func TestServer_Endpoints(t *testing.T) {
+	s, c := newTestServer(t)
+	now := time.Now()
+	s.AddTransaction(
+		subscription("1000", "1000", now.Add(-40*24*time.Hour), 30*24*time.Hour),
+		subscription("1000", "1001", now.Add(-10*24*time.Hour), 30*24*time.Hour),
+	)
+	s.AddOrder("ORDER1", "1000")
+
+	status, err := c.ApiGetAllSubscriptionStatuses("1000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Data) != 1 || status.Data[0].LastTransactions[0].Status != 1 {
+		t.Fatalf("status = %+v, want one active subscription", status.Data)
+	}
+	if got := status.Data[0].LastTransactions[0].SignedTransactionInfo.TransactionId; got != "1001" {
+		t.Errorf("last transaction = %s, want 1001", got)
+	}
+
+	order, err := c.ApiLookUpOrderId("ORDER1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != 0 || len(order.SignedTransactions) != 1 {
+		t.Errorf("order = %+v, want one transaction", order)
+	}
+	order, err = c.ApiLookUpOrderId("MISSING")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Status != 1 {
+		t.Errorf("order status = %d, want 1", order.Status)
+	}
+
+	history, err := c.ApiGetTransactionHistory("1001", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.SignedTransactions) != 2 || history.HasMore {
+		t.Errorf("history = %+v, want two transactions", history)
+	}
+
+	refunds, err := c.ApiGetRefundHistory("1000", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refunds.SignedTransactions) != 0 {
+		t.Errorf("refunds = %d, want 0", len(refunds.SignedTransactions))
+	}
+
+	extended, err := c.ApiExtendAsubscriptionRenewalDate("1000", appstoreserverapi.ExtendRenewalDateRequest{
+		ExtendByDays:      5,
+		ExtendReasonCode:  1,
+		RequestIdentifier: "req",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := subscription("1000", "1001", now.Add(-10*24*time.Hour), 35*24*time.Hour).ExpiresDate
+	if !extended.Success || extended.EffectiveDate != want {
+		t.Errorf("extended = %+v, want effective date %d", extended, want)
+	}
+
+	err = c.ApiSendConsumptionInformation("1001", appstoreserverapi.ConsumptionRequest{CustomerConsented: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.ConsumptionRequests("1001"); len(got) != 1 || !got[0].CustomerConsented {
+		t.Errorf("consumption requests = %+v", got)
+	}
+}
+
+func TestServer_HistoryPages(t *testing.T) {
+	s, c := newTestServer(t)
+	start := time.Now().Add(-365 * 24 * time.Hour)
+	for i := 0; i < 25; i++ {
+		s.AddTransaction(subscription("1", fmt.Sprint(i+1), start.Add(time.Duration(i)*24*time.Hour), 24*time.Hour))
+	}
+	history, err := c.ApiGetTransactionHistory("1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history.SignedTransactions) != 20 || !history.HasMore || history.Revision != "20" {
+		t.Errorf("first page = %d transactions, hasMore %v, revision %s", len(history.SignedTransactions), history.HasMore, history.Revision)
+	}
+}
+
+func TestServer_RefundAndStatus(t *testing.T) {
+	s, c := newTestServer(t)
+	now := time.Now()
+	revoked := subscription("2000", "2000", now.Add(-24*time.Hour), 30*24*time.Hour)
+	revoked.RevocationDate = millis(now)
+	revoked.RevocationReason = 1
+	s.AddTransaction(revoked)
+
+	refunds, err := c.ApiGetRefundHistory("2000", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(refunds.SignedTransactions) != 1 {
+		t.Errorf("refunds = %d, want 1", len(refunds.SignedTransactions))
+	}
+	status, err := c.ApiGetAllSubscriptionStatuses("2000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := status.Data[0].LastTransactions[0].Status; got != 5 {
+		t.Errorf("status = %d, want 5", got)
+	}
+}
+
+func TestServer_RefundHistoryVersions(t *testing.T) {
+	s, c := newTestServer(t)
+	start := time.Now().Add(-30 * 24 * time.Hour)
+	for i := 0; i < 25; i++ {
+		tx := subscription("3000", fmt.Sprint(3000+i), start.Add(time.Duration(i)*time.Hour), time.Hour)
+		tx.RevocationDate = millis(start.Add(time.Duration(i)*time.Hour + time.Minute))
+		s.AddTransaction(tx)
+	}
+
+	// v2 按 revision 分页，响应中没有 bundleId 和 environment
+	first, err := c.ApiGetRefundHistory("3000", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.SignedTransactions) != 20 || !first.HasMore || first.Revision != "20" {
+		t.Errorf("first page = %d refunds, hasMore %v, revision %s", len(first.SignedTransactions), first.HasMore, first.Revision)
+	}
+	if strings.Contains(first.Raw(), "bundleId") || strings.Contains(first.Raw(), "environment") {
+		t.Errorf("v2 response has fields Apple does not send: %s", first.Raw())
+	}
+	second, err := c.ApiGetRefundHistory("3000", false, appstoreserverapi.WithRevision(first.Revision))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(second.SignedTransactions) != 5 || second.HasMore || second.Revision != "25" {
+		t.Errorf("second page = %d refunds, hasMore %v, revision %s", len(second.SignedTransactions), second.HasMore, second.Revision)
+	}
+
+	// v1 不分页，忽略 revision
+	tokens, err := appstoreserverapi.NewTokenSource(s.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bearer, err := tokens.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest(http.MethodGet, s.URL+"/inApps/v1/refund/lookup/3000?revision=20", nil)
+	req.Header.Set("Authorization", "Bearer "+bearer)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body := map[string]interface{}{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := body["revision"]; ok {
+		t.Error("v1 response should not have a revision")
+	}
+	if _, ok := body["hasMore"]; ok {
+		t.Error("v1 response should not have hasMore")
+	}
+	if got := len(body["signedTransactions"].([]interface{})); got != 25 {
+		t.Errorf("v1 refunds = %d, want 25", got)
+	}
+	if s.Calls(EndpointGetRefundHistory) != 2 || s.Calls(EndpointGetRefundHistoryV1) != 1 {
+		t.Errorf("calls: v2 = %d, v1 = %d", s.Calls(EndpointGetRefundHistory), s.Calls(EndpointGetRefundHistoryV1))
+	}
+}
+
+func TestServer_Bearer(t *testing.T) {
+	s, _ := newTestServer(t)
+	s.AddTransaction(subscription("1", "1", time.Now(), time.Hour))
+
+	cfg := s.Config()
+	cfg.Bid = "com.example.other"
+	c, err := appstoreserverapi.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ApiGetTransactionHistory("1", false); err != appstoreserverapi.ErrUnauthorized {
+		t.Errorf("unknown bid: err = %v, want %v", err, appstoreserverapi.ErrUnauthorized)
+	}
+	s.AddBundleId("com.example.other")
+	other := subscription("2", "2", time.Now(), time.Hour)
+	other.BundleId = "com.example.other"
+	s.AddTransaction(other)
+	if _, err := c.ApiGetTransactionHistory("2", false); err != nil {
+		t.Errorf("registered bid: err = %v", err)
+	}
+
+	s.RevokeKey(Kid)
+	c, err = appstoreserverapi.NewClient(s.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ApiGetTransactionHistory("1", false); err != appstoreserverapi.ErrUnauthorized {
+		t.Errorf("revoked key: err = %v, want %v", err, appstoreserverapi.ErrUnauthorized)
+	}
+}
+
+func TestServer_Faults(t *testing.T) {
+	s, c := newTestServer(t)
+	s.AddTransaction(subscription("1", "1", time.Now(), time.Hour))
+
+	s.InjectError(EndpointGetTransactionHistory, http.StatusNotFound, 4040010, 1)
+	_, err := c.ApiGetTransactionHistory("1", false)
+	var appErr appstoreserverapi.AppError
+	if !errors.As(err, &appErr) || appErr.ErrorCode() != 4040010 {
+		t.Errorf("err = %v, want 4040010", err)
+	}
+	if _, err := c.ApiGetTransactionHistory("1", false); err != nil {
+		t.Errorf("fault should be used up: err = %v", err)
+	}
+
+	s.InjectRateLimit("", 1)
+	_, err = c.ApiGetRefundHistory("1", false)
+	if !errors.As(err, &appErr) || appErr.ErrorCode() != rateLimitExceededErrorCode {
+		t.Errorf("err = %v, want %d", err, rateLimitExceededErrorCode)
+	}
+	if got := s.Calls(EndpointGetRefundHistory); got != 1 {
+		t.Errorf("calls = %d, want 1", got)
+	}
+
+	s.SetLatency(50 * time.Millisecond)
+	cfg := s.Config()
+	cfg.TryCount = 1
+	cfg.HttpClient = &http.Client{Timeout: 10 * time.Millisecond}
+	slow, err := appstoreserverapi.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := slow.ApiGetRefundHistory("1", false); err == nil {
+		t.Error("expected a timeout")
+	}
+}
+
+func TestServer_FaultsAfterBearer(t *testing.T) {
+	s, c := newTestServer(t)
+	s.AddTransaction(subscription("1", "1", time.Now(), time.Hour))
+
+	// 未授权的请求不消耗注入的错误
+	s.InjectError(EndpointGetTransactionHistory, http.StatusNotFound, 4040010, 1)
+	cfg := s.Config()
+	cfg.Bid = "com.example.other"
+	unauthorized, err := appstoreserverapi.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := unauthorized.ApiGetTransactionHistory("1", false); err != appstoreserverapi.ErrUnauthorized {
+		t.Fatalf("unknown bid: err = %v, want %v", err, appstoreserverapi.ErrUnauthorized)
+	}
+	_, err = c.ApiGetTransactionHistory("1", false)
+	var appErr appstoreserverapi.AppError
+	if !errors.As(err, &appErr) || appErr.ErrorCode() != 4040010 {
+		t.Errorf("err = %v, want the injected 4040010", err)
+	}
+}
+
+func TestServer_SignFailure(t *testing.T) {
+	s, c := newTestServer(t)
+	s.AddTransaction(subscription("1", "1", time.Now(), time.Hour))
+	chain := s.Chain
+	// 没有私钥的证书链无法签名
+	s.Chain = &CertificateChain{}
+
+	for _, call := range []func() error{
+		func() error { _, err := c.ApiGetTransactionHistory("1", false); return err },
+		func() error { _, err := c.ApiGetAllSubscriptionStatuses("1"); return err },
+	} {
+		if err := call(); err == nil {
+			t.Error("expected an error when signing fails")
+		}
+	}
+	// 签名失败后锁已释放，服务仍然可用
+	s.Chain = chain
+	if _, err := c.ApiGetTransactionHistory("1", false); err != nil {
+		t.Errorf("after restoring the chain: err = %v", err)
+	}
+}
+
+func TestServer_Clock(t *testing.T) {
+	for _, start := range []time.Time{time.Now(), time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)} {
+		testServerClock(t, start)
+	}
+}
+
+// testServerClock 从 start 开始的时钟，历史时间也能校验证书链
+func testServerClock(t *testing.T, start time.Time) {
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	clock := NewClock(start)
+	s.SetClock(clock)
+	s.AddScenario(MonthlySubscription("5000", "monthly", start))
+	cfg := s.Config()
+	cfg.TryCount = 1
+	c, err := appstoreserverapi.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 订阅状态按模拟服务的时钟计算
+	for _, want := range []int64{1, 2} {
+		status, err := c.ApiGetAllSubscriptionStatuses("5000")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := status.Data[0].LastTransactions[0].Status; got != want {
+			t.Errorf("status at %s = %d, want %d", clock.Now().Format(time.RFC3339), got, want)
+		}
+		clock.Advance(32 * 24 * time.Hour)
+	}
+}
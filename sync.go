@@ -0,0 +1,239 @@
+package appstoreserverapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+)
+
+var ErrSyncNoProgress = errors.New("history has more pages but the revision did not advance")
+
+// Checkpoint 一个客户的同步进度，保存最后一页的 Revision
+// The sync progress of one customer, the Revision of the last page fetched
+type Checkpoint struct {
+	HistoryRevision string `json:"historyRevision,omitempty"`
+	RefundRevision  string `json:"refundRevision,omitempty"`
+}
+
+// CheckpointStore 保存同步进度，每一页写入存储后保存一次，任务中断后从最后一页继续
+// Keeps sync progress, saved after each page is stored so an interrupted job resumes from the last page
+type CheckpointStore interface {
+	// Checkpoint 不存在时返回零值
+	// Returns the zero Checkpoint when missing
+	Checkpoint(transactionId string) (Checkpoint, error)
+	SaveCheckpoint(transactionId string, checkpoint Checkpoint) error
+}
+
+// MemoryCheckpoints 内存中的 CheckpointStore
+// An in-memory CheckpointStore
+type MemoryCheckpoints struct {
+	lock        sync.RWMutex
+	checkpoints map[string]Checkpoint
+}
+
+func NewMemoryCheckpoints() *MemoryCheckpoints {
+	return &MemoryCheckpoints{checkpoints: make(map[string]Checkpoint)}
+}
+
+func (m *MemoryCheckpoints) Checkpoint(transactionId string) (Checkpoint, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.checkpoints[transactionId], nil
+}
+
+func (m *MemoryCheckpoints) SaveCheckpoint(transactionId string, checkpoint Checkpoint) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.checkpoints[transactionId] = checkpoint
+	return nil
+}
+
+// FileCheckpoints JSON 文件中的 CheckpointStore，每次保存都写入临时文件后替换
+// A CheckpointStore in a JSON file, each save writes a temporary file and renames it over the old one
+type FileCheckpoints struct {
+	path string
+
+	lock        sync.Mutex
+	checkpoints map[string]Checkpoint
+}
+
+// NewFileCheckpoints 读取已有的进度，文件不存在时从头开始
+// Loads existing progress, starts empty when the file does not exist
+func NewFileCheckpoints(path string) (*FileCheckpoints, error) {
+	f := &FileCheckpoints{
+		path:        path,
+		checkpoints: make(map[string]Checkpoint),
+	}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &f.checkpoints); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FileCheckpoints) Checkpoint(transactionId string) (Checkpoint, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.checkpoints[transactionId], nil
+}
+
+func (f *FileCheckpoints) SaveCheckpoint(transactionId string, checkpoint Checkpoint) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.checkpoints[transactionId] = checkpoint
+	b, err := json.MarshalIndent(f.checkpoints, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err := writeFileSync(tmp, b); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
+
+// SyncResult 一个客户的同步结果
+// The sync result of one customer
+type SyncResult struct {
+	TransactionId string
+	// 本次拉取的交易数量
+	// Transactions fetched this run
+	Transactions int
+	// 本次拉取的退款数量
+	// Refunds fetched this run
+	Refunds int
+	// 解码失败的条目，其它条目已经保存，进度照常前进
+	// Items that failed to decode, the others were saved and the progress still advanced
+	DecodeErrors DecodeErrors
+	Err          error
+}
+
+// Syncer 增量同步客户的交易历史和退款到 TransactionStore
+// 客户用其任意一笔交易的 transactionId 表示，只拉取上次 Revision 之后的数据
+// Incrementally syncs the transaction history and refunds of customers into a TransactionStore
+// A customer is identified by the transactionId of any of their transactions, only pages after the last Revision are fetched
+type Syncer struct {
+	client      Client
+	store       TransactionStore
+	checkpoints CheckpointStore
+	concurrency int
+}
+
+// NewSyncer 创建同步任务
+// concurrency: 同时同步的客户数量，至少为 1
+// concurrency: how many customers are synced at once, at least 1
+func NewSyncer(client Client, store TransactionStore, checkpoints CheckpointStore, concurrency int) *Syncer {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &Syncer{
+		client:      client,
+		store:       store,
+		checkpoints: checkpoints,
+		concurrency: concurrency,
+	}
+}
+
+// Sync 同步多个客户，结果和 transactionIds 的顺序一致
+// ctx 取消后不再开始新的客户，未开始的客户的 Err 为 ctx.Err()
+// Syncs several customers, results are in the order of transactionIds
+// once ctx is done no new customer is started, those not started get ctx.Err()
+func (s *Syncer) Sync(ctx context.Context, transactionIds []string) []SyncResult {
+	results := make([]SyncResult, len(transactionIds))
+	fanOut(ctx, len(transactionIds), s.concurrency, func(i int) {
+		results[i] = s.SyncCustomer(ctx, transactionIds[i])
+	}, func(i int, err error) {
+		results[i] = SyncResult{TransactionId: transactionIds[i], Err: err}
+	})
+	return results
+}
+
+// SyncCustomer 同步一个客户的交易历史，然后同步退款，ctx 通过 WithContext 传给每次调用
+// Syncs the transaction history of one customer, then the refunds, ctx is passed to each call with WithContext
+func (s *Syncer) SyncCustomer(ctx context.Context, transactionId string) SyncResult {
+	result := SyncResult{TransactionId: transactionId}
+	checkpoint, err := s.checkpoints.Checkpoint(transactionId)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+
+	for {
+		r, err := s.client.ApiGetTransactionHistory(transactionId, false, WithContext(ctx), WithRevision(checkpoint.HistoryRevision))
+		if !result.partial(r != nil, err) {
+			return result
+		}
+		if err := s.save(r.SignedTransactions); err != nil {
+			result.Err = err
+			return result
+		}
+		result.Transactions += len(r.SignedTransactions)
+		if r.HasMore && r.Revision == checkpoint.HistoryRevision {
+			result.Err = ErrSyncNoProgress
+			return result
+		}
+		checkpoint.HistoryRevision = r.Revision
+		if err := s.checkpoints.SaveCheckpoint(transactionId, checkpoint); err != nil {
+			result.Err = err
+			return result
+		}
+		if !r.HasMore {
+			break
+		}
+	}
+
+	for {
+		r, err := s.client.ApiGetRefundHistory(transactionId, false, WithContext(ctx), WithRevision(checkpoint.RefundRevision))
+		if !result.partial(r != nil, err) {
+			return result
+		}
+		if err := s.save(r.SignedTransactions); err != nil {
+			result.Err = err
+			return result
+		}
+		result.Refunds += len(r.SignedTransactions)
+		if r.HasMore && r.Revision == checkpoint.RefundRevision {
+			result.Err = ErrSyncNoProgress
+			return result
+		}
+		if r.Revision != "" {
+			checkpoint.RefundRevision = r.Revision
+			if err := s.checkpoints.SaveCheckpoint(transactionId, checkpoint); err != nil {
+				result.Err = err
+				return result
+			}
+		}
+		if !r.HasMore {
+			break
+		}
+	}
+	return result
+}
+
+// partial 部分模式下有解码错误的结果仍然保存，否则一个无法解码的条目会让该客户一直无法同步；其它错误时返回 false
+func (r *SyncResult) partial(ok bool, err error) bool {
+	if err == nil {
+		return true
+	}
+	if errs, isDecode := err.(DecodeErrors); isDecode && ok {
+		r.DecodeErrors = append(r.DecodeErrors, errs...)
+		return true
+	}
+	r.Err = err
+	return false
+}
+
+func (s *Syncer) save(transactions []JWSTransactionDecodedPayload) error {
+	if len(transactions) == 0 {
+		return nil
+	}
+	return s.store.PutTransactions(transactions...)
+}
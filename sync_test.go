@@ -0,0 +1,138 @@
+package appstoreserverapi_test
+
+import (
+	"context"
+	"fmt"
+	"github.com/lhlyu/appstoreserverapi"
+	"github.com/lhlyu/appstoreserverapi/storetest"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncer_Resume(t *testing.T) {
+	start := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	customers := make([]string, 0)
+	scenarios := make([]*storetest.Scenario, 0)
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprint(1000 + i)
+		scenarios = append(scenarios, storetest.MonthlySubscription(id, "monthly", start).Renew(24))
+		customers = append(customers, id)
+	}
+	s, client := newStoreTestClient(t, func(cfg *appstoreserverapi.Config) {
+		cfg.TryCount = 1
+	}, scenarios...)
+	store := appstoreserverapi.NewMemoryStore()
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	checkpoints, err := appstoreserverapi.NewFileCheckpoints(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 退款接口失败，模拟任务中途崩溃：交易历史的进度已经保存
+	s.InjectError(storetest.EndpointGetRefundHistory, 500, 5000000, 1)
+	syncer := appstoreserverapi.NewSyncer(client, store, checkpoints, 1)
+	first := syncer.SyncCustomer(context.Background(), "1000")
+	if first.Err == nil {
+		t.Fatal("expected the injected error")
+	}
+	if first.Transactions != 25 {
+		t.Errorf("transactions = %d, want 25", first.Transactions)
+	}
+
+	// 进度已经保存到文件，重新打开后没有新的交易
+	checkpoints, err = appstoreserverapi.NewFileCheckpoints(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp, _ := checkpoints.Checkpoint("1000"); cp.HistoryRevision != "25" {
+		t.Errorf("checkpoint = %+v, want revision 25", cp)
+	}
+	calls := s.Calls(storetest.EndpointGetTransactionHistory)
+
+	// 续订一次并退款
+	sc := storetest.MonthlySubscription("1000", "monthly", start).Renew(25).Refund(start.AddDate(2, 1, 3))
+	s.AddScenario(sc)
+
+	syncer = appstoreserverapi.NewSyncer(client, store, checkpoints, 3)
+	results := syncer.Sync(context.Background(), customers)
+	for _, r := range results {
+		if r.Err != nil {
+			t.Fatalf("%s: %v", r.TransactionId, r.Err)
+		}
+	}
+	if results[0].Transactions != 1 || results[0].Refunds != 1 {
+		t.Errorf("resumed customer: %+v, want 1 transaction and 1 refund", results[0])
+	}
+	if got := s.Calls(storetest.EndpointGetTransactionHistory) - calls; got != 1+4*2 {
+		t.Errorf("history calls = %d, want 9", got)
+	}
+	latest, err := store.Transaction(sc.Latest().TransactionId)
+	if err != nil || latest.RevocationDate == 0 {
+		t.Errorf("latest = %+v, err = %v, want refunded", latest, err)
+	}
+	for _, id := range customers[1:] {
+		if txs, _ := store.TransactionsByOriginalTransactionId(id); len(txs) != 25 {
+			t.Errorf("%s: stored %d transactions, want 25", id, len(txs))
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if r := syncer.Sync(ctx, customers[:1]); r[0].Err != context.Canceled {
+		t.Errorf("err = %v, want %v", r[0].Err, context.Canceled)
+	}
+	// SyncCustomer 把 ctx 传给每次调用，已取消时不发出请求
+	calls = s.Calls(storetest.EndpointGetTransactionHistory)
+	if r := syncer.SyncCustomer(ctx, customers[1]); r.Err != context.Canceled {
+		t.Errorf("SyncCustomer err = %v, want %v", r.Err, context.Canceled)
+	}
+	if got := s.Calls(storetest.EndpointGetTransactionHistory) - calls; got != 0 {
+		t.Errorf("history calls after cancel = %d, want 0", got)
+	}
+}
+
+func TestSyncer_DecodeErrors(t *testing.T) {
+	s, err := storetest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	tx := storetest.MonthlySubscription("1500", "monthly", time.Now()).Latest()
+	signed, err := s.Chain.Sign(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 交易历史中有一条无法解码
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/history/") {
+			fmt.Fprintf(w, `{"revision":"r1","hasMore":false,"signedTransactions":["not a jws",%q]}`, signed)
+			return
+		}
+		w.Write([]byte(`{"revision":"","hasMore":false,"signedTransactions":[]}`))
+	}))
+	defer srv.Close()
+	cfg := s.Config()
+	cfg.TryCount = 1
+	cfg.BaseUrls = map[appstoreserverapi.Env]string{cfg.Evn: srv.URL}
+	client, err := appstoreserverapi.NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	store := appstoreserverapi.NewMemoryStore()
+	checkpoints := appstoreserverapi.NewMemoryCheckpoints()
+	result := appstoreserverapi.NewSyncer(client, store, checkpoints, 1).SyncCustomer(context.Background(), "1500")
+	if result.Err != nil || len(result.DecodeErrors) != 1 || result.Transactions != 1 {
+		t.Fatalf("result = %+v", result)
+	}
+	if _, err := store.Transaction(tx.TransactionId); err != nil {
+		t.Errorf("decoded transaction was not saved: %v", err)
+	}
+	if cp, _ := checkpoints.Checkpoint("1500"); cp.HistoryRevision != "r1" {
+		t.Errorf("checkpoint = %+v, want revision r1", cp)
+	}
+}
@@ -0,0 +1,137 @@
+package appstoreserverapi
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TokenSource 提供接口请求使用的 JWT
+// Provides the JWT used by API requests
+type TokenSource interface {
+	Token() (string, error)
+	// Invalidate 作废 token，例如收到 401 时，下次调用 Token 会重新签名
+	// Invalidates the token, eg: after a 401, the next Token call signs a new one
+	Invalidate(bearer string)
+}
+
+type cachedToken struct {
+	bearer string
+	exp    time.Time
+	// 签名使用的秘钥下标
+	key int
+}
+
+// tokenSource 缓存 token，过期时间保存在内部，不修改 Config
+// 进入提前刷新窗口后在后台刷新，期间继续返回旧的 token，只有 token 不存在或者已过期时才会阻塞
+type tokenSource struct {
+	cfg  *Config
+	keys []SigningKey
+	// 下一次签名使用的秘钥下标
+	next int32
+
+	current    atomic.Value // *cachedToken
+	lock       sync.Mutex   // 同一时间只有一个签名
+	refreshing int32
+}
+
+// NewTokenSource 创建线程安全的 TokenSource，使用 cfg 的副本
+// Creates a thread-safe TokenSource from a copy of cfg
+func NewTokenSource(cfg *Config) (TokenSource, error) {
+	if cfg == nil {
+		return nil, ErrConfigIsNil
+	}
+	c := *cfg
+	if c.Iss == "" || c.Bid == "" || !c.hasKey() {
+		return nil, ErrConfigInvalid
+	}
+	if c.ExpiryIn == 0 {
+		c.ExpiryIn = time.Minute * 10
+	}
+	if c.RefreshSkew == 0 {
+		c.RefreshSkew = c.ExpiryIn / 10
+	}
+	if c.RefreshSkew >= c.ExpiryIn {
+		c.RefreshSkew = c.ExpiryIn / 2
+	}
+	if c.Aud == "" {
+		c.Aud = "appstoreconnect-v1"
+	}
+	if c.KeyRotation == "" {
+		c.KeyRotation = RotateOnUnauthorized
+	}
+	if c.Clock == nil {
+		c.Clock = SystemClock
+	}
+	return newTokenSource(&c), nil
+}
+
+func newTokenSource(cfg *Config) *tokenSource {
+	return &tokenSource{
+		cfg:  cfg,
+		keys: cfg.signingKeys(),
+	}
+}
+
+func (ts *tokenSource) Token() (string, error) {
+	now := ts.cfg.now()
+	t, _ := ts.current.Load().(*cachedToken)
+	if t != nil && now.Before(t.exp) {
+		if !now.Before(t.exp.Add(-ts.cfg.RefreshSkew)) {
+			ts.refreshInBackground()
+		}
+		return t.bearer, nil
+	}
+	t, err := ts.refresh(t)
+	if err != nil {
+		return "", err
+	}
+	return t.bearer, nil
+}
+
+// refresh 重新签名，old 已经被其它 goroutine 替换时直接返回新的 token
+// 被 Invalidate 作废后当前 token 为空，同样需要重新签名
+func (ts *tokenSource) refresh(old *cachedToken) (*cachedToken, error) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	if t, _ := ts.current.Load().(*cachedToken); t != nil && t != old {
+		return t, nil
+	}
+	now := ts.cfg.now()
+	key := int(atomic.LoadInt32(&ts.next))
+	bearer, exp, err := signJwt(ts.cfg, ts.keys[key], now)
+	if err != nil {
+		return nil, err
+	}
+	if ts.cfg.KeyRotation == RotateRoundRobin {
+		atomic.StoreInt32(&ts.next, int32((key+1)%len(ts.keys)))
+	}
+	ts.cfg.metrics().TokenRefresh()
+	t := &cachedToken{bearer: bearer, exp: exp, key: key}
+	ts.current.Store(t)
+	return t, nil
+}
+
+// Invalidate 只有 bearer 仍是当前 token 时才作废，并切换到下一个秘钥，避免并发的 401 重复切换
+func (ts *tokenSource) Invalidate(bearer string) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	t, _ := ts.current.Load().(*cachedToken)
+	if t == nil || t.bearer != bearer {
+		return
+	}
+	atomic.StoreInt32(&ts.next, int32((t.key+1)%len(ts.keys)))
+	ts.current.Store((*cachedToken)(nil))
+}
+
+func (ts *tokenSource) refreshInBackground() {
+	if !atomic.CompareAndSwapInt32(&ts.refreshing, 0, 1) {
+		return
+	}
+	old, _ := ts.current.Load().(*cachedToken)
+	go func() {
+		defer atomic.StoreInt32(&ts.refreshing, 0)
+		// 失败时保留旧的 token，下次请求会再次尝试
+		_, _ = ts.refresh(old)
+	}()
+}
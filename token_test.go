@@ -0,0 +1,220 @@
+package appstoreserverapi
+
+import (
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"github.com/lestrrat-go/jwx/v2/jwt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenSource_Concurrent(t *testing.T) {
+	key, pk := newTestKey(t)
+	cfg := &Config{
+		Iss: ISS,
+		Kid: KID,
+		Bid: BID,
+		Pk:  pk,
+	}
+	before := *cfg
+	ts, err := NewTokenSource(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*cfg, before) {
+		t.Errorf("config was mutated: %+v", cfg)
+	}
+
+	tokens := make([]string, 50)
+	wg := sync.WaitGroup{}
+	for i := range tokens {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tokens[i], _ = ts.Token()
+		}(i)
+	}
+	wg.Wait()
+	for _, token := range tokens {
+		if token != tokens[0] {
+			t.Fatal("concurrent calls signed more than one token")
+		}
+	}
+	verifyTestJwt(t, tokens[0], key.Public())
+}
+
+func TestTokenSource_RefreshSkew(t *testing.T) {
+	_, pk := newTestKey(t)
+	lock := sync.Mutex{}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ts, err := NewTokenSource(&Config{
+		Iss:         ISS,
+		Kid:         KID,
+		Bid:         BID,
+		Pk:          pk,
+		ExpiryIn:    time.Hour,
+		RefreshSkew: 10 * time.Minute,
+		Clock: ClockFunc(func() time.Time {
+			lock.Lock()
+			defer lock.Unlock()
+			return now
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	now = now.Add(55 * time.Minute)
+	lock.Unlock()
+	// 已经在提前刷新窗口内：先返回旧的 token，后台刷新
+	second, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second != first {
+		t.Error("token inside the skew window should be served while refreshing")
+	}
+
+	// 提前刷新不小于有效期时，每次调用都会在后台刷新，使用有效期的 1/2
+	for _, skew := range []time.Duration{time.Hour, 2 * time.Hour} {
+		ts, err := NewTokenSource(&Config{Iss: ISS, Kid: KID, Bid: BID, Pk: pk, ExpiryIn: time.Hour, RefreshSkew: skew})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := ts.(*tokenSource).cfg.RefreshSkew; got != 30*time.Minute {
+			t.Errorf("skew %s: RefreshSkew = %s, want %s", skew, got, 30*time.Minute)
+		}
+	}
+}
+
+func tokenKid(t *testing.T, token string) string {
+	msg, err := jws.ParseString(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg.Signatures()[0].ProtectedHeaders().KeyID()
+}
+
+func TestTokenSource_Rotation(t *testing.T) {
+	_, oldPk := newTestKey(t)
+	_, newPk := newTestKey(t)
+	keys := []SigningKey{
+		{Kid: "OLDKEY0001", Pk: oldPk},
+		{Kid: "NEWKEY0002", Pk: newPk},
+	}
+
+	ts, err := NewTokenSource(&Config{Iss: ISS, Bid: BID, Keys: keys})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := ts.Token()
+	if kid := tokenKid(t, first); kid != "OLDKEY0001" {
+		t.Fatalf("kid = %s, want OLDKEY0001", kid)
+	}
+	// 收到 401：作废后使用下一个秘钥
+	ts.Invalidate(first)
+	second, _ := ts.Token()
+	if kid := tokenKid(t, second); kid != "NEWKEY0002" {
+		t.Fatalf("kid = %s, want NEWKEY0002", kid)
+	}
+	// 过期的 bearer 不会再次切换
+	ts.Invalidate(first)
+	third, _ := ts.Token()
+	if third != second {
+		t.Error("invalidating a stale bearer should keep the current token")
+	}
+}
+
+func TestTokenSource_Clock(t *testing.T) {
+	_, pk := newTestKey(t)
+	lock := sync.Mutex{}
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	advance := func(d time.Duration) {
+		lock.Lock()
+		defer lock.Unlock()
+		now = now.Add(d)
+	}
+	ts, err := NewTokenSource(&Config{
+		Iss: ISS,
+		Kid: KID,
+		Bid: BID,
+		Pk:  pk,
+		Clock: ClockFunc(func() time.Time {
+			lock.Lock()
+			defer lock.Unlock()
+			return now
+		}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := ts.Token()
+	token, err := jwt.ParseString(first, jwt.WithVerify(false), jwt.WithValidate(false))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !token.IssuedAt().Equal(now) || !token.Expiration().Equal(now.Add(10*time.Minute)) {
+		t.Errorf("iat = %s, exp = %s, want them from the clock", token.IssuedAt(), token.Expiration())
+	}
+
+	advance(5 * time.Minute)
+	if second, _ := ts.Token(); second != first {
+		t.Error("token should be cached before the skew window")
+	}
+	advance(6 * time.Minute)
+	if third, _ := ts.Token(); third == first {
+		t.Error("expired token should be signed again")
+	}
+}
+
+func TestTokenSource_RefreshAfterInvalidate(t *testing.T) {
+	_, pk := newTestKey(t)
+	ts := newTokenSource(&Config{Iss: ISS, Kid: KID, Bid: BID, Pk: pk, ExpiryIn: time.Minute * 10, Aud: "appstoreconnect-v1"})
+	first, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, _ := ts.current.Load().(*cachedToken)
+	ts.Invalidate(first)
+	// 作废后 old 已经不是当前 token，但当前 token 为空，必须重新签名
+	fresh, err := ts.refresh(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fresh == nil || fresh.bearer == "" || fresh.bearer == first {
+		t.Fatalf("refresh after invalidate = %+v, want a new token", fresh)
+	}
+}
+
+func TestTokenSource_ConcurrentInvalidate(t *testing.T) {
+	_, pk := newTestKey(t)
+	ts, err := NewTokenSource(&Config{Iss: ISS, Kid: KID, Bid: BID, Pk: pk, ExpiryIn: time.Minute, RefreshSkew: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wg := sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				token, err := ts.Token()
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if token == "" {
+					t.Error("empty token")
+					return
+				}
+				ts.Invalidate(token)
+			}
+		}()
+	}
+	wg.Wait()
+}
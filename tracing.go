@@ -0,0 +1,63 @@
+package appstoreserverapi
+
+import "context"
+
+// span 名称，每次调用的 span 为 SpanPrefix 加接口名称，例如：appstoreserverapi.GetTransactionHistory
+// Span names, the span of a call is SpanPrefix followed by the endpoint, eg: appstoreserverapi.GetTransactionHistory
+const (
+	SpanPrefix = "appstoreserverapi."
+	// SpanAttempt 每次 HTTP 请求，包括重试
+	// Each HTTP request including retries
+	SpanAttempt = SpanPrefix + "attempt"
+	// SpanVerify 每次签名校验，只在设置了 Config.Verifier 时记录
+	// Each signature verification, only recorded with Config.Verifier set
+	SpanVerify = SpanPrefix + "verify"
+)
+
+// Tracer 链路追踪，默认不记录，oteltrace 子模块提供 OpenTelemetry 实现
+// 每次 Client 调用一个 span，其下每次 HTTP 请求（包括重试）和每次签名校验各一个子 span
+// Tracing, nothing is recorded by default, the oteltrace module provides an OpenTelemetry implementation
+// one span per Client call, with a child span for each HTTP request including retries and for each signature verification
+type Tracer interface {
+	// Start 开始一个 span，ctx 中的 span 为父 span，返回带有新 span 的 ctx
+	// Starts a span, the span in ctx is its parent, returns a ctx carrying the new span
+	Start(ctx context.Context, name string, fields ...Field) (context.Context, Span)
+}
+
+// Span 一个 span
+// A span
+type Span interface {
+	SetAttributes(fields ...Field)
+	// End 结束 span，err 不为空时记录错误
+	// Ends the span, recording err when not nil
+	End(err error)
+}
+
+type nopTracer struct{}
+
+func (nopTracer) Start(ctx context.Context, _ string, _ ...Field) (context.Context, Span) {
+	return ctx, nopSpan{}
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttributes(...Field) {}
+func (nopSpan) End(error)              {}
+
+// tracer 配置的 Tracer，未设置时不记录
+func (cfg *Config) tracer() Tracer {
+	if cfg.Tracer == nil {
+		return nopTracer{}
+	}
+	return cfg.Tracer
+}
+
+// startSpan 开始一次调用的 span，之后的请求和签名校验使用带有该 span 的 o.ctx
+func (c *client) startSpan(o *callOptions, endpoint string) Span {
+	o.ctx, o.span = c.cfg.tracer().Start(o.ctx, SpanPrefix+endpoint,
+		Field{FieldEndpoint, endpoint},
+		Field{FieldEnvironment, string(c.cfg.Evn)},
+		Field{FieldBundleId, c.cfg.Bid},
+	)
+	return o.span
+}
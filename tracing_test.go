@@ -0,0 +1,134 @@
+package appstoreserverapi_test
+
+import (
+	"context"
+	"github.com/lhlyu/appstoreserverapi"
+	"github.com/lhlyu/appstoreserverapi/storetest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type spanKey struct{}
+
+type recordedSpan struct {
+	name   string
+	parent *recordedSpan
+	attrs  map[string]interface{}
+	err    error
+	ended  bool
+}
+
+type recordingTracer struct {
+	lock  sync.Mutex
+	spans []*recordedSpan
+}
+
+func (tr *recordingTracer) Start(ctx context.Context, name string, fields ...appstoreserverapi.Field) (context.Context, appstoreserverapi.Span) {
+	parent, _ := ctx.Value(spanKey{}).(*recordedSpan)
+	span := &recordedSpan{name: name, parent: parent, attrs: make(map[string]interface{})}
+	tr.lock.Lock()
+	tr.spans = append(tr.spans, span)
+	tr.lock.Unlock()
+	s := &recordingSpan{tr: tr, span: span}
+	s.SetAttributes(fields...)
+	return context.WithValue(ctx, spanKey{}, span), s
+}
+
+func (tr *recordingTracer) named(name string) []*recordedSpan {
+	tr.lock.Lock()
+	defer tr.lock.Unlock()
+	spans := make([]*recordedSpan, 0)
+	for _, span := range tr.spans {
+		if span.name == name {
+			spans = append(spans, span)
+		}
+	}
+	return spans
+}
+
+type recordingSpan struct {
+	tr   *recordingTracer
+	span *recordedSpan
+}
+
+func (s *recordingSpan) SetAttributes(fields ...appstoreserverapi.Field) {
+	s.tr.lock.Lock()
+	defer s.tr.lock.Unlock()
+	for _, f := range fields {
+		s.span.attrs[f.Key] = f.Value
+	}
+}
+
+func (s *recordingSpan) End(err error) {
+	s.tr.lock.Lock()
+	defer s.tr.lock.Unlock()
+	s.span.err = err
+	s.span.ended = true
+}
+
+func TestClient_Tracing(t *testing.T) {
+	tracer := &recordingTracer{}
+	s, client := newStoreTestClient(t, func(cfg *appstoreserverapi.Config) {
+		cfg.Tracer = tracer
+	}, storetest.MonthlySubscription("6000", "monthly", time.Now().AddDate(0, -2, 0)).Renew(2))
+
+	ctx, root := tracer.Start(context.Background(), "caller")
+	s.InjectError(storetest.EndpointGetTransactionHistory, 500, 5000001, 1)
+	if _, err := client.ApiGetTransactionHistory("6000", false, appstoreserverapi.WithContext(ctx)); err != nil {
+		t.Fatal(err)
+	}
+	root.End(nil)
+
+	calls := tracer.named("appstoreserverapi.GetTransactionHistory")
+	if len(calls) != 1 {
+		t.Fatalf("call spans = %d, want 1", len(calls))
+	}
+	call := calls[0]
+	if call.parent == nil || call.parent.name != "caller" || !call.ended || call.err != nil {
+		t.Errorf("call span = %+v", call)
+	}
+	if call.attrs[appstoreserverapi.FieldBundleId] != storetest.Bid || call.attrs[appstoreserverapi.FieldStatus] != 200 {
+		t.Errorf("call attributes = %v", call.attrs)
+	}
+
+	attempts := tracer.named(appstoreserverapi.SpanAttempt)
+	if len(attempts) != 2 {
+		t.Fatalf("attempt spans = %d, want 2", len(attempts))
+	}
+	if attempts[0].parent != call || attempts[0].attrs[appstoreserverapi.FieldErrorCode] != 5000001 || attempts[0].err == nil {
+		t.Errorf("first attempt = %+v", attempts[0])
+	}
+	if attempts[1].attrs[appstoreserverapi.FieldStatus] != 200 || attempts[1].err != nil {
+		t.Errorf("second attempt = %+v", attempts[1])
+	}
+
+	verifies := tracer.named(appstoreserverapi.SpanVerify)
+	if len(verifies) != 3 {
+		t.Fatalf("verify spans = %d, want 3", len(verifies))
+	}
+	for _, span := range verifies {
+		if span.parent != call || !span.ended || span.err != nil {
+			t.Errorf("verify span = %+v", span)
+		}
+	}
+}
+
+func TestClient_WithContext(t *testing.T) {
+	_, client := newStoreTestClient(t, func(cfg *appstoreserverapi.Config) {
+		// 令牌桶已经用完，下一次请求需要等待
+		cfg.RateLimiter = appstoreserverapi.NewRateLimiter(1, time.Hour, nil)
+	})
+	client.ApiGetAllSubscriptionStatuses("6000")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.ApiGetAllSubscriptionStatuses("6000", appstoreserverapi.WithContext(ctx))
+	if err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if time.Since(start) > time.Second {
+		t.Error("the rate limit wait should stop with the context")
+	}
+}
@@ -0,0 +1,280 @@
+package appstoreserverapi
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/lestrrat-go/jwx/v2/cert"
+	"github.com/lestrrat-go/jwx/v2/jwa"
+	"github.com/lestrrat-go/jwx/v2/jws"
+	"time"
+)
+
+var (
+	ErrVerifyNoRootCertificates     = errors.New("verifier has no root certificates")
+	ErrVerifyInvalidRootCertificate = errors.New("root certificate is not valid DER or PEM")
+	ErrVerifyInvalidChain           = errors.New("x5c certificate chain invalid")
+	ErrVerifyInvalidSignature       = errors.New("signature invalid")
+	ErrVerifyAppNotConfigured       = errors.New("verifier has no bundle id, set it with WithApp")
+	ErrVerifyAppMismatch            = errors.New("signed data belongs to another app or environment")
+)
+
+// AppMismatchError 签名数据的 bundleId、appAppleId 或 environment 和校验器的设置不一致
+// errors.Is(err, ErrVerifyAppMismatch) 为 true
+// The bundleId, appAppleId or environment of the signed data differs from the verifier's
+// errors.Is(err, ErrVerifyAppMismatch) is true
+type AppMismatchError struct {
+	// 不一致的字段，例如：bundleId
+	// The field that differs, eg: bundleId
+	Field string
+	Want  string
+	Got   string
+}
+
+func (e *AppMismatchError) Error() string {
+	return fmt.Sprintf("%v: %s is %q, want %q", ErrVerifyAppMismatch, e.Field, e.Got, e.Want)
+}
+
+func (e *AppMismatchError) Unwrap() error {
+	return ErrVerifyAppMismatch
+}
+
+var (
+	// Apple 签名证书（leaf）中的扩展 OID
+	// Extension OID of the Apple signing (leaf) certificate
+	oidAppleLeaf = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 11, 1}
+	// Apple WWDR 中间证书中的扩展 OID
+	// Extension OID of the Apple WWDR intermediate certificate
+	oidAppleIntermediate = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 2, 1}
+)
+
+// Verifier 校验 App Store 签名数据（JWS）的 x5c 证书链和签名，用 WithApp 设置应用后还校验数据属于该应用
+// Verifies the x5c certificate chain and the signature of App Store signed data (JWS), and that the data belongs to the app set with WithApp
+// doc: https://developer.apple.com/documentation/appstoreserverapi/jwstransaction
+type Verifier struct {
+	roots *x509.CertPool
+	// 校验证书有效期使用的时钟，为空时使用系统时间
+	clock Clock
+	// 应用：Verify 和 VerifyAppTransaction 比较的 bundleId、appAppleId 和 environment，environment 是 Apple 的写法
+	bundleId    string
+	appAppleId  int64
+	environment string
+}
+
+// NewVerifier 创建校验器
+// rootCerts: 信任的根证书，DER 或者 PEM 格式，例如 Apple Root CA - G3
+// rootCerts: trusted root certificates, DER or PEM, eg: Apple Root CA - G3
+// doc: https://www.apple.com/certificateauthority/
+func NewVerifier(rootCerts ...[]byte) (*Verifier, error) {
+	if len(rootCerts) == 0 {
+		return nil, ErrVerifyNoRootCertificates
+	}
+	roots := x509.NewCertPool()
+	for _, b := range rootCerts {
+		if block, _ := pem.Decode(b); block != nil {
+			b = block.Bytes
+		}
+		c, err := x509.ParseCertificate(b)
+		if err != nil {
+			return nil, ErrVerifyInvalidRootCertificate
+		}
+		roots.AddCert(c)
+	}
+	return &Verifier{roots: roots}, nil
+}
+
+// WithClock 返回使用 clock 校验证书有效期的副本
+// Returns a copy that checks certificate validity with clock
+func (vf *Verifier) WithClock(clock Clock) *Verifier {
+	v := *vf
+	v.clock = clock
+	return &v
+}
+
+// appleEnvironments 各个环境在 Apple 数据中 environment 字段的值
+var appleEnvironments = map[Env]string{
+	Production:   "Production",
+	Development:  "Sandbox",
+	Xcode:        "Xcode",
+	LocalTesting: "LocalTesting",
+}
+
+// WithApp 返回设置了应用的副本，Verify 和 VerifyAppTransaction 只接受该应用的数据
+// bundleId: 必填；appAppleId: 为 0 时不比较；environment: 例如 Production、Sandbox，和数据中 Apple 的写法（"Production"、"Sandbox"）比较，为空时不比较
+// NewClient 会用 Config.Bid 设置未设置应用的 Config.Verifier，不设置 environment：开启沙盒回退时正式环境的客户端也会收到沙盒数据
+// Returns a copy bound to an app, Verify and VerifyAppTransaction only accept data of that app
+// bundleId: required; appAppleId: not compared when 0; environment: eg: Production, Sandbox, compared with Apple's spelling in the data ("Production", "Sandbox"), not compared when empty
+// NewClient sets Config.Bid on a Config.Verifier without an app, without environment: a production client with sandbox fallback also gets sandbox data
+func (vf *Verifier) WithApp(bundleId string, appAppleId int64, environment Env) *Verifier {
+	v := *vf
+	v.bundleId = bundleId
+	v.appAppleId = appAppleId
+	v.environment = string(environment)
+	if apple, ok := appleEnvironments[environment]; ok {
+		v.environment = apple
+	}
+	return &v
+}
+
+func (vf *Verifier) now() time.Time {
+	if vf.clock == nil {
+		return SystemClock.Now()
+	}
+	return vf.clock.Now()
+}
+
+// Verify 校验签名数据，通过后解码到 v
+// 用 WithApp 设置了应用时，数据（通知中为 data 或 summary）中的 bundleId、appAppleId 和 environment 不一致时返回 *AppMismatchError，
+// 数据中没有的字段不比较，例如续订信息没有 bundleId；没有设置应用时调用方需要自己比较
+// Verifies the signed data, then decodes it into v
+// with an app set with WithApp, a bundleId, appAppleId or environment in the data (data or summary of a notification) that differs returns *AppMismatchError,
+// fields missing from the data are not compared, eg: renewal infos have no bundleId; without an app callers must compare them themselves
+func (vf *Verifier) Verify(payload string, v interface{}) error {
+	msg, err := jws.ParseString(payload)
+	if err != nil {
+		return err
+	}
+	if len(msg.Signatures()) != 1 {
+		return ErrVerifyInvalidSignature
+	}
+	headers := msg.Signatures()[0].ProtectedHeaders()
+	if headers.Algorithm() != jwa.ES256 {
+		return ErrVerifyInvalidSignature
+	}
+	leaf, err := vf.verifyChain(headers.X509CertChain(), vf.now())
+	if err != nil {
+		return err
+	}
+	b, err := jws.Verify([]byte(payload), jws.WithKey(jwa.ES256, leaf.PublicKey))
+	if err != nil {
+		return ErrVerifyInvalidSignature
+	}
+	if vf.bundleId != "" {
+		if err := vf.checkApp(b); err != nil {
+			return err
+		}
+	}
+	if v == nil {
+		return nil
+	}
+	return json.Unmarshal(b, v)
+}
+
+// verifyChain 校验 x5c 证书链：leaf、中间证书、根证书
+func (vf *Verifier) verifyChain(chain *cert.Chain, now time.Time) (*x509.Certificate, error) {
+	if chain == nil || chain.Len() != 3 {
+		return nil, ErrVerifyInvalidChain
+	}
+	certs := make([]*x509.Certificate, 0, chain.Len())
+	for i := 0; i < chain.Len(); i++ {
+		enc, _ := chain.Get(i)
+		der, err := base64.StdEncoding.DecodeString(string(enc))
+		if err != nil {
+			return nil, ErrVerifyInvalidChain
+		}
+		c, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, ErrVerifyInvalidChain
+		}
+		certs = append(certs, c)
+	}
+	leaf, intermediate := certs[0], certs[1]
+	if !hasExtension(leaf, oidAppleLeaf) || !hasExtension(intermediate, oidAppleIntermediate) {
+		return nil, ErrVerifyInvalidChain
+	}
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediate)
+	_, err := leaf.Verify(x509.VerifyOptions{
+		Roots:         vf.roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	})
+	if err != nil {
+		return nil, ErrVerifyInvalidChain
+	}
+	return leaf, nil
+}
+
+// appFields 签名数据中标识应用的字段，通知中在 data 或 summary 中
+type appFields struct {
+	BundleId    string     `json:"bundleId"`
+	AppAppleId  int64      `json:"appAppleId"`
+	Environment string     `json:"environment"`
+	ReceiptType string     `json:"receiptType"`
+	Data        *appFields `json:"data"`
+	Summary     *appFields `json:"summary"`
+}
+
+// checkApp 比较数据中存在的 bundleId、appAppleId 和 environment
+func (vf *Verifier) checkApp(b []byte) error {
+	fields := appFields{}
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return err
+	}
+	if fields.Data != nil {
+		fields = *fields.Data
+	} else if fields.Summary != nil {
+		fields = *fields.Summary
+	}
+	if fields.BundleId != "" && fields.BundleId != vf.bundleId {
+		return &AppMismatchError{Field: "bundleId", Want: vf.bundleId, Got: fields.BundleId}
+	}
+	if vf.appAppleId != 0 && fields.AppAppleId != 0 && fields.AppAppleId != vf.appAppleId {
+		return &AppMismatchError{Field: "appAppleId", Want: fmt.Sprint(vf.appAppleId), Got: fmt.Sprint(fields.AppAppleId)}
+	}
+	// 旧版本的 AppTransaction 只有 receiptType
+	environment := fields.Environment
+	if environment == "" {
+		environment = fields.ReceiptType
+	}
+	if vf.environment != "" && environment != "" && environment != vf.environment {
+		return &AppMismatchError{Field: "environment", Want: vf.environment, Got: environment}
+	}
+	return nil
+}
+
+func hasExtension(c *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	for _, ext := range c.Extensions {
+		if ext.Id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifyAppTransaction 校验并解码 StoreKit 2 客户端发送的 AppTransaction
+// 除了证书链和签名，还比较 WithApp 设置的 bundleId、appAppleId 和 environment，不一致时返回 *AppMismatchError
+// Verifies and decodes the signed AppTransaction sent by a StoreKit 2 client
+// besides the chain and signature, the bundleId, appAppleId and environment set with WithApp are compared, a mismatch returns *AppMismatchError
+// doc: https://developer.apple.com/documentation/storekit/apptransaction
+func (vf *Verifier) VerifyAppTransaction(signedAppTransaction string) (*AppTransaction, error) {
+	if vf.bundleId == "" {
+		return nil, ErrVerifyAppNotConfigured
+	}
+	appTransaction := &AppTransaction{}
+	if err := vf.Verify(signedAppTransaction, appTransaction); err != nil {
+		return nil, err
+	}
+	if appTransaction.BundleId != vf.bundleId {
+		return nil, &AppMismatchError{Field: "bundleId", Want: vf.bundleId, Got: appTransaction.BundleId}
+	}
+	if vf.appAppleId != 0 && appTransaction.AppAppleId != vf.appAppleId {
+		return nil, &AppMismatchError{Field: "appAppleId", Want: fmt.Sprint(vf.appAppleId), Got: fmt.Sprint(appTransaction.AppAppleId)}
+	}
+	if vf.environment != "" {
+		// 旧版本的 AppTransaction 只有 receiptType
+		environment := appTransaction.Environment
+		if environment == "" {
+			environment = appTransaction.ReceiptType
+		}
+		if environment != vf.environment {
+			return nil, &AppMismatchError{Field: "environment", Want: vf.environment, Got: environment}
+		}
+	}
+	return appTransaction, nil
+}
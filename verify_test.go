@@ -0,0 +1,111 @@
+package appstoreserverapi_test
+
+import (
+	"errors"
+	"github.com/lhlyu/appstoreserverapi"
+	"github.com/lhlyu/appstoreserverapi/storetest"
+	"testing"
+)
+
+func TestVerifier_VerifyAppTransaction(t *testing.T) {
+	chain, err := storetest.NewCertificateChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := chain.Sign(appstoreserverapi.AppTransaction{
+		BundleId:    "com.example.app",
+		AppAppleId:  1234,
+		Environment: "Sandbox",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := chain.Verifier().VerifyAppTransaction(signed); err != appstoreserverapi.ErrVerifyAppNotConfigured {
+		t.Errorf("without app: err = %v, want %v", err, appstoreserverapi.ErrVerifyAppNotConfigured)
+	}
+	app, err := chain.Verifier().WithApp("com.example.app", 1234, appstoreserverapi.Sandbox).VerifyAppTransaction(signed)
+	if err != nil || app.AppAppleId != 1234 {
+		t.Fatalf("app = %+v, err = %v", app, err)
+	}
+
+	mismatches := []struct {
+		verifier *appstoreserverapi.Verifier
+		field    string
+	}{
+		{chain.Verifier().WithApp("com.example.other", 0, ""), "bundleId"},
+		{chain.Verifier().WithApp("com.example.app", 5678, ""), "appAppleId"},
+		{chain.Verifier().WithApp("com.example.app", 0, appstoreserverapi.Production), "environment"},
+	}
+	for _, m := range mismatches {
+		_, err := m.verifier.VerifyAppTransaction(signed)
+		mismatch := &appstoreserverapi.AppMismatchError{}
+		if !errors.Is(err, appstoreserverapi.ErrVerifyAppMismatch) || !errors.As(err, &mismatch) || mismatch.Field != m.field {
+			t.Errorf("%s: err = %v", m.field, err)
+		}
+		// Env 按 Apple 的写法比较
+		if m.field == "environment" && mismatch.Want != "Production" {
+			t.Errorf("environment: want = %q, want Apple's spelling %q", mismatch.Want, "Production")
+		}
+	}
+
+	// 另一条证书链的根证书不受信任
+	other, err := storetest.NewCertificateChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Verifier().WithApp("com.example.app", 0, "").VerifyAppTransaction(signed); err != appstoreserverapi.ErrVerifyInvalidChain {
+		t.Errorf("untrusted root: err = %v, want %v", err, appstoreserverapi.ErrVerifyInvalidChain)
+	}
+}
+
+func TestVerifier_VerifyApp(t *testing.T) {
+	chain, err := storetest.NewCertificateChain()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(v interface{}) string {
+		signed, err := chain.Sign(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+	tx := sign(appstoreserverapi.JWSTransactionDecodedPayload{BundleId: "com.example.app", Environment: "Sandbox"})
+	notification := sign(map[string]interface{}{
+		"notificationType": appstoreserverapi.NotificationTypeDidRenew,
+		"data":             map[string]interface{}{"bundleId": "com.example.app", "appAppleId": 1234, "environment": "Sandbox"},
+	})
+	// 续订信息没有 bundleId
+	renewal := sign(appstoreserverapi.JWSRenewalInfoDecodedPayload{OriginalTransactionId: "1", Environment: "Sandbox"})
+
+	app := chain.Verifier().WithApp("com.example.app", 1234, appstoreserverapi.Sandbox)
+	for _, signed := range []string{tx, notification, renewal} {
+		if err := app.Verify(signed, nil); err != nil {
+			t.Errorf("same app: err = %v", err)
+		}
+	}
+	// 没有设置应用时只校验证书链和签名
+	if err := chain.Verifier().Verify(tx, nil); err != nil {
+		t.Errorf("without app: err = %v", err)
+	}
+
+	mismatches := []struct {
+		verifier *appstoreserverapi.Verifier
+		signed   string
+		field    string
+	}{
+		{chain.Verifier().WithApp("com.example.other", 0, ""), tx, "bundleId"},
+		{chain.Verifier().WithApp("com.example.app", 0, appstoreserverapi.Production), tx, "environment"},
+		{chain.Verifier().WithApp("com.example.other", 0, ""), notification, "bundleId"},
+		{chain.Verifier().WithApp("com.example.app", 5678, ""), notification, "appAppleId"},
+		{chain.Verifier().WithApp("com.example.app", 0, appstoreserverapi.Production), renewal, "environment"},
+	}
+	for _, m := range mismatches {
+		err := m.verifier.Verify(m.signed, nil)
+		mismatch := &appstoreserverapi.AppMismatchError{}
+		if !errors.As(err, &mismatch) || mismatch.Field != m.field {
+			t.Errorf("%s: err = %v", m.field, err)
+		}
+	}
+}